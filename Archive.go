@@ -0,0 +1,643 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/klauspost/compress/zstd"
+)
+
+//归档文件作为源端或目标端: 本地的tar/zip文件，按扩展名识别，流式读写，不需要先解压到本地
+//a2o, a2f: 源端为归档文件; o2a, f2a: 目标端为归档文件
+//归档中的条目转换为FileInfo, 属性(uid/gid/mode/mtime/symlink)与其他模式一样处理
+var archiveSuffixes = []struct {
+	suffix string
+	format string
+}{
+	{".tar.gz", "tar.gz"},
+	{".tgz", "tar.gz"},
+	{".tar.zst", "tar.zst"},
+	{".tzst", "tar.zst"},
+	{".tar", "tar"},
+	{".zip", "zip"},
+}
+
+//zip中保存uid/gid的Info-ZIP扩展字段
+const zipUnixExtraID = 0x7875
+
+//归档中的一个条目，Body只在回调函数中有效
+type ArchiveEntry struct {
+	Info FileInfo
+	Body io.Reader
+}
+
+func archiveFormat(name string) string {
+	if strings.HasPrefix(strings.ToLower(name), "s3://") {
+		return ""
+	}
+	for _, s := range archiveSuffixes {
+		if strings.HasSuffix(strings.ToLower(name), s.suffix) {
+			return s.format
+		}
+	}
+	return ""
+}
+
+func isArchiveMode() bool {
+	return strings.HasPrefix(mode, "a2") || strings.HasSuffix(mode, "2a")
+}
+
+//归档中的路径统一为相对路径，目录以/结尾
+func archiveName(name string, isDir bool) string {
+	name = path.Clean("/" + name)[1:]
+	if isDir && name != "" {
+		name = name + "/"
+	}
+	return name
+}
+
+func permString(mode int64) string {
+	return strconv.FormatInt(mode&0777, 8)
+}
+
+func archiveFileInfo(name string, fType string, perm int64, uid int, gid int, user string, group string, mTime time.Time, aTime time.Time, size int64) FileInfo {
+	if aTime.IsZero() {
+		aTime = mTime
+	}
+	return FileInfo{IsMetaExist: withAttr, Filename: name, FUserAgent: "admt", FUID: uid, FGID: gid, FUser: user, FGroup: group, FType: fType, FPerm: permString(perm), FaTime: aTime.Unix(), FmTime: mTime.Unix(), FaTimeNs: int64(aTime.Nanosecond()), FmTimeNs: int64(mTime.Nanosecond()), FNsec: true, FSize: size}
+}
+
+//顺序读取归档，每个条目调用一次fn
+func ReadArchive(archivePath string, format string, fn func(ArchiveEntry)) error {
+	if format == "zip" {
+		return readZip(archivePath, fn)
+	}
+	fd, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	var r io.Reader = bufio.NewReaderSize(fd, 1024*1024)
+	switch format {
+	case "tar.gz":
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		r = gr
+	case "tar.zst":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	return readTar(r, fn)
+}
+
+func readTar(r io.Reader, fn func(ArchiveEntry)) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var fType string
+		var body io.Reader = tr
+		size := hdr.Size
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			fType = "0040"
+		case tar.TypeSymlink:
+			fType = "0120"
+			body = strings.NewReader(hdr.Linkname) //和其他模式一样，symlink的内容为指向的路径
+			size = int64(len(hdr.Linkname))
+		case tar.TypeReg, tar.TypeRegA:
+			fType = "0100"
+		default:
+			log.Println("Skip unsupported archive entry:", hdr.Name, string(hdr.Typeflag))
+			continue
+		}
+		name := archiveName(hdr.Name, fType == "0040")
+		if name == "" {
+			continue
+		}
+		info := archiveFileInfo(name, fType, hdr.Mode, hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname, hdr.ModTime, hdr.AccessTime, size)
+		for k, v := range hdr.PAXRecords {
+			if strings.HasPrefix(k, "SCHILY.xattr.") {
+				if info.FXattr == nil {
+					info.FXattr = map[string][]byte{}
+				}
+				info.FXattr[strings.TrimPrefix(k, "SCHILY.xattr.")] = []byte(v)
+			}
+		}
+		if fType != "0120" {
+			info.FXattr, info.FUserMeta = splitUserMetaXattr(info.FXattr)
+		}
+		fn(ArchiveEntry{Info: info, Body: body})
+	}
+}
+
+func readZip(archivePath string, fn func(ArchiveEntry)) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		mode := f.Mode()
+		var fType string
+		switch {
+		case mode.IsDir():
+			fType = "0040"
+		case mode&os.ModeSymlink != 0:
+			fType = "0120"
+		case mode.IsRegular():
+			fType = "0100"
+		default:
+			log.Println("Skip unsupported archive entry:", f.Name, mode)
+			continue
+		}
+		name := archiveName(f.Name, fType == "0040")
+		if name == "" {
+			continue
+		}
+		uid, gid := zipOwner(f.Extra)
+		info := archiveFileInfo(name, fType, int64(mode.Perm()), uid, gid, "", "", f.Modified, time.Time{}, int64(f.UncompressedSize64))
+		rc, err := f.Open()
+		if err != nil {
+			log.Println("Failed to read archive entry:", f.Name, err)
+			continue
+		}
+		fn(ArchiveEntry{Info: info, Body: rc})
+		rc.Close()
+	}
+	return nil
+}
+
+//解析Info-ZIP的unix扩展字段，没有时使用-u -g指定的默认值
+func zipOwner(extra []byte) (int, int) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if len(extra) < 4+size {
+			break
+		}
+		field := extra[4 : 4+size]
+		extra = extra[4+size:]
+		if id != zipUnixExtraID || len(field) < 1 || field[0] != 1 {
+			continue
+		}
+		field = field[1:]
+		uid, field, ok := zipReadID(field)
+		if !ok {
+			break
+		}
+		gid, _, ok := zipReadID(field)
+		if !ok {
+			break
+		}
+		return uid, gid
+	}
+	return defaultFileMode.UID, defaultFileMode.GID
+}
+
+func zipReadID(field []byte) (int, []byte, bool) {
+	if len(field) < 1 {
+		return 0, nil, false
+	}
+	n := int(field[0])
+	if len(field) < 1+n || n > 8 {
+		return 0, nil, false
+	}
+	var id uint64
+	for i := n; i >= 1; i-- {
+		id = id<<8 | uint64(field[i])
+	}
+	return int(id), field[1+n:], true
+}
+
+func zipOwnerExtra(uid int, gid int) []byte {
+	extra := make([]byte, 15)
+	binary.LittleEndian.PutUint16(extra[0:2], zipUnixExtraID)
+	binary.LittleEndian.PutUint16(extra[2:4], 11)
+	extra[4], extra[5], extra[10] = 1, 4, 4 //version, uid长度, gid长度
+	binary.LittleEndian.PutUint32(extra[6:10], uint32(uid))
+	binary.LittleEndian.PutUint32(extra[11:15], uint32(gid))
+	return extra
+}
+
+//写入归档，多个goroutine可以同时调用Add, 条目按调用顺序写入
+type ArchiveWriter struct {
+	mu      sync.Mutex
+	fd      *os.File
+	bw      *bufio.Writer
+	closers []io.Closer //按顺序关闭
+	tw      *tar.Writer
+	zw      *zip.Writer
+}
+
+func NewArchiveWriter(archivePath string, format string) *ArchiveWriter {
+	fd, err := os.Create(archivePath)
+	if err != nil {
+		log.Fatalln("Failed to create archive:", archivePath, err)
+	}
+	w := &ArchiveWriter{fd: fd, bw: bufio.NewWriterSize(fd, 1024*1024)}
+	var out io.Writer = w.bw
+	switch format {
+	case "zip":
+		w.zw = zip.NewWriter(out)
+		w.closers = append(w.closers, w.zw)
+		return w
+	case "tar.gz":
+		gw := gzip.NewWriter(out)
+		w.closers = append(w.closers, gw)
+		out = gw
+	case "tar.zst":
+		zw, err := zstd.NewWriter(out)
+		if err != nil {
+			log.Fatalln("Failed to create archive:", archivePath, err)
+		}
+		w.closers = append(w.closers, zw)
+		out = zw
+	}
+	w.tw = tar.NewWriter(out)
+	w.closers = append([]io.Closer{w.tw}, w.closers...)
+	return w
+}
+
+//没有属性的条目(没有-a true)使用-u -g -m指定的默认属性
+func archiveAttr(info FileInfo) (int64, int, int, time.Time, time.Time) {
+	mTime := time.Unix(info.FmTime, info.FmTimeNs)
+	aTime := time.Unix(info.FaTime, info.FaTimeNs)
+	if !info.IsMetaExist {
+		perm, _ := strconv.ParseInt(defaultFileMode.Mode, 8, 64)
+		return perm, defaultFileMode.UID, defaultFileMode.GID, mTime, aTime
+	}
+	perm, _ := strconv.ParseInt(info.FPerm, 8, 64)
+	return perm, idMap.MapUID(info), idMap.MapGID(info), mTime, aTime
+}
+
+//body为文件内容或symlink指向的路径，size为body的大小
+func (w *ArchiveWriter) Add(info FileInfo, body io.Reader, size int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	perm, uid, gid, mTime, aTime := archiveAttr(info)
+	name := RenamePath(info)
+
+	if w.zw != nil {
+		hdr := &zip.FileHeader{Name: name, Modified: mTime, Method: zip.Deflate, Extra: zipOwnerExtra(uid, gid)}
+		switch info.FType {
+		case "0040":
+			hdr.Method = zip.Store
+			hdr.SetMode(os.ModeDir | os.FileMode(perm))
+		case "0120":
+			hdr.Method = zip.Store
+			hdr.SetMode(os.ModeSymlink | os.FileMode(perm))
+		default:
+			hdr.SetMode(os.FileMode(perm))
+		}
+		fw, err := w.zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if info.FType == "0040" {
+			return nil
+		}
+		_, err = io.Copy(fw, body)
+		return err
+	}
+
+	hdr := &tar.Header{Name: name, Mode: perm, Uid: uid, Gid: gid, ModTime: mTime, AccessTime: aTime, Format: tar.FormatPAX}
+	if info.IsMetaExist {
+		hdr.Uname, hdr.Gname = info.FUser, info.FGroup
+		xattrs := info.FXattr
+		if len(info.FUserMeta) > 0 {
+			xattrs = map[string][]byte{}
+			for k, v := range info.FXattr {
+				xattrs[k] = v
+			}
+			for k, v := range userMetaToXattr(info.FUserMeta) {
+				xattrs[k] = v
+			}
+		}
+		for k, v := range xattrs {
+			if hdr.PAXRecords == nil {
+				hdr.PAXRecords = map[string]string{}
+			}
+			hdr.PAXRecords["SCHILY.xattr."+k] = string(v)
+		}
+	}
+	switch info.FType {
+	case "0040":
+		hdr.Typeflag = tar.TypeDir
+	case "0120":
+		target, err := io.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = string(target)
+	default:
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = size
+	}
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if hdr.Typeflag != tar.TypeReg {
+		return nil
+	}
+	n, err := io.CopyN(w.tw, body, size)
+	if err != nil {
+		io.CopyN(w.tw, zeroReader{}, size-n) //补齐header中的大小，保证归档的格式正确
+		return err
+	}
+	return nil
+}
+
+func (w *ArchiveWriter) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, c := range w.closers {
+		if err := c.Close(); err != nil {
+			log.Println("Failed to close archive:", err)
+		}
+	}
+	if err := w.bw.Flush(); err != nil {
+		log.Println("Failed to write archive:", err)
+	}
+	if err := w.fd.Close(); err != nil {
+		log.Println("Failed to write archive:", err)
+	}
+}
+
+//归档中的条目上传到s3, 和F2O一样处理属性、header、压缩和加密
+func A2O_Copy(client *s3.Client, entry ArchiveEntry, dstBucket string, dstPrefix string, storageClass string, partSize int64) {
+	info := entry.Info
+	if info.FType == "0040" {
+		F2O_DirCopy(client, info, "", dstBucket, dstPrefix)
+		return
+	}
+	uploader := manager.NewUploader(client, func(u *manager.Uploader) {
+		u.PartSize = partSize * 1024 * 1024
+	})
+	UploadS3(uploader, entry.Body, dstBucket, dstJoin(dstPrefix, info), storageClass, info)
+	fmt.Println("Copy:", info.Filename)
+}
+
+//解压时防止条目写到dstPath之外: 例如先有符号链接x -> /etc, 再有文件x/passwd
+//条目的上级目录不能是这次从归档中创建的符号链接，已经存在的上级目录解析符号链接后也必须在dstPath之内
+//worker并发解压，创建符号链接时持有写锁，检查和创建文件、目录时持有读锁，检查之后上级目录不会再变成符号链接
+type extractGuard struct {
+	mu       sync.RWMutex
+	root     string
+	realRoot string
+	links    map[string]bool
+}
+
+var a2fGuard *extractGuard
+
+func newExtractGuard(root string) *extractGuard {
+	root = filepath.Clean(root)
+	os.MkdirAll(root, 0755)
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		log.Fatalln("Invalid destination:", err)
+	}
+	return &extractGuard{root: root, realRoot: realRoot, links: map[string]bool{}}
+}
+
+func (g *extractGuard) check(fpath string) error {
+	fpath = filepath.Clean(fpath)
+	rel, err := filepath.Rel(g.root, fpath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return fmt.Errorf("path is outside of %s", g.root)
+	}
+	if rel == "." {
+		return nil
+	}
+	existing := ""
+	for dir := filepath.Dir(fpath); dir != g.root; dir = filepath.Dir(dir) {
+		if g.links[dir] {
+			return fmt.Errorf("parent %s is a symlink from the archive", dir)
+		}
+		if existing == "" {
+			if _, err := os.Lstat(dir); err == nil {
+				existing = dir
+			}
+		}
+	}
+	if existing == "" {
+		return nil
+	}
+	real, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return err
+	}
+	if real != g.realRoot && !strings.HasPrefix(real, g.realRoot+"/") {
+		return fmt.Errorf("parent %s resolves outside of %s", existing, g.root)
+	}
+	return nil
+}
+
+//归档中的条目解压到本地
+func A2F_Copy(entry ArchiveEntry, dstPath string, defaultFileMode Filemod) {
+	info := entry.Info
+	fdstPath := dstJoin(dstPath, info)
+	if info.FType == "0040" {
+		a2fGuard.mu.RLock()
+		err := a2fGuard.check(fdstPath)
+		if err == nil {
+			if stat, lerr := os.Lstat(fdstPath); lerr == nil && stat.Mode()&os.ModeSymlink != 0 {
+				os.Remove(fdstPath) //目录代替同名的符号链接，不能跟随符号链接修改属性
+			}
+			err = os.MkdirAll(fdstPath, 0755)
+		}
+		a2fGuard.mu.RUnlock()
+		if err != nil {
+			log.Println("Unsafe or failed archive entry:", info.Filename, err)
+			return
+		}
+		Chattr(info, fdstPath, false, defaultFileMode)
+		fmt.Println("Copy:", info.Filename)
+		return
+	}
+	if info.FType == "0120" {
+		target, _ := io.ReadAll(entry.Body)
+		a2fGuard.mu.Lock()
+		err := a2fGuard.check(fdstPath)
+		if err == nil {
+			os.MkdirAll(filepath.Dir(fdstPath), 0775)
+			if _, lerr := os.Lstat(fdstPath); lerr == nil {
+				os.Remove(fdstPath)
+			}
+			err = os.Symlink(string(target), fdstPath)
+			if err == nil {
+				a2fGuard.links[filepath.Clean(fdstPath)] = true
+			}
+		}
+		a2fGuard.mu.Unlock()
+		if err != nil {
+			log.Println("Symbol link failed to create:", info.Filename, err)
+			return
+		}
+		fmt.Println("Copy:", info.Filename)
+		return
+	}
+	a2fGuard.mu.RLock()
+	err := a2fGuard.check(fdstPath)
+	var fd *os.File
+	if err == nil {
+		os.MkdirAll(filepath.Dir(fdstPath), 0775)
+		if stat, lerr := os.Lstat(fdstPath); lerr == nil && stat.Mode()&os.ModeSymlink != 0 {
+			os.Remove(fdstPath) //不跟随同名的符号链接写入
+		}
+		fd, err = os.OpenFile(fdstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, 0644)
+	}
+	a2fGuard.mu.RUnlock()
+	if err != nil {
+		log.Println("Unsafe or failed archive entry:", info.Filename, err)
+		return
+	}
+	if _, err := io.Copy(fd, entry.Body); err != nil {
+		log.Printf("Error %s ocurred in copy %s :\n", err, info.Filename)
+	}
+	fd.Close()
+	Chattr(info, fdstPath, true, defaultFileMode)
+	fmt.Println("Copy:", info.Filename)
+}
+
+//下载对象到临时文件后写入归档，下载可以并发，写入归档是串行的
+func O2A_Copy(client *s3.Client, info FileInfo, srcBucket string, srcPrefix string, archive *ArchiveWriter, partSize int64) {
+	if info.FType == "0040" {
+		if err := archive.Add(info, nil, 0); err != nil {
+			log.Println("Failed to archive:", info.Filename, err)
+		}
+		fmt.Println("Copy:", info.Filename)
+		return
+	}
+	downloader := manager.NewDownloader(client, func(u *manager.Downloader) {
+		u.PartSize = partSize * 1024 * 1024
+	})
+	tmpfile := CreateTempFile(dataDir, filepath.Base(info.Filename))
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+	var err error
+	if info.FPack != nil {
+		err = DownloadPacked(client, tmpfile, srcBucket, srcPrefix, info, srcSSE)
+	} else if info.FType == "0120" {
+		err = DownloadS3(downloader, tmpfile, srcBucket, pathJoin(srcPrefix, info.Filename), info.FVersion, srcSSE)
+	} else {
+		err = DownloadS3Decode(downloader, tmpfile, srcBucket, pathJoin(srcPrefix, info.Filename), info.FVersion, srcSSE)
+	}
+	if err != nil { //不完整的内容不写入归档
+		return
+	}
+	archiveTempFile(info, tmpfile, archive)
+}
+
+func F2A_Copy(info FileInfo, srcPath string, archive *ArchiveWriter) {
+	fsrcPath := pathJoin(srcPath, info.Filename)
+	var err error
+	switch info.FType {
+	case "0040":
+		err = archive.Add(info, nil, 0)
+	case "0120":
+		target, _ := os.Readlink(fsrcPath)
+		err = archive.Add(info, strings.NewReader(target), int64(len(target)))
+	default:
+		fd, openErr := os.Open(fsrcPath)
+		if openErr != nil {
+			log.Println("Failed opening file", fsrcPath, openErr)
+			return
+		}
+		defer fd.Close()
+		err = archive.Add(info, fd, info.FSize)
+	}
+	if err != nil {
+		log.Println("Failed to archive:", info.Filename, err)
+		return
+	}
+	fmt.Println("Copy:", info.Filename)
+}
+
+func archiveTempFile(info FileInfo, tmpfile *os.File, archive *ArchiveWriter) {
+	stat, err := tmpfile.Stat()
+	if err == nil {
+		_, err = tmpfile.Seek(0, io.SeekStart)
+	}
+	if err == nil {
+		err = archive.Add(info, tmpfile, stat.Size())
+	}
+	if err != nil {
+		log.Println("Failed to archive:", info.Filename, err)
+		return
+	}
+	fmt.Println("Copy:", info.Filename)
+}
+
+//小的条目读到内存中交给worker并发上传，大的条目直接在读取的goroutine中流式上传
+func DispatchArchive(archivePath string, format string, entries chan<- ArchiveEntry, copyInline func(ArchiveEntry)) {
+	limit := partSize * 1024 * 1024
+	err := ReadArchive(archivePath, format, func(entry ArchiveEntry) {
+		if entry.Info.FType == "0100" && entry.Info.FSize > limit {
+			copyInline(entry)
+			return
+		}
+		data, err := io.ReadAll(entry.Body)
+		if err != nil {
+			log.Println("Failed to read archive entry:", entry.Info.Filename, err)
+			return
+		}
+		entry.Body = bytes.NewReader(data)
+		entries <- entry
+	})
+	if err != nil {
+		log.Fatalln("Failed to read archive:", archivePath, err)
+	}
+}
+
+//a2f和f2a不需要访问s3
+func archiveClient() *s3.Client {
+	if mode == "a2o" || mode == "o2a" {
+		return CreateS3Client(region)
+	}
+	return nil
+}
+
+func CopyArchiveEntry(client *s3.Client, entry ArchiveEntry) {
+	if skipName(entry.Info) {
+		return
+	}
+	if mode == "a2o" {
+		A2O_Copy(client, entry, dstBucket, dstPrefix, SelectStorageClass(entry.Info), partSize)
+	} else {
+		A2F_Copy(entry, dstPath, defaultFileMode)
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"strconv"
+)
+
+//客户端加密: 每个对象随机生成256位的data key，用-cse-key指定的master key通过AES-256-GCM加密后保存在metadata里
+//对象内容按cseChunkSize分块，每块单独用AES-256-GCM加密，这样可以流式上传，支持分段上传
+//第i块的nonce为iv的最后8字节异或i，最后一块的AAD为cseFinalAAD，防止对象被截断
+const (
+	cseAlgMetaKey  = "admt-cse-alg"
+	cseKeyMetaKey  = "admt-cse-key"  //base64(nonce+被master key加密后的data key)
+	cseIVMetaKey   = "admt-cse-iv"   //base64(12字节iv)
+	cseSizeMetaKey = "admt-cse-size" //明文大小，attr检查时比较明文大小
+	cseAlg         = "AES-256-GCM-64K"
+	cseChunkSize   = 64 * 1024
+	cseTagSize     = 16
+)
+
+var cseFinalAAD = []byte("final")
+
+//加密参数，为nil代表对象没有加密
+type CSEParams struct {
+	DataKey []byte
+	IV      []byte
+}
+
+func LoadCSEKey(keyFile string) []byte {
+	return loadAESKey(keyFile, "client-side encryption master key")
+}
+
+func newGCM(key []byte) cipher.AEAD {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		log.Fatalln("Invalid AES key:", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		log.Fatalln("Invalid AES key:", err)
+	}
+	return gcm
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalln("Failed to generate random bytes:", err)
+	}
+	return b
+}
+
+//生成新的data key，并把加密参数写入metadata
+func NewCSEParams(metadata map[string]string, plainSize int64) *CSEParams {
+	params := &CSEParams{DataKey: randomBytes(32), IV: randomBytes(12)}
+	gcm := newGCM(cseKey)
+	nonce := randomBytes(gcm.NonceSize())
+	wrapped := gcm.Seal(nonce, nonce, params.DataKey, []byte(cseAlg))
+
+	metadata[cseAlgMetaKey] = cseAlg
+	metadata[cseKeyMetaKey] = base64.StdEncoding.EncodeToString(wrapped)
+	metadata[cseIVMetaKey] = base64.StdEncoding.EncodeToString(params.IV)
+	metadata[cseSizeMetaKey] = strconv.FormatInt(plainSize, 10)
+	return params
+}
+
+//从metadata里解出data key，没有加密时返回nil
+func ParseCSEParams(metadata map[string]string) (*CSEParams, error) {
+	if metadata[cseAlgMetaKey] == "" {
+		return nil, nil
+	}
+	if metadata[cseAlgMetaKey] != cseAlg {
+		return nil, errors.New("unsupported client-side encryption algorithm " + metadata[cseAlgMetaKey])
+	}
+	if cseKey == nil {
+		return nil, errors.New("object is client-side encrypted, please specify -cse-key")
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(metadata[cseKeyMetaKey])
+	if err != nil {
+		return nil, err
+	}
+	iv, err := base64.StdEncoding.DecodeString(metadata[cseIVMetaKey])
+	if err != nil || len(iv) != 12 {
+		return nil, errors.New("invalid client-side encryption iv")
+	}
+	gcm := newGCM(cseKey)
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, errors.New("invalid client-side encryption key")
+	}
+	dataKey, err := gcm.Open(nil, wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():], []byte(cseAlg))
+	if err != nil {
+		return nil, errors.New("failed to unwrap data key, wrong -cse-key?")
+	}
+	return &CSEParams{DataKey: dataKey, IV: iv}, nil
+}
+
+func isCSEMetaKey(k string) bool {
+	return k == cseAlgMetaKey || k == cseKeyMetaKey || k == cseIVMetaKey || k == cseSizeMetaKey
+}
+
+func chunkNonce(iv []byte, index uint64) []byte {
+	nonce := make([]byte, len(iv))
+	copy(nonce, iv)
+	counter := binary.BigEndian.Uint64(nonce[4:]) ^ index
+	binary.BigEndian.PutUint64(nonce[4:], counter)
+	return nonce
+}
+
+//返回一个加密后内容的Reader，用于上传，上传结束后需要Close，防止上传失败时加密的goroutine阻塞
+func NewEncryptReader(r io.Reader, params *CSEParams) *io.PipeReader {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(encryptStream(r, pw, params))
+	}()
+	return pr
+}
+
+func encryptStream(r io.Reader, w io.Writer, params *CSEParams) error {
+	gcm := newGCM(params.DataKey)
+	br := bufio.NewReaderSize(r, cseChunkSize)
+	buf := make([]byte, cseChunkSize)
+	var index uint64
+	for {
+		n, err := io.ReadFull(br, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		_, peekErr := br.Peek(1) //读不到下一个字节时，当前块为最后一块
+		final := peekErr != nil
+		var aad []byte
+		if final {
+			aad = cseFinalAAD
+		}
+		if _, err := w.Write(gcm.Seal(nil, chunkNonce(params.IV, index), buf[:n], aad)); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+		index++
+	}
+}
+
+func decryptStream(r io.Reader, w io.Writer, params *CSEParams) error {
+	gcm := newGCM(params.DataKey)
+	br := bufio.NewReaderSize(r, cseChunkSize+cseTagSize)
+	buf := make([]byte, cseChunkSize+cseTagSize)
+	var index uint64
+	for {
+		n, err := io.ReadFull(br, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		_, peekErr := br.Peek(1)
+		final := peekErr != nil
+		var aad []byte
+		if final {
+			aad = cseFinalAAD
+		}
+		plain, err := gcm.Open(nil, chunkNonce(params.IV, index), buf[:n], aad)
+		if err != nil {
+			return errors.New("client-side decryption failed, object is corrupted or truncated")
+		}
+		if _, err := w.Write(plain); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+		index++
+	}
+}
@@ -5,92 +5,127 @@ package main
 
 import (
 	"log"
-	"path/filepath"
 
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
+//按模式列出两端放入SrcCheck和DstCheck, incr为true时跳过上次已经检查通过的文件
+func (f FileWalk) GetCheck(incr bool) {
+	var client *s3.Client
+	if mode != "f2f" {
+		client = CreateS3Client(region)
+	}
+	if filesFrom != "" {
+		f.ManifestCheck(client, filesFrom, incr)
+		return
+	}
+	if incr {
+		switch mode {
+		case "f2o":
+			f.F2O_GetIncrCheck(client, srcPath, dstBucket, dstPrefix)
+		case "f2f":
+			f.F2F_GetIncrCheck(srcPath, dstPath)
+		case "o2f":
+			f.O2F_GetIncrCheck(client, srcBucket, srcPrefix, dstPath)
+		case "o2o":
+			f.O2O_GetIncrCheck(client, srcBucket, srcPrefix, dstBucket, dstPrefix)
+		}
+		return
+	}
+	switch mode {
+	case "f2o":
+		f.F2O_GetCheck(client, srcPath, dstBucket, dstPrefix)
+	case "f2f":
+		f.F2F_GetCheck(srcPath, dstPath)
+	case "o2f":
+		f.O2F_GetCheck(client, srcBucket, srcPrefix, dstPath)
+	case "o2o":
+		f.O2O_GetCheck(client, srcBucket, srcPrefix, dstBucket, dstPrefix)
+	}
+}
+
 func (f FileWalk) F2O_GetCheck(client *s3.Client, srcPath string, dstBucket string, dstPrefix string) {
-	if err := filepath.Walk(srcPath, f.WalkforSrcCheck); err != nil {
+	if err := ParallelWalk(srcPath, f.WalkforSrcCheck); err != nil {
 		log.Fatalln("Walk failed:", err)
 	}
 	close(f.FileList)
 
 	f.ListobjforDstCheck(client, dstBucket, dstPrefix)
-
-	CheckAttr(&f.SrcCheckMap, &f.DstCheckMap, &f.ResultMap)
 }
 
 func (f FileWalk) F2F_GetCheck(srcPath string, dstPath string) {
-	if err := filepath.Walk(srcPath, f.WalkforSrcCheck); err != nil {
+	if err := ParallelWalk(srcPath, f.WalkforSrcCheck); err != nil {
 		log.Fatalln("Walk failed:", err)
 	}
 	close(f.FileList)
 
-	if err := filepath.Walk(dstPath, f.WalkforDstCheck); err != nil {
+	if err := ParallelWalk(dstPath, f.WalkforDstCheck); err != nil {
 		log.Fatalln("Walk failed:", err)
 	}
-
-	CheckAttr(&f.SrcCheckMap, &f.DstCheckMap, &f.ResultMap)
 }
 
 func (f FileWalk) O2F_GetCheck(client *s3.Client, srcBucket string, srcPrefix string, dstPath string) {
-	f.ListobjforSrcCheck(client, srcBucket, srcPrefix)
+	if srcInventory != "" {
+		f.InventoryforSrcCheck(client, srcInventory, false)
+	} else {
+		f.ListobjforSrcCheck(client, srcBucket, srcPrefix)
+	}
 
-	if err := filepath.Walk(dstPath, f.WalkforDstCheck); err != nil {
+	if err := ParallelWalk(dstPath, f.WalkforDstCheck); err != nil {
 		log.Fatalln("Walk failed:", err)
 	}
 	close(f.FileList)
-
-	CheckAttr(&f.SrcCheckMap, &f.DstCheckMap, &f.ResultMap)
 }
 
 func (f FileWalk) O2O_GetCheck(client *s3.Client, srcBucket string, srcPrefix string, dstBucket string, dstPrefix string) {
-	f.ListobjforSrcCheck(client, srcBucket, srcPrefix)
+	if srcInventory != "" {
+		f.InventoryforSrcCheck(client, srcInventory, false)
+	} else {
+		f.ListobjforSrcCheck(client, srcBucket, srcPrefix)
+	}
 	f.ListobjforDstCheck(client, dstBucket, dstPrefix)
 	close(f.FileList)
-
-	CheckAttr(&f.SrcCheckMap, &f.DstCheckMap, &f.ResultMap)
 }
 
 func (f FileWalk) F2O_GetIncrCheck(client *s3.Client, srcPath string, dstBucket string, dstPrefix string) {
-	if err := filepath.Walk(srcPath, f.WalkforSrcIncrCheck); err != nil {
+	if err := ParallelWalk(srcPath, f.WalkforSrcIncrCheck); err != nil {
 		log.Fatalln("Walk failed:", err)
 	}
 	close(f.FileList)
 
 	f.ListobjforDstIncrCheck(client, dstBucket, dstPrefix)
-	CheckAttr(&f.SrcCheckMap, &f.DstCheckMap, &f.ResultMap)
 }
 
 func (f FileWalk) F2F_GetIncrCheck(srcPath string, dstPath string) {
-	if err := filepath.Walk(srcPath, f.WalkforSrcIncrCheck); err != nil {
+	if err := ParallelWalk(srcPath, f.WalkforSrcIncrCheck); err != nil {
 		log.Fatalln("Walk failed:", err)
 	}
 	close(f.FileList)
 
-	if err := filepath.Walk(dstPath, f.WalkforDstIncrCheck); err != nil {
+	if err := ParallelWalk(dstPath, f.WalkforDstIncrCheck); err != nil {
 		log.Fatalln("Walk failed:", err)
 	}
-
-	CheckAttr(&f.SrcCheckMap, &f.DstCheckMap, &f.ResultMap)
 }
 
 func (f FileWalk) O2F_GetIncrCheck(client *s3.Client, srcPath string, dstBucket string, dstPrefix string) {
-	f.ListobjforSrcIncrCheck(client, srcBucket, srcPrefix)
+	if srcInventory != "" {
+		f.InventoryforSrcCheck(client, srcInventory, true)
+	} else {
+		f.ListobjforSrcIncrCheck(client, srcBucket, srcPrefix)
+	}
 
-	if err := filepath.Walk(dstPath, f.WalkforDstIncrCheck); err != nil {
+	if err := ParallelWalk(dstPath, f.WalkforDstIncrCheck); err != nil {
 		log.Fatalln("Walk failed:", err)
 	}
 	close(f.FileList)
-
-	CheckAttr(&f.SrcCheckMap, &f.DstCheckMap, &f.ResultMap)
 }
 
 func (f FileWalk) O2O_GetIncrCheck(client *s3.Client, srcBucket string, srcPrefix string, dstBucket string, dstPrefix string) {
-	f.ListobjforSrcIncrCheck(client, srcBucket, srcPrefix)
+	if srcInventory != "" {
+		f.InventoryforSrcCheck(client, srcInventory, true)
+	} else {
+		f.ListobjforSrcIncrCheck(client, srcBucket, srcPrefix)
+	}
 	f.ListobjforDstIncrCheck(client, dstBucket, dstPrefix)
 	close(f.FileList)
-
-	CheckAttr(&f.SrcCheckMap, &f.DstCheckMap, &f.ResultMap)
 }
@@ -0,0 +1,342 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//子命令，第一个参数不是子命令时按sync处理，兼容原来的调用方式: admt [options] <Source Path> <Destination Path>
+type command struct {
+	Name  string
+	Args  string
+	Usage string
+}
+
+var commands = []command{
+	{"sync", "[options] <Source Path> <Destination Path>", "Copy new and changed files, files that passed the last check are skipped unless '-i true'"},
+	{"cp", "[options] <Source Path> <Destination Path>", "Copy everything, the state of the last job is discarded"},
+	{"verify", "[options] <Source Path> <Destination Path>", "Check the destination against the source without copying, '-c attr' or '-c md5'"},
+	{"ls", "[options] <Path>", "List a local path or S3 prefix the way a copy reads it, sorted by name"},
+	{"diff", "[options] <Source Path> <Destination Path>", "Print '+' for files missing in the destination, '-' for extra files, '~' for files that differ, exits with 1 when there are differences"},
+	{"job", "[options] <Job File>", "Run the transfers listed in a YAML or JSON job file at the same time with one shared worker pool and one report"},
+	{"serve", "[options] <Job File>", "Keep running and start the transfers of a job file on their cron schedules, one run at a time"},
+	{"status", "[<Job> | <Source Path> <Destination Path>]", "Show the state of a job, or list all jobs without arguments"},
+	{"clean", "<Job> | <Source Path> <Destination Path>", "Remove the state of a job, the next sync copies everything"},
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].Name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage:")
+	fmt.Fprintln(os.Stderr, "  admt <command> [options] <arguments>")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s%s\n", c.Name, c.Usage)
+	}
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "'admt [options] <Source Path> <Destination Path>' without a command runs sync.")
+	fmt.Fprintln(os.Stderr, "Run 'admt <command> -h' for the options of a command.")
+}
+
+func commandUsage(fs *flag.FlagSet, name string) {
+	c := findCommand(name)
+	fmt.Fprintf(fs.Output(), "Usage: admt %s %s\n\n%s\n\nOptions:\n", c.Name, c.Args, c.Usage)
+	fs.PrintDefaults()
+}
+
+//返回子命令和它的参数
+func parseCommand(args []string) (string, []string) {
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		if len(args) > 1 && findCommand(args[1]) != nil {
+			return args[1], []string{"-h"}
+		}
+		usage()
+		os.Exit(0)
+	}
+	if findCommand(args[0]) != nil {
+		return args[0], args[1:]
+	}
+	return "sync", args
+}
+
+//job文件的名字由源端和目标端的路径去掉/组成
+func jobFileName(srcPath string, dstPath string) string {
+	srcjob := strings.Join(strings.Split(srcPath, "/"), "")
+	dstjob := strings.Join(strings.Split(dstPath, "/"), "")
+	return filepath.Join(jobDir, "_"+srcjob+"_"+dstjob)
+}
+
+//一个job在jobDir中的所有文件
+func jobStateFiles(jobFile string) []string {
+	return []string{jobFile, renameFile(jobFile), jobFile + ".tmp", historyFile(jobFile)}
+}
+
+//status和clean的参数: job文件名，或者和拷贝时相同的源端和目标端路径
+func resolveJob(fs *flag.FlagSet) string {
+	args := fs.Args()
+	switch len(args) {
+	case 1:
+		return filepath.Join(jobDir, filepath.Base(args[0]))
+	case 2:
+		src, dst := args[0], args[1]
+		if !strings.HasSuffix(src, "/") {
+			src = src + "/"
+		}
+		if !strings.HasSuffix(dst, "/") {
+			dst = dst + "/"
+		}
+		return jobFileName(src, dst)
+	}
+	fs.Usage()
+	os.Exit(2)
+	return ""
+}
+
+//jobDir中的job文件，只有serve运行历史的job也列出
+func listJobs() []string {
+	entries, err := os.ReadDir(jobDir)
+	if err != nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	var jobs []string
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".history")
+		if !strings.HasPrefix(name, "_") || strings.HasSuffix(name, ".rename") || strings.HasSuffix(name, ".tmp") || seen[name] {
+			continue
+		}
+		seen[name] = true
+		jobs = append(jobs, filepath.Join(jobDir, name))
+	}
+	return jobs
+}
+
+type jobSummary struct {
+	Entries, Files, Dirs, Symlinks int
+	Bytes                          int64
+	Pass, Fail, Other              int
+	LastCheck                      int64
+	Failed                         []string
+}
+
+func summarizeJob(fileMap map[string]FileInfo) jobSummary {
+	var s jobSummary
+	for name, info := range fileMap {
+		s.Entries++
+		switch info.FType {
+		case "0040":
+			s.Dirs++
+		case "0120":
+			s.Symlinks++
+		default:
+			s.Files++
+			s.Bytes += info.FSize
+		}
+		switch info.CStatus.CopyStatus {
+		case "checkPass":
+			s.Pass++
+		case "checkFail":
+			s.Fail++
+			s.Failed = append(s.Failed, name)
+		default:
+			s.Other++
+		}
+		if info.CStatus.Copytime > s.LastCheck {
+			s.LastCheck = info.CStatus.Copytime
+		}
+	}
+	sort.Strings(s.Failed)
+	return s
+}
+
+func formatTime(t int64) string {
+	if t == 0 {
+		return "-"
+	}
+	return time.Unix(t, 0).Format("2006-01-02 15:04:05")
+}
+
+//status: 显示job文件中记录的检查结果
+func Status(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	fs.Usage = func() { commandUsage(fs, "status") }
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		jobs := listJobs()
+		if len(jobs) == 0 {
+			fmt.Println("No jobs in", jobDir)
+			return
+		}
+		fmt.Printf("%-20s%8s%8s%8s  %-20s%s\n", "Last check", "Entries", "Pass", "Fail", "Last run", "Job")
+		for _, jobFile := range jobs {
+			s := summarizeJob(readLastTimeCopyInfo(jobFile))
+			lastRun := "-"
+			if runs := readRunHistory(jobFile); len(runs) > 0 {
+				lastRun = formatTime(runs[len(runs)-1].Start)
+			}
+			fmt.Printf("%-20s%8d%8d%8d  %-20s%s\n", formatTime(s.LastCheck), s.Entries, s.Pass, s.Fail, lastRun, filepath.Base(jobFile))
+		}
+		return
+	}
+
+	jobFile := resolveJob(fs)
+	if _, err := os.Stat(jobFile); err != nil && readRunHistory(jobFile) == nil {
+		log.Fatalln("No state for job:", filepath.Base(jobFile))
+	}
+	s := summarizeJob(readLastTimeCopyInfo(jobFile))
+	fmt.Println("Job file  :", jobFile)
+	fmt.Printf("Entries   : %d (files %d, directories %d, symlinks %d), %d bytes\n", s.Entries, s.Files, s.Dirs, s.Symlinks, s.Bytes)
+	fmt.Printf("Check     : pass %d, fail %d, not checked %d\n", s.Pass, s.Fail, s.Other)
+	fmt.Println("Last check:", formatTime(s.LastCheck))
+	if runs := readRunHistory(jobFile); len(runs) > 0 {
+		if len(runs) > 10 {
+			runs = runs[len(runs)-10:]
+		}
+		fmt.Println("Runs of serve:")
+		fmt.Printf("  %-20s%-20s%-8s%10s%10s%10s%10s  %s\n", "Start", "End", "Command", "Seconds", "Queued", "Pass", "Fail", "Transfer")
+		for _, run := range runs {
+			end, seconds := "running", "-"
+			if run.End > 0 {
+				end, seconds = formatTime(run.End), strconv.FormatInt(run.End-run.Start, 10)
+			} else if run.Error != "" {
+				end = "-"
+			}
+			fmt.Printf("  %-20s%-20s%-8s%10s%10d%10d%10d  %s", formatTime(run.Start), end, run.Command, seconds, run.Queued, run.Pass, run.Fail, run.Transfer)
+			if run.Skipped > 0 {
+				fmt.Printf(" (skipped %d overlapping runs)", run.Skipped)
+			}
+			if run.Error != "" {
+				fmt.Printf(" (%s)", run.Error)
+			}
+			fmt.Println()
+		}
+	}
+	if len(s.Failed) > 0 {
+		fmt.Println("Files which fail to pass check:")
+		for _, name := range s.Failed {
+			fmt.Println(name)
+		}
+	}
+}
+
+//clean: 删除job的状态文件
+func Clean(args []string) {
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	fs.Usage = func() { commandUsage(fs, "clean") }
+	fs.Parse(args)
+
+	jobFile := resolveJob(fs)
+	removed := 0
+	for _, name := range jobStateFiles(jobFile) {
+		if err := os.Remove(name); err == nil {
+			fmt.Println("Removed", name)
+			removed++
+		} else if !os.IsNotExist(err) {
+			log.Println(err)
+		}
+	}
+	if removed == 0 {
+		fmt.Println("No state for job:", filepath.Base(jobFile))
+	}
+}
+
+func fileTypeChar(fType string) string {
+	switch fType {
+	case "0040":
+		return "d"
+	case "0120":
+		return "l"
+	}
+	return "-"
+}
+
+//ls: 和拷贝时一样列出源端，按名字排序输出
+func List() {
+	walker := FileWalk{
+		make(chan FileInfo, 100000),
+		true,
+		map[string]FileInfo{},
+		nil,
+		nil,
+		srcPath,
+		dstPath,
+		defaultFileMode,
+		withAttr,
+	}
+	startListing(walker)
+
+	sorted := newCheckSorter() //名字较多时在临时文件中排序
+	for info := range walker.FileList {
+		sorted.Add(checkEntry{Key: info.Filename, Info: info})
+	}
+	var entries int
+	var bytes int64
+	for entry := range sorted.Sorted() {
+		info := entry.Info
+		if withAttr {
+			fmt.Printf("%s %4s %6d %6d %12d %s %s\n", fileTypeChar(info.FType), info.FPerm, info.FUID, info.FGID, info.FSize, formatTime(info.FmTime), info.Filename)
+		} else {
+			fmt.Printf("%s %12d %s %s\n", fileTypeChar(info.FType), info.FSize, formatTime(info.FmTime), info.Filename)
+		}
+		entries++
+		if info.FType != "0040" {
+			bytes += info.FSize
+		}
+	}
+	fmt.Printf("Total: %d entries, %d bytes\n", entries, bytes)
+}
+
+//diff: 按属性比较两端，只输出不同的文件，没有差异时返回true
+func Diff() bool {
+	checker := FileWalk{
+		make(chan FileInfo, 100000),
+		false,
+		map[string]FileInfo{},
+		newCheckSorter(),
+		newCheckSorter(),
+		srcPath,
+		dstPath,
+		defaultFileMode,
+		withAttr,
+	}
+	checker.GetCheck(false)
+
+	same := true
+	mergeJoin(checker.SrcCheck.Sorted(), checker.DstCheck.Sorted(), func(src checkEntry, dst *checkEntry) {
+		if dst == nil {
+			fmt.Println("+", src.Info.Filename)
+			same = false
+		} else if compareAttr(src.Info, &dst.Info) == "checkFail" {
+			fmt.Println("~", src.Info.Filename)
+			same = false
+		}
+	}, func(dst checkEntry) {
+		fmt.Println("-", dst.Info.Filename)
+		same = false
+	})
+	return same
+}
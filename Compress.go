@@ -0,0 +1,116 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+//-compress开启时，F2O上传时流式压缩，并在metadata中记录压缩格式和原始大小，O2F下载时自动解压
+//同时开启-cse-key时，先压缩再加密
+const (
+	compressMetaKey     = "admt-compress"
+	originalSizeMetaKey = "admt-original-size"
+)
+
+//已经压缩过的格式，压缩没有效果，默认跳过
+const defaultCompressSkip = "*.gz,*.tgz,*.bz2,*.xz,*.zst,*.zip,*.7z,*.rar,*.jpg,*.jpeg,*.png,*.gif,*.webp,*.mp3,*.mp4,*.mkv,*.mov,*.avi,*.parquet,*.orc"
+
+func isCompressMetaKey(k string) bool {
+	return k == compressMetaKey || k == originalSizeMetaKey
+}
+
+func validCompressCodec(codec string) bool {
+	return codec == "" || codec == "zstd" || codec == "gzip"
+}
+
+func ParseCompressSkip(skip string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(skip, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if !validPattern(pattern) {
+			log.Fatalln("Invalid pattern in '-compress-skip':", pattern)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+//symlink和-compress-skip匹配的文件不压缩
+func shouldCompress(info FileInfo) bool {
+	if compressCodec == "" || info.FType == "0120" {
+		return false
+	}
+	for _, pattern := range compressSkip {
+		if matchPattern(pattern, info.Filename) {
+			return false
+		}
+	}
+	return true
+}
+
+//返回一个压缩后内容的Reader，用于上传，上传结束后需要Close
+func NewCompressReader(r io.Reader, codec string, metadata map[string]string, originalSize int64) *io.PipeReader {
+	metadata[compressMetaKey] = codec
+	metadata[originalSizeMetaKey] = strconv.FormatInt(originalSize, 10)
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(compressStream(r, pw, codec))
+	}()
+	return pr
+}
+
+func compressStream(r io.Reader, w io.Writer, codec string) error {
+	var cw io.WriteCloser
+	switch codec {
+	case "gzip":
+		cw = gzip.NewWriter(w)
+	case "zstd":
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		cw = zw
+	default:
+		return errors.New("unsupported compression " + codec)
+	}
+	if _, err := io.Copy(cw, r); err != nil {
+		cw.Close()
+		return err
+	}
+	return cw.Close()
+}
+
+func decompressStream(r io.Reader, w io.Writer, codec string) error {
+	switch codec {
+	case "gzip":
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		_, err = io.Copy(w, gr)
+		return err
+	case "zstd":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		_, err = io.Copy(w, zr)
+		return err
+	}
+	return errors.New("unsupported compression " + codec)
+}
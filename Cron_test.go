@@ -0,0 +1,101 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{"* * * * *", false},
+		{"*/15 0-6/2 1,15 * 1-5", false},
+		{"0 0 * * 7", false},
+		{"@daily", false},
+		{"@every 90s", false},
+		{"@every 500ms", true},
+		{"@every soon", true},
+		{"@often", true},
+		{"* * * *", true},
+		{"60 * * * *", true},
+		{"* 24 * * *", true},
+		{"* * 0 * *", true},
+		{"* * * 13 *", true},
+		{"* * * * 8", true},
+		{"5-1 * * * *", true},
+		{"*/0 * * * *", true},
+		{"a * * * *", true},
+	}
+	for _, tt := range tests {
+		_, err := parseCron(tt.expr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCron(%q) error = %v, want error %v", tt.expr, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		name  string
+		expr  string
+		after string
+		want  string //空字符串表示永远不会运行
+	}{
+		{"every minute", "* * * * *", "2024-03-10 08:30", "2024-03-10 08:31"},
+		{"hourly", "@hourly", "2024-03-10 08:30", "2024-03-10 09:00"},
+		{"daily", "@daily", "2024-12-31 23:59", "2025-01-01 00:00"},
+		{"weekly on sunday", "@weekly", "2024-03-11 00:00", "2024-03-17 00:00"},
+		{"monthly", "@monthly", "2024-01-15 12:00", "2024-02-01 00:00"},
+		{"yearly", "@yearly", "2024-01-01 00:00", "2025-01-01 00:00"},
+		{"minute step", "*/15 * * * *", "2024-03-10 08:31", "2024-03-10 08:45"},
+		{"step from a start", "5/20 * * * *", "2024-03-10 08:46", "2024-03-10 09:05"},
+		{"range with step", "0 9-17/4 * * *", "2024-03-10 13:00", "2024-03-10 17:00"},
+		{"list", "0 0 1,15 * *", "2024-03-02 00:00", "2024-03-15 00:00"},
+		{"7 is sunday", "0 0 * * 7", "2024-03-11 00:00", "2024-03-17 00:00"},
+		{"dom or dow", "0 0 13 * 5", "2024-09-01 00:00", "2024-09-06 00:00"},
+		{"dom or dow, dom first", "0 0 13 * 5", "2024-09-07 00:00", "2024-09-13 00:00"},
+		{"dom range and dow", "0 0 1-10/9 * 1", "2024-04-02 00:00", "2024-04-08 00:00"},
+		{"dom step is any, both must match", "0 0 */10 * 1", "2024-04-02 00:00", "2024-07-01 00:00"},
+		{"dow step is any, both must match", "0 0 15 * */3", "2024-04-02 00:00", "2024-05-15 00:00"},
+		{"dom step and dow any", "0 0 */10 * *", "2024-04-02 00:00", "2024-04-11 00:00"},
+		{"leap day", "0 0 29 2 *", "2024-03-01 00:00", "2028-02-29 00:00"},
+		{"31st skips short months", "0 0 31 * *", "2024-04-01 00:00", "2024-05-31 00:00"},
+		{"impossible date", "0 0 30 2 *", "2024-01-01 00:00", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := s.Next(at(tt.after))
+			if tt.want == "" {
+				if !got.IsZero() {
+					t.Errorf("Next = %v, want never", got)
+				}
+				return
+			}
+			if want := at(tt.want); !got.Equal(want) {
+				t.Errorf("Next = %v, want %v", got, want)
+			}
+		})
+	}
+
+	s, _ := parseCron("@every 90s")
+	after := at("2024-03-10 08:30").Add(10 * time.Second)
+	if got := s.Next(after); !got.Equal(after.Add(90 * time.Second)) {
+		t.Errorf("@every Next = %v, want %v", got, after.Add(90*time.Second))
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+//文件名和key的转换
+//s3的key必须是UTF-8，并且不超过1024字节；linux的文件名可以是任意字节，但每一级不超过255字节，不能包含NUL, 不能是.或..
+//-name-encoding percent: 上传时把非UTF-8的字节和%编码为%XX，下载时再解码，可以还原原来的文件名
+//-name-encoding none: 不转换，无法上传的文件名跳过并记录在报告中
+//-unicode-normalize nfc/nfd: 目标端的名字统一为NFC或NFD
+//超过255字节的路径无法还原，截断后加上hash, 超过1024字节的key跳过，都记录在报告中
+const (
+	maxKeyLength       = 1024
+	maxComponentLength = 255
+)
+
+type nameReportEntry struct {
+	Action string //mapped或skipped
+	Reason string
+	Src    string
+	Dst    string
+}
+
+var (
+	nameReportMu sync.Mutex
+	nameReport   = map[string]nameReportEntry{}
+)
+
+func validNameEncoding(encoding string) bool {
+	return encoding == "none" || encoding == "percent"
+}
+
+func validNormalize(form string) bool {
+	return form == "" || form == "nfc" || form == "nfd"
+}
+
+func reportName(action string, reason string, src string, dst string) {
+	nameReportMu.Lock()
+	defer nameReportMu.Unlock()
+	if _, ok := nameReport[src]; ok {
+		return
+	}
+	nameReport[src] = nameReportEntry{action, reason, src, dst}
+	if action == "skipped" {
+		log.Printf("Skip invalid name (%s): %q\n", reason, src)
+	}
+}
+
+//上传时的编码，只编码%和非UTF-8的字节
+func percentEncode(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); {
+		r, size := utf8.DecodeRuneInString(name[i:])
+		if r == utf8.RuneError && size <= 1 {
+			fmt.Fprintf(&b, "%%%02X", name[i])
+			i++
+			continue
+		}
+		if r == '%' {
+			b.WriteString("%25")
+		} else {
+			b.WriteString(name[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+//下载时的解码，不是合法%XX的部分保持不变
+func percentDecode(component string) string {
+	if !strings.Contains(component, "%") {
+		return component
+	}
+	var b strings.Builder
+	for i := 0; i < len(component); i++ {
+		if component[i] == '%' && i+2 < len(component) {
+			hi, ok1 := unhex(component[i+1])
+			lo, ok2 := unhex(component[i+2])
+			if ok1 && ok2 {
+				b.WriteByte(hi<<4 | lo)
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(component[i])
+	}
+	return b.String()
+}
+
+func normalizeName(name string) string {
+	switch unicodeNormalize {
+	case "nfc":
+		return norm.NFC.String(name)
+	case "nfd":
+		return norm.NFD.String(name)
+	}
+	return name
+}
+
+//超长的一级路径截断到255字节以内，加上原名的hash保证不重复，保留扩展名
+func shortenComponent(component string) string {
+	sum := sha1.Sum([]byte(component))
+	suffix := "~" + hex.EncodeToString(sum[:4])
+	ext := ""
+	if i := strings.LastIndex(component, "."); i > 0 && len(component)-i <= 16 {
+		ext = component[i:]
+	}
+	keep := maxComponentLength - len(suffix) - len(ext)
+	for keep > 0 && !utf8.RuneStart(component[keep]) {
+		keep--
+	}
+	return component[:keep] + suffix + ext
+}
+
+//返回目标端的名字，为空时代表无法转换，需要跳过，转换过的名字记录在报告中
+func mapDstName(src string, name string) string {
+	isDir := strings.HasSuffix(name, "/")
+	name = strings.TrimSuffix(name, "/")
+	var reasons []string
+	if normalized := normalizeName(name); normalized != name {
+		name = normalized
+		reasons = append(reasons, "normalized")
+	}
+
+	switch {
+	case strings.HasSuffix(mode, "2o"): //目标端为s3
+		if !utf8.ValidString(name) && nameEncoding != "percent" {
+			reportName("skipped", "not UTF-8, use -name-encoding percent", src, "")
+			return ""
+		}
+		if nameEncoding == "percent" && mode != "o2o" {
+			if encoded := percentEncode(name); encoded != name {
+				name = encoded
+				reasons = append(reasons, "percent-encoded")
+			}
+		}
+		if len(pathJoin(dstPrefix, name)) > maxKeyLength {
+			reportName("skipped", "key longer than 1024 bytes", src, "")
+			return ""
+		}
+
+	case strings.HasSuffix(mode, "2f"): //目标端为文件系统
+		components := strings.Split(name, "/")
+		for i, c := range components {
+			if nameEncoding == "percent" && strings.HasPrefix(mode, "o2") {
+				//解码后不合法的名字保持编码后的形式
+				if decoded := percentDecode(c); decoded != c && decoded != "." && decoded != ".." && !strings.ContainsAny(decoded, "/\x00") {
+					c = decoded
+					reasons = append(reasons, "percent-decoded")
+				}
+			}
+			if c == ".." || c == "." {
+				c = strings.Repeat("%2E", len(c)) //防止写到目标目录以外
+				reasons = append(reasons, "dot segment")
+			}
+			if strings.Contains(c, "\x00") {
+				c = strings.ReplaceAll(c, "\x00", "%00")
+				reasons = append(reasons, "NUL")
+			}
+			if len(c) > maxComponentLength {
+				c = shortenComponent(c)
+				reasons = append(reasons, "longer than 255 bytes")
+			}
+			components[i] = c
+		}
+		name = strings.Join(components, "/")
+	}
+	if isDir {
+		name = name + "/"
+	}
+	if len(reasons) > 0 {
+		reportName("mapped", strings.Join(reasons, ","), src, name)
+	}
+	return name
+}
+
+//非UTF-8的名字用Go的转义格式写入，保证报告本身是合法的UTF-8
+func reportField(name string) string {
+	if utf8.ValidString(name) {
+		return name
+	}
+	return fmt.Sprintf("%q", name)
+}
+
+func printNameReport() {
+	nameReportMu.Lock()
+	defer nameReportMu.Unlock()
+	if len(nameReport) == 0 {
+		return
+	}
+	var mapped, skipped int
+	var srcs []string
+	for src, entry := range nameReport {
+		if entry.Action == "skipped" {
+			skipped++
+		} else {
+			mapped++
+		}
+		srcs = append(srcs, src)
+	}
+	fmt.Printf("Names mapped: %d, names skipped: %d\n", mapped, skipped)
+	if nameReportFile == "" {
+		return
+	}
+	sort.Strings(srcs)
+	fd, err := os.Create(nameReportFile)
+	if err != nil {
+		log.Println("Failed to write name report:", err)
+		return
+	}
+	defer fd.Close()
+	w := csv.NewWriter(fd)
+	w.Write([]string{"action", "reason", "source", "destination"})
+	for _, src := range srcs {
+		entry := nameReport[src]
+		w.Write([]string{entry.Action, entry.Reason, reportField(entry.Src), reportField(entry.Dst)})
+	}
+	w.Flush()
+	fmt.Println("Name report:", nameReportFile)
+}
@@ -0,0 +1,197 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestPercentEncode(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"plain.txt", "plain.txt"},
+		{"", ""},
+		{"日本語/ü", "日本語/ü"},
+		{"100%", "100%25"},
+		{"%41", "%2541"},
+		{"%%", "%25%25"},
+		{"bad\xff", "bad%FF"},
+		{"\xc3", "%C3"},               //不完整的多字节字符
+		{"\xc3(", "%C3("},             //续字节不合法
+		{"\xed\xa0\x80", "%ED%A0%80"}, //UTF-16代理项
+		{"a\x00b", "a\x00b"},          //NUL是合法的UTF-8, 写文件时另外处理
+	}
+	for _, tt := range tests {
+		got := percentEncode(tt.name)
+		if got != tt.encoded {
+			t.Errorf("percentEncode(%q) = %q, want %q", tt.name, got, tt.encoded)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("percentEncode(%q) = %q is not UTF-8", tt.name, got)
+		}
+		if back := percentDecode(got); back != tt.name {
+			t.Errorf("percentDecode(%q) = %q, want %q", got, back, tt.name)
+		}
+	}
+}
+
+func TestPercentDecode(t *testing.T) {
+	tests := []struct {
+		encoded string
+		name    string
+	}{
+		{"a%2Fb", "a/b"},
+		{"%e6%97%a5", "日"},
+		{"%zz", "%zz"},
+		{"%4", "%4"},
+		{"end%", "end%"},
+		{"%%41", "%A"},
+		{"%2541", "%41"},
+	}
+	for _, tt := range tests {
+		if got := percentDecode(tt.encoded); got != tt.name {
+			t.Errorf("percentDecode(%q) = %q, want %q", tt.encoded, got, tt.name)
+		}
+	}
+}
+
+func TestShortenComponent(t *testing.T) {
+	tests := []struct {
+		name      string
+		component string
+		ext       string
+	}{
+		{"ascii", strings.Repeat("a", 300), ""},
+		{"extension kept", strings.Repeat("a", 300) + ".tar.gz", ".gz"},
+		{"long extension dropped", strings.Repeat("a", 300) + "." + strings.Repeat("b", 20), ""},
+		{"multi-byte at the cut", strings.Repeat("a", 244) + strings.Repeat("日", 10), ""},
+		{"multi-byte at the cut with extension", strings.Repeat("a", 240) + strings.Repeat("日", 10) + ".txt", ".txt"},
+		{"four-byte runes", strings.Repeat("😀", 70), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := shortenComponent(tt.component)
+			if len(got) > maxComponentLength {
+				t.Errorf("length = %d", len(got))
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("%q is not UTF-8", got)
+			}
+			if !strings.HasSuffix(got, tt.ext) {
+				t.Errorf("%q does not end with %q", got, tt.ext)
+			}
+			if other := shortenComponent(tt.component + "x"); other == got {
+				t.Errorf("different names shorten to the same %q", got)
+			}
+		})
+	}
+}
+
+func TestMapDstNameToFiles(t *testing.T) {
+	oldMode, oldEncoding, oldNormalize := mode, nameEncoding, unicodeNormalize
+	t.Cleanup(func() {
+		mode, nameEncoding, unicodeNormalize = oldMode, oldEncoding, oldNormalize
+		resetJobState()
+	})
+	mode, unicodeNormalize = "o2f", ""
+	long := strings.Repeat("a", 300)
+	tests := []struct {
+		encoding string
+		key      string
+		want     string
+	}{
+		{"percent", "dir/bad%FF", "dir/bad\xff"},
+		{"percent", "100%25", "100%"},
+		{"percent", "100%", "100%"},
+		{"percent", "a%2Fb", "a%2Fb"}, //解码后包含/
+		{"percent", "a%00b", "a%00b"}, //解码后包含NUL
+		{"percent", "%2E", "%2E"},
+		{"percent", "%2E%2E/x", "%2E%2E/x"},
+		{"percent", "%2e%2E%2e", "..."},
+		{"none", "bad%FF", "bad%FF"},
+		{"none", "../x", "%2E%2E/x"},
+		{"none", "a/./b", "a/%2E/b"},
+		{"none", "a\x00b", "a%00b"},
+		{"none", "dir/", "dir/"},
+		{"none", "d/" + long, "d/" + shortenComponent(long)},
+	}
+	for _, tt := range tests {
+		nameEncoding = tt.encoding
+		if got := mapDstName(tt.key, tt.key); got != tt.want {
+			t.Errorf("%s: mapDstName(%q) = %q, want %q", tt.encoding, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestJobStateNames(t *testing.T) {
+	names := []string{"", "a%41", "%", "bad\xff\xfename", "dir/\x80/", "日本語"}
+	state := map[string]FileInfo{}
+	for _, name := range names {
+		state[name] = FileInfo{Filename: name, CStatus: CopyInfo{CopyStatus: "checkPass"}}
+	}
+	jobFile := filepath.Join(t.TempDir(), "job")
+	if err := writeJobFile(jobFile, state); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(jobFile)
+	if !json.Valid(content) {
+		t.Fatalf("invalid job file %s", content)
+	}
+	got := readLastTimeCopyInfo(jobFile)
+	if len(got) != len(names) {
+		t.Errorf("read %d entries, want %d", len(got), len(names))
+	}
+	for _, name := range names {
+		if info, ok := got[name]; !ok || info.Filename != name {
+			t.Errorf("entry %q = %+v, %v", name, info, ok)
+		}
+	}
+
+	//旧版本的job文件中的名字没有编码
+	old := `{"%":{"FSize":1},"a%41":{"FSize":2}}`
+	if err := os.WriteFile(jobFile, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got = readLastTimeCopyInfo(jobFile)
+	if got["%"].FSize != 1 || got["a%41"].FSize != 2 || len(got) != 2 {
+		t.Errorf("old job file = %v", got)
+	}
+}
+
+func TestRenameStateNames(t *testing.T) {
+	oldRenames := renames
+	t.Cleanup(func() { renames = oldRenames })
+	jobFile := filepath.Join(t.TempDir(), "job")
+
+	renames = newRenameState()
+	renames.toDst["src\xff%"] = "dst\xff%"
+	renames.toDst["a"] = "b"
+	SaveRenameState(jobFile)
+	renames = newRenameState()
+	LoadRenameState(jobFile)
+	want := map[string]string{"src\xff%": "dst\xff%", "a": "b"}
+	if !reflect.DeepEqual(renames.toDst, want) {
+		t.Errorf("toDst = %q, want %q", renames.toDst, want)
+	}
+	if srcNameOf("dst\xff%") != "src\xff%" {
+		t.Errorf("srcNameOf = %q", srcNameOf("dst\xff%"))
+	}
+
+	if err := os.WriteFile(renameFile(jobFile), []byte(`{"a%41":"b%42"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	renames = newRenameState()
+	LoadRenameState(jobFile)
+	if dstNameOf("a%41") != "b%42" {
+		t.Errorf("old rename file: toDst = %q", renames.toDst)
+	}
+}
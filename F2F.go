@@ -18,7 +18,7 @@ import (
 func F2F_DirCopy(info FileInfo, srcPath string, dstpath string, defaultFileMode Filemod) {
 
 	filename := info.Filename
-	fdstPath := pathJoin(dstPath, filename)
+	fdstPath := dstJoin(dstPath, info)
 
 	_, err := os.Lstat(fdstPath)
 	if errors.Is(err, os.ErrNotExist) { //判断目录是否已存在,不存在的话
@@ -34,7 +34,7 @@ func F2F_DirCopy(info FileInfo, srcPath string, dstpath string, defaultFileMode
 func F2F_RegCopy(info FileInfo, srcPath string, dstpath string, partSize int64, defalutFileMode Filemod) {
 
 	filename := info.Filename
-	fdstPath := pathJoin(dstPath, filename)
+	fdstPath := dstJoin(dstPath, info)
 
 	_, err := os.Lstat(filepath.Dir(fdstPath)) //判断目录是否已存在,不存在就新建。
 	if errors.Is(err, os.ErrNotExist) {
@@ -65,7 +65,7 @@ func F2F_RegCopy(info FileInfo, srcPath string, dstpath string, partSize int64,
 func F2F_SymCopy( info FileInfo, srcPath string, dstpath string) {
 
 	filename := info.Filename
-	fdstPath := pathJoin(dstPath, filename)
+	fdstPath := dstJoin(dstPath, info)
 
 	_, err := os.Lstat(filepath.Dir(fdstPath)) //判断目录是否已存在,不存在就新建。
 	if errors.Is(err, os.ErrNotExist) {
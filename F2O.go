@@ -9,7 +9,6 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"strconv"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
@@ -24,19 +23,19 @@ func F2O_DirCopy(client *s3.Client, info FileInfo, srcPath string, dstBucket str
 	//这里会先执行，closeCh()再执行wg.Done()，这里使用的栈结构，wg.Done()会先压入栈
 
 	filename := info.Filename
-	fdstPath := pathJoin(dstPrefix, filename)
-	_, err := client.PutObject(context.TODO(), &s3.PutObjectInput{ //uploadmanager不能上传空目录，所以这里使用client来上传
-		Bucket: &dstBucket,
-		Key:    aws.String(fdstPath), //这里没有body
-		Metadata: map[string]string{
-			"user-agent":       info.FUserAgent,
-			"file-owner":       strconv.FormatInt(int64(info.FUID), 10),
-			"file-group":       strconv.FormatInt(int64(info.FGID), 10),
-			"file-permissions": info.FType + info.FPerm,
-			"file-atime":       strconv.FormatInt(info.FaTime, 10),
-			"file-mtime":       strconv.FormatInt(info.FmTime, 10),
-		},
-	})
+	fdstPath := dstJoin(dstPrefix, info)
+	metadata := buildAttrMetadata(info)
+	if err := PutXattrMeta(client, dstBucket, fdstPath, metadata, info.FXattr); err != nil {
+		log.Fatalln("Failed to upload", fdstPath, err)
+	}
+	input := &s3.PutObjectInput{ //uploadmanager不能上传空目录，所以这里使用client来上传
+		Bucket:   &dstBucket,
+		Key:      aws.String(fdstPath), //这里没有body
+		Metadata: metadata,
+	}
+	dstSSE.applyPut(input)
+	applyPutTags(input, filename)
+	_, err := client.PutObject(context.TODO(), input)
 	if err != nil {
 		log.Fatalln("Failed to upload", fdstPath, err)
 	}
@@ -59,7 +58,7 @@ func F2O_RegCopy(client *s3.Client, info FileInfo, srcPath string, dstBucket str
 	}
 	defer fd.Close()
 
-	fdstPath := pathJoin(dstPrefix, filename)
+	fdstPath := dstJoin(dstPrefix, info)
 	UploadS3(uploader, fd, dstBucket, fdstPath, storageClass, info)
 	fmt.Println("Copy:", filename)
 
@@ -80,7 +79,7 @@ func F2O_SymCopy(client *s3.Client, info FileInfo, srcPath string, dstBucket str
 	fd, _ := os.Open(tmpfname)
 	defer fd.Close()
 
-	fdstPath := pathJoin(dstPrefix, filename)
+	fdstPath := dstJoin(dstPrefix, info)
 	UploadS3(uploader, fd, dstBucket, fdstPath, storageClass, info)
 
 	fmt.Println("Copy:", filename)
@@ -9,7 +9,7 @@ import (
 	"path/filepath"
 )
 
-//这是filepath.Walk的参数，代表每扫描到一个对象，需要执行这个参数进行操作
+//这是ParallelWalk(filepath.WalkFunc)的参数，代表每扫描到一个对象，需要执行这个参数进行操作
 //由于这里的Walk参数是固定的，但是因为放到list的最好是相对路径，所以这里的srcPath为全局参数
 func (f FileWalk) Walk(fsrcPath string, info os.FileInfo, err error) error {
 	if err != nil {
@@ -72,7 +72,7 @@ func (f FileWalk) WalkforSrcCheck(fsrcPath string, info os.FileInfo, err error)
 	}
 
 	if !(objInfo.Filename == "./" || objInfo.Filename == "../" || objInfo.Filename == ".." || objInfo.Filename == ".") {
-		f.SrcCheckMap[objInfo.Filename] = objInfo
+		f.addSrcCheck(objInfo)
 
 	}
 
@@ -93,18 +93,22 @@ func (f FileWalk) WalkforDstCheck(fdstPath string, info os.FileInfo, err error)
 
 	}
 	if !(objInfo.Filename == "./" || objInfo.Filename == "../" || objInfo.Filename == ".." || objInfo.Filename == ".") {
-		f.DstCheckMap[objInfo.Filename] = objInfo
+		f.addDstCheck(objInfo)
 
 	}
 
 	return nil
 }
 
+//增量检查时只记录名字，上次已经检查通过的文件在归并时跳过，其他文件归并后再读取属性
 func (f FileWalk) WalkforSrcIncrCheck(fsrcPath string, info os.FileInfo, err error) error {
 	if err != nil {
 		log.Println(err)
 		return err
 	}
+	if !lazySrcCheck() {
+		return f.WalkforSrcCheck(fsrcPath, info, err)
+	}
 
 	filename, err := filepath.Rel(f.SrcPath, fsrcPath) //在key上去除掉原来的prefix
 	if err != nil {
@@ -114,19 +118,8 @@ func (f FileWalk) WalkforSrcIncrCheck(fsrcPath string, info os.FileInfo, err err
 		filename = filename + "/"
 	}
 
-	if f.FileMap[filename].CStatus.CopyStatus != "checkPass" {
-		var objInfo FileInfo
-		if f.withAttr {
-			objInfo = GetFileMetadata(f.SrcPath, fsrcPath)
-
-		} else {
-			objInfo = GetFileMetadataWithoutAttr(f.SrcPath, fsrcPath)
-
-		}
-		if !(objInfo.Filename == "./" || objInfo.Filename == "../" || objInfo.Filename == ".." || objInfo.Filename == ".") {
-			f.SrcCheckMap[objInfo.Filename] = objInfo
-
-		}
+	if !(filename == "./" || filename == "../" || filename == ".." || filename == ".") {
+		f.addSrcEntry(FileInfo{Filename: filename}, &checkFetch{Key: fsrcPath})
 
 	}
 
@@ -146,19 +139,8 @@ func (f FileWalk) WalkforDstIncrCheck(fdstPath string, info os.FileInfo, err err
 	if info.IsDir() {
 		filename = filename + "/"
 	}
-	if f.FileMap[filename].CStatus.CopyStatus != "checkPass" {
-		var objInfo FileInfo
-		if f.withAttr {
-			objInfo = GetFileMetadata(f.DstPath, fdstPath)
-
-		} else {
-			objInfo = GetFileMetadataWithoutAttr(f.DstPath, fdstPath)
-
-		}
-		if !(objInfo.Filename == "./" || objInfo.Filename == "../" || objInfo.Filename == ".." || objInfo.Filename == ".") {
-			f.DstCheckMap[objInfo.Filename] = objInfo
-
-		}
+	if !(filename == "./" || filename == "../" || filename == ".." || filename == ".") {
+		f.addDstEntry(FileInfo{Filename: filename}, &checkFetch{Key: fdstPath})
 	}
 
 	return nil
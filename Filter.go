@@ -0,0 +1,58 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"log"
+	"path"
+	"strings"
+)
+
+//-include和-exclude指定的pattern, 可以重复指定，格式和-header-rules相同: 不含/时匹配文件名，含/时匹配相对路径
+//pattern匹配一个目录时，目录下的所有文件都匹配
+type patternFlags []string
+
+var (
+	includePatterns patternFlags
+	excludePatterns patternFlags
+)
+
+func (p *patternFlags) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patternFlags) Set(value string) error {
+	if !validPattern(value) {
+		log.Fatalln("Invalid filter pattern:", value)
+	}
+	*p = append(*p, value)
+	return nil
+}
+
+//名字或它所在的任意一级目录匹配pattern
+func matchFilter(patterns patternFlags, filename string) bool {
+	for name := strings.TrimSuffix(filename, "/"); name != "." && name != "/" && name != ""; name = path.Dir(name) {
+		for _, pattern := range patterns {
+			if matchPattern(pattern, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//按源端的相对路径选择文件，-exclude优先。指定-include时只选择匹配的文件和符号链接，目录总是保留
+func selected(filename string) bool {
+	if len(excludePatterns) > 0 && matchFilter(excludePatterns, filename) {
+		return false
+	}
+	if len(includePatterns) == 0 || strings.HasSuffix(filename, "/") {
+		return true
+	}
+	return matchFilter(includePatterns, filename)
+}
+
+func hasFilter() bool {
+	return len(includePatterns) > 0 || len(excludePatterns) > 0
+}
@@ -4,8 +4,8 @@
 package main
 
 import (
+	"bufio"
 	"context"
-	"encoding/json"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -23,6 +23,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -68,8 +69,10 @@ func GetFileMetadata(srcPath string, fsrcPath string) FileInfo {
 	other := strconv.FormatInt(permMap[modeStr[7]]+permMap[modeStr[8]]+permMap[modeStr[9]], 10) //转换其他人权限
 	fPerm := owner + group + other                                                              //进行字符串拼接，即完成转换为0100644类型
 
-	faTime := info.Sys().(*syscall.Stat_t).Atim.Sec //s3中存储为unix的纳秒，在进行上传时需要加上+ "000000000ns"， strconv.FormatInt(info.Sys().(*syscall.Stat_t).Mtim.Sec, 10) + "000000000ns"
+	faTime := info.Sys().(*syscall.Stat_t).Atim.Sec //s3中存储为unix的纳秒，后缀为ns，与lustre一致，见formatNsTime
 	fmTime := info.Sys().(*syscall.Stat_t).Mtim.Sec
+	faTimeNs := info.Sys().(*syscall.Stat_t).Atim.Nsec
+	fmTimeNs := info.Sys().(*syscall.Stat_t).Mtim.Nsec
 
 	//这里go1.18.6和1.19.3不一样， 1.18.6为*syscall.Stat_t).Atim.Sec, *syscall.Stat_t).Mtim.Sec, 而在1.19.3中为info.Sys().(*syscall.Stat_t).Atimespec.Sec,info.Sys().(*syscall.Stat_t).Mtimespec.Sec
 
@@ -77,7 +80,13 @@ func GetFileMetadata(srcPath string, fsrcPath string) FileInfo {
 
 	fSize := info.Size() //这里加了文件大小，是为了迁移后做对比
 
-	return FileInfo{IsMetaExist: true, Filename: filename, FUserAgent: fUserAgent, FUID: fUID, FGID: fGID, FType: fType, FPerm: fPerm, FaTime: faTime, FmTime: fmTime, FSize: fSize}
+	var fXattr map[string][]byte
+	var fUserMeta map[string]string
+	if fType != "0120" { //syscall.Listxattr会跟随symlink，所以symlink不读取xattr
+		fXattr, fUserMeta = splitUserMetaXattr(GetXattr(fsrcPath))
+	}
+
+	return FileInfo{IsMetaExist: true, Filename: filename, FUserAgent: fUserAgent, FUID: fUID, FGID: fGID, FUser: lookupUserName(fUID), FGroup: lookupGroupName(fGID), FType: fType, FPerm: fPerm, FaTime: faTime, FmTime: fmTime, FaTimeNs: faTimeNs, FmTimeNs: fmTimeNs, FNsec: true, FSize: fSize, FXattr: fXattr, FUserMeta: fUserMeta}
 
 }
 
@@ -92,20 +101,26 @@ func GetFileMetadataWithoutAttr(srcPath string, fsrcPath string) FileInfo {
 		return FileInfo{Filename: filename, CStatus: CopyInfo{CopyStatus: "notFound"}}
 	}
 
+	stat := info.Sys().(*syscall.Stat_t)
 	if info.IsDir() {
 		filename = filename + "/"
-		return FileInfo{IsMetaExist: false, Filename: filename, FType: "0040", FSize: info.Size(), FaTime: info.Sys().(*syscall.Stat_t).Atim.Sec, FmTime: info.Sys().(*syscall.Stat_t).Mtim.Sec}
+		return FileInfo{IsMetaExist: false, Filename: filename, FType: "0040", FSize: info.Size(), FaTime: stat.Atim.Sec, FmTime: stat.Mtim.Sec, FaTimeNs: stat.Atim.Nsec, FmTimeNs: stat.Mtim.Nsec, FNsec: true}
 
 	} else {
 		//这里不支持指向direcotry的symlink
-		return FileInfo{IsMetaExist: false, Filename: filename, FType: "0100", FSize: info.Size(), FaTime: info.Sys().(*syscall.Stat_t).Atim.Sec, FmTime: info.Sys().(*syscall.Stat_t).Mtim.Sec}
+		return FileInfo{IsMetaExist: false, Filename: filename, FType: "0100", FSize: info.Size(), FaTime: stat.Atim.Sec, FmTime: stat.Mtim.Sec, FaTimeNs: stat.Atim.Nsec, FmTimeNs: stat.Mtim.Nsec, FNsec: true}
 	}
 
 }
 
 //函数中，如果是目录，返回的加/， FileType中只会有目录和regular两种，因为没有meta所以没有link文件
 //如果没有metadata，返回isMetaExist false, 其他属性也会选用默认或output获取值，无论是否有meta，都会有FileInfo,后续直接使用
-func GetObjMetadata(client *s3.Client, srcBucket string, srcPrefix string, key string) FileInfo {
+func GetObjMetadata(client *s3.Client, srcBucket string, srcPrefix string, key string, sse *SSEConfig) FileInfo {
+	return GetObjVersionMetadata(client, srcBucket, srcPrefix, key, "", sse)
+}
+
+//读取对象指定版本的属性，version为空时为最新版本
+func GetObjVersionMetadata(client *s3.Client, srcBucket string, srcPrefix string, key string, version string, sse *SSEConfig) FileInfo {
 	filename, err := filepath.Rel(srcPrefix, key) //在key上去除掉原来的prefix
 	if err != nil {
 		log.Fatalln("Unable to get relative path:", key, err)
@@ -115,52 +130,58 @@ func GetObjMetadata(client *s3.Client, srcBucket string, srcPrefix string, key s
 	if isDir {
 		filename = filename + "/"
 	}
-	output, err := client.HeadObject(context.TODO(), &s3.HeadObjectInput{
-		Bucket: aws.String(srcBucket),
-		Key:    aws.String(key),
-	})
+	headInput := &s3.HeadObjectInput{
+		Bucket:    aws.String(srcBucket),
+		Key:       aws.String(key),
+		VersionId: strOrNil(version),
+	}
+	sse.applyHead(headInput)
+	output, err := client.HeadObject(context.TODO(), headInput)
 	if err != nil {
 		log.Println(key, ":", err)
 		return FileInfo{Filename: filename, CStatus: CopyInfo{CopyStatus: "notFound"}}
 	}
 
-	if len(output.Metadata) != 6 {
+	format := detectMetadataFormat(output.Metadata)
+	if format == "" {
 		var filetype string
 		if isDir {
 			filetype = "0040"
-			filename = filename + "/"
-
 		} else {
 			filetype = "0100"
 		}
-		return FileInfo{IsMetaExist: false, Filename: filename, FUserAgent: "admt", FUID: 0, FGID: 0, FType: filetype, FPerm: "775", FaTime: output.LastModified.Unix(), FmTime: output.LastModified.Unix(), FSize: output.ContentLength}
+		return FileInfo{IsMetaExist: false, Filename: filename, FUserAgent: "admt", FUID: 0, FGID: 0, FType: filetype, FPerm: "775", FaTime: output.LastModified.Unix(), FmTime: output.LastModified.Unix(), FSize: plainSize(output), FUserMeta: extraMetadata(format, output.Metadata)}
 	}
 
-	fUserAgent := output.Metadata["user-agent"]
-	fUIDInt64, _ := strconv.ParseInt(output.Metadata["file-owner"], 10, 64) //如果是空的话，fUID会返回0
-	fUID := int(fUIDInt64)
-	fGIDInt64, _ := strconv.ParseInt(output.Metadata["file-group"], 10, 64)
-	fGID := int(fGIDInt64)
-	fType := ""
-	fPerm := ""
-	if len(output.Metadata) > 0 { //在attr check的时候，有可能prefix里存在之前传的无metadata的数据，这时进行数组索引时会报错。通过判断长度，防止数组溢出
-		fType = output.Metadata["file-permissions"][:4]
-		fPerm = output.Metadata["file-permissions"][4:]
-	}
-	faTime, _ := strconv.ParseInt(output.Metadata["file-atime"], 10, 64)
-	fmTime, _ := strconv.ParseInt(output.Metadata["file-mtime"], 10, 64)
-	fSize := output.ContentLength //这里加了对象大小，是为了迁移后做对比
+	objInfo := parseAttrMetadata(format, output.Metadata, key, isDir, output.LastModified.Unix())
+	objInfo.Filename = filename
+	objInfo.FSize = plainSize(output) //这里加了对象大小，是为了迁移后做对比
+	objInfo.FXattr = GetXattrMeta(client, srcBucket, key, output.Metadata, sse)
+	objInfo.FUserMeta = extraMetadata(format, output.Metadata)
 
-	return FileInfo{IsMetaExist: true, Filename: filename, FUserAgent: fUserAgent, FUID: fUID, FGID: fGID, FType: fType, FPerm: fPerm, FaTime: faTime, FmTime: fmTime, FSize: fSize}
+	return objInfo
 
 }
 
-func GetObjMetadataWithoutAttr(client *s3.Client, srcBucket string, srcPrefix string, key string, lastModified int64, size int64) FileInfo {
+func GetObjMetadataWithoutAttr(client *s3.Client, srcBucket string, srcPrefix string, key string, lastModified int64, size int64, sse *SSEConfig) FileInfo {
 	filename, err := filepath.Rel(srcPrefix, key) //在key上去除掉原来的prefix
 	if err != nil {
 		log.Fatalln("Unable to get relative path:", key, err)
 	}
 	isDir, _ := regexp.MatchString("/$", key)
+	if cseKey != nil && !isDir { //客户端加密时，列表里的大小为保存的大小，需要从metadata里读取原始大小，压缩的对象见plainSizes
+		headInput := &s3.HeadObjectInput{
+			Bucket: aws.String(srcBucket),
+			Key:    aws.String(key),
+		}
+		sse.applyHead(headInput)
+		output, err := client.HeadObject(context.TODO(), headInput)
+		if err != nil {
+			log.Println(key, ":", err)
+			return FileInfo{Filename: filename, CStatus: CopyInfo{CopyStatus: "notFound"}}
+		}
+		size = plainSize(output)
+	}
 	if isDir {
 		filename = filename + "/"
 		return FileInfo{IsMetaExist: false, Filename: filename, FType: "0040", FmTime: lastModified, FSize: size}
@@ -170,6 +191,145 @@ func GetObjMetadataWithoutAttr(client *s3.Client, srcBucket string, srcPrefix st
 
 }
 
+//源端和目标端都有纳秒时比较纳秒，否则只比较到秒
+func mtimeNotOlder(dst FileInfo, src FileInfo) bool {
+	if dst.FNsec && src.FNsec {
+		return dst.FmTime > src.FmTime || (dst.FmTime == src.FmTime && dst.FmTimeNs >= src.FmTimeNs)
+	}
+	return dst.FmTime >= src.FmTime
+}
+
+//压缩或客户端加密的对象返回原始大小
+func plainSize(output *s3.HeadObjectOutput) int64 {
+	if value, ok := output.Metadata[originalSizeMetaKey]; ok {
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err == nil {
+			return size
+		}
+	}
+	if value, ok := output.Metadata[cseSizeMetaKey]; ok {
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err == nil {
+			return size
+		}
+	}
+	return output.ContentLength
+}
+
+//下载时从第一个GetObject的响应中读取metadata, 不需要另外HeadObject
+//普通对象直接写入fd, 压缩或客户端加密的对象写入临时文件，下载完成后再解密和解压到fd
+//manager.Downloader先同步下载第一段，之后才并发下载其他段，所以写入前已经知道写到哪里
+type decodeTarget struct {
+	manager.DownloadAPIClient
+	fd       *os.File
+	key      string
+	once     sync.Once
+	metadata map[string]string
+	raw      *os.File
+}
+
+func (t *decodeTarget) GetObject(ctx context.Context, input *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	output, err := t.DownloadAPIClient.GetObject(ctx, input, optFns...)
+	if err == nil {
+		t.once.Do(func() {
+			t.metadata = output.Metadata
+			if t.metadata[cseAlgMetaKey] != "" || t.metadata[compressMetaKey] != "" {
+				t.raw = CreateTempFile(dataDir, filepath.Base(t.key))
+			}
+		})
+	}
+	return output, err
+}
+
+func (t *decodeTarget) WriteAt(p []byte, off int64) (int, error) {
+	if t.raw != nil {
+		return t.raw.WriteAt(p, off)
+	}
+	return t.fd.WriteAt(p, off)
+}
+
+//对象可能经过压缩或客户端加密，解密和解压到fd，普通对象直接下载
+//失败时清空fd并返回错误，不会留下部分解密的内容
+func DownloadS3Decode(downloader *manager.Downloader, fd *os.File, Bucket string, Key string, version string, sse *SSEConfig) error {
+	target := &decodeTarget{DownloadAPIClient: downloader.S3, fd: fd, key: Key}
+	d := *downloader
+	d.S3 = target
+	input := &s3.GetObjectInput{
+		Bucket:    aws.String(Bucket),
+		Key:       aws.String(Key),
+		VersionId: strOrNil(version),
+	}
+	sse.applyGet(input)
+	_, err := d.Download(context.TODO(), target, input)
+	if target.raw != nil {
+		defer os.Remove(target.raw.Name())
+		defer target.raw.Close()
+	}
+	if err != nil {
+		log.Println("Failed to download", Key, err)
+		return truncateFailed(fd, err)
+	}
+	if target.raw == nil {
+		return nil
+	}
+
+	params, err := ParseCSEParams(target.metadata)
+	if err != nil {
+		log.Println("Failed to download", Key, err)
+		return truncateFailed(fd, err)
+	}
+	codec := target.metadata[compressMetaKey]
+	if _, err := target.raw.Seek(0, io.SeekStart); err != nil {
+		log.Println("Failed to download", Key, err)
+		return truncateFailed(fd, err)
+	}
+
+	var r io.Reader = target.raw
+	if params != nil {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(decryptStream(target.raw, pw, params))
+		}()
+		defer pr.Close()
+		r = pr
+	}
+	if codec != "" {
+		err = decompressStream(r, fd, codec)
+	} else {
+		_, err = io.Copy(fd, r)
+	}
+	if err != nil {
+		log.Println("Failed to decode", Key, err)
+		return truncateFailed(fd, err)
+	}
+	return nil
+}
+
+func truncateFailed(fd *os.File, err error) error {
+	fd.Truncate(0)
+	fd.Seek(0, io.SeekStart)
+	return err
+}
+
+//下载到目标文件所在目录的临时文件，成功后rename，失败时删除，不会留下不完整的文件
+func downloadReplace(fpath string, download func(fd *os.File) error) error {
+	fd, err := os.CreateTemp(filepath.Dir(fpath), "."+filepath.Base(fpath)+".admt-")
+	if err != nil {
+		return err
+	}
+	err = download(fd)
+	if closeErr := fd.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(fd.Name(), fpath)
+	}
+	if err != nil {
+		os.Remove(fd.Name())
+	}
+	return err
+}
+
 func pathJoin(rootPath string, subPath string) string {
 	regexpDir, _ := regexp.Compile("/$")
 	isRootPathDir := regexpDir.MatchString(rootPath)
@@ -192,8 +352,8 @@ func pathJoin(rootPath string, subPath string) string {
 func Chattr(info FileInfo, fpath string, withTime bool, defaultFileMode Filemod) {
 
 	if info.IsMetaExist {
-		fUID := info.FUID
-		fGID := info.FGID
+		fUID := idMap.MapUID(info)
+		fGID := idMap.MapGID(info)
 		fPermUnit64, _ := strconv.ParseUint(info.FPerm, 8, 64) //这里要用8位的unit，不能用10进制
 		fPerm := os.FileMode(fPermUnit64)
 		err := os.Chown(fpath, int(fUID), int(fGID)) //chown需要在root才能运行
@@ -218,9 +378,15 @@ func Chattr(info FileInfo, fpath string, withTime bool, defaultFileMode Filemod)
 			log.Println(err)
 		}
 	}
+	if info.IsMetaExist && len(info.FXattr) > 0 {
+		SetXattr(fpath, info.FXattr)
+	}
+	if len(info.FUserMeta) > 0 { //其他工具写入的metadata保存在保留的xattr命名空间，再次上传时还原
+		SetXattr(fpath, userMetaToXattr(info.FUserMeta))
+	}
 	if withTime {
-		faTime := time.Unix(info.FaTime, 0)
-		fmTime := time.Unix(info.FmTime, 0)
+		faTime := time.Unix(info.FaTime, info.FaTimeNs)
+		fmTime := time.Unix(info.FmTime, info.FmTimeNs)
 		os.Chtimes(fpath, faTime, fmTime)
 	}
 
@@ -239,49 +405,71 @@ func CopyFile(fd *os.File, srcPath string) (int64, error) { //Go里面是以目
 
 }
 
-func UploadS3(uploader *manager.Uploader, file *os.File, Bucket string, Key string, storageClass string, info FileInfo) {
+func UploadS3(uploader *manager.Uploader, file io.Reader, Bucket string, Key string, storageClass string, info FileInfo) {
+	metadata := map[string]string{}
+	for k, v := range info.FUserMeta {
+		metadata[k] = v
+	}
 	if info.IsMetaExist {
-		_, err := uploader.Upload(context.TODO(), &s3.PutObjectInput{
-			Bucket:       aws.String(Bucket),
-			StorageClass: types.StorageClass(*aws.String(storageClass)),
-			Key:          aws.String(Key),
-			Body:         file,
-			Metadata: map[string]string{
-				"user-agent":       info.FUserAgent,
-				"file-owner":       strconv.FormatInt(int64(info.FUID), 10),
-				"file-group":       strconv.FormatInt(int64(info.FGID), 10),
-				"file-permissions": info.FType + info.FPerm,
-				"file-atime":       strconv.FormatInt(info.FaTime, 10),
-				"file-mtime":       strconv.FormatInt(info.FmTime, 10),
-			},
-		})
-		if err != nil {
-			log.Println("Failed to upload:", info.Filename, err)
-		}
-	} else {
+		metadata = buildAttrMetadata(info)
+	}
+	input := &s3.PutObjectInput{
+		Bucket:       aws.String(Bucket),
+		StorageClass: types.StorageClass(*aws.String(storageClass)),
+		Key:          aws.String(Key),
+		Body:         file,
+		Metadata:     metadata,
+	}
+	dstSSE.applyPut(input)
+	applyPutTags(input, info.Filename)
+	if info.FType != "0120" { //symlink上传的是指向的路径，不需要设置header
+		applyPutHeaders(input, GetObjectHeaders(info.Filename, file))
+	}
+
+	//Content-Type需要在压缩和加密前按原始内容判断，先压缩再加密
+	if shouldCompress(info) {
+		compressReader := NewCompressReader(input.Body, compressCodec, metadata, info.FSize)
+		defer compressReader.Close()
+		input.Body = compressReader
+	}
+	if cseKey != nil && info.FType != "0120" {
+		params := NewCSEParams(metadata, info.FSize)
+		encryptReader := NewEncryptReader(input.Body, params)
+		defer encryptReader.Close()
+		input.Body = encryptReader
+	}
+	//压缩和加密参数也在metadata里，最后再决定xattr是否放得下
+	var xattrs map[string][]byte
+	if info.IsMetaExist {
+		xattrs = info.FXattr
+	}
+	if err := PutXattrMeta(uploader.S3, Bucket, Key, metadata, xattrs); err != nil {
+		log.Println("Failed to upload:", info.Filename, err)
+		return
+	}
 
-		_, err := uploader.Upload(context.TODO(), &s3.PutObjectInput{
-			Bucket:       aws.String(Bucket),
-			StorageClass: types.StorageClass(*aws.String(storageClass)),
-			Key:          aws.String(Key),
-			Body:         file,
-		})
-		if err != nil {
-			log.Println("Failed to upload:", info.Filename, err)
-		}
+	_, err := uploader.Upload(context.TODO(), input)
+	if err != nil {
+		log.Println("Failed to upload:", info.Filename, err)
+	} else {
+		recordStorageClass(storageClass, info.FSize)
 	}
 
 }
 
-func DownloadS3(downloader *manager.Downloader, file *os.File, Bucket string, Key string) {
+func DownloadS3(downloader *manager.Downloader, file *os.File, Bucket string, Key string, version string, sse *SSEConfig) error {
 
-	_, err := downloader.Download(context.TODO(), file, &s3.GetObjectInput{
-		Bucket: aws.String(Bucket),
-		Key:    aws.String(Key),
-	})
+	input := &s3.GetObjectInput{
+		Bucket:    aws.String(Bucket),
+		Key:       aws.String(Key),
+		VersionId: strOrNil(version),
+	}
+	sse.applyGet(input)
+	_, err := downloader.Download(context.TODO(), file, input)
 	if err != nil {
 		log.Println("Failed to download", Key, err)
 	}
+	return err
 }
 
 func ParseArgs(srcPath string, dstPath string) (string, string, string, string, string) {
@@ -401,31 +589,18 @@ func centerPrint(w int, str string, padding string) {
 
 }
 
-func getResult(fileMap *map[string]FileInfo, successFlag string, failFlag string) (int, int) {
-
-	success := 0
-	fail := 0
-	for filename, info := range *fileMap {
-		if info.CStatus.CopyStatus == successFlag {
-			success++
-		}
-		if info.CStatus.CopyStatus == failFlag {
-			fmt.Println(filename)
-			fail++
-		}
-	}
-	return success, fail
-}
-
-func MD5Obj(client *s3.Client, Bucket string, Key string, partSize int64) []byte {
+func MD5Obj(client *s3.Client, Bucket string, Key string, version string, partSize int64, sse *SSEConfig) []byte {
 
 	downloader := manager.NewDownloader(client, func(u *manager.Downloader) {
 		u.PartSize = partSize * 1024 * 1024
 	})
 
 	fd := CreateTempFile(dataDir, filepath.Base(Key))
+	defer os.Remove(fd.Name())
 	defer fd.Close()
-	DownloadS3(downloader, fd, Bucket, Key)
+	if err := DownloadS3Decode(downloader, fd, Bucket, Key, version, sse); err != nil {
+		return nil
+	}
 
 	m := md5.New()
 	//	var content = make([]byte,100), fd.Read(content),这里不能用fd.read()函数，是因为fd.read会读指定100个字节的数据，即使对象中没有100，content对象仍然是100， 进行hash计算时是以100为基础计算的
@@ -446,40 +621,12 @@ func MD5File(fsrcPath string) []byte {
 
 }
 
-func collectCheckInfo(jobFile string, fileMap *map[string]FileInfo) { //在利用json.Marshal进行序列号时，结构体里的变量必须首字母大写
-
-	fd, err := os.Create(jobFile)
-	if err != nil {
-		log.Println(err)
-	}
-	defer fd.Close()
-
-	b, err := json.Marshal(fileMap)
-	if err != nil {
-		log.Println(err)
-	}
-	_, err = fd.Write(b)
-	if err != nil {
-		log.Println(err)
-	}
-
-}
 func collectIncrCheckInfo(jobFile string, fileMap *map[string]FileInfo, resultMap *map[string]FileInfo) { //在利用json.Marshal进行序列号时，结构体里的变量必须首字母大写
 
-	fd, err := os.Create(jobFile)
-	if err != nil {
-		log.Println(err)
-	}
-	defer fd.Close()
 	for name, value := range *resultMap {
 		(*fileMap)[name] = value
 	}
-	b, err := json.Marshal(fileMap)
-	if err != nil {
-		log.Println(err)
-	}
-	_, err = fd.Write(b)
-	if err != nil {
+	if err := writeJobFile(jobFile, *fileMap); err != nil {
 		log.Println(err)
 	}
 
@@ -489,13 +636,16 @@ func readLastTimeCopyInfo(checkfile string) map[string]FileInfo {
 
 	var fileMap = make(map[string]FileInfo)
 
-	content, err := os.ReadFile(checkfile)
+	fd, err := os.Open(checkfile)
 	if err != nil {
 		return fileMap
 	}
+	defer fd.Close()
 
-	err = json.Unmarshal(content, &fileMap)
-	if err != nil {
+	err = decodeJobState(bufio.NewReader(fd), func(info FileInfo) {
+		fileMap[info.Filename] = info
+	})
+	if err != nil && err != io.EOF {
 		log.Println(err)
 
 	}
@@ -512,43 +662,3 @@ func CreateS3Client(region string) *s3.Client {
 	return s3.NewFromConfig(cfg)
 }
 
-func CheckAttr(SrcCheckMap *map[string]FileInfo, DstCheckMap *map[string]FileInfo, ResultMap *map[string]FileInfo) {
-
-	for name, info := range *SrcCheckMap {
-
-		//在dstPath中没有对应的文件或对象
-		if (*DstCheckMap)[name].Filename == "" {
-			fmt.Printf("%-23s%s\n", "Attributes check fail: ", info.Filename)
-
-			(*ResultMap)[name] = FileInfo{IsMetaExist: info.IsMetaExist, Filename: info.Filename, FUserAgent: info.FUserAgent, FUID: info.FUID, FGID: info.FGID, FType: info.FType, FPerm: info.FPerm, FaTime: info.FaTime, FmTime: info.FmTime, FSize: info.FSize, CStatus: CopyInfo{CopyStatus: "checkFail", Copytime: time.Now().Unix()}}
-
-			continue
-		}
-
-		//找到地应的目标文件或对象
-		//如果是目录或symlink，则直接返回checkPass
-		if (*SrcCheckMap)[name].FType == "0040" || (*SrcCheckMap)[name].FType == "0120" {
-			fmt.Printf("%-23s%s\n", "Attributes check pass: ", info.Filename)
-			(*ResultMap)[name] = FileInfo{IsMetaExist: info.IsMetaExist, Filename: info.Filename, FUserAgent: info.FUserAgent, FUID: info.FUID, FGID: info.FGID, FType: info.FType, FPerm: info.FPerm, FaTime: info.FaTime, FmTime: info.FmTime, FSize: info.FSize, CStatus: CopyInfo{CopyStatus: "checkPass", Copytime: time.Now().Unix()}}
-			continue
-		}
-
-		//如果为文件，则比较大小，和目标对文件或对象的更新时间大于源文件或对象，为什么会出现大于源文件情况，是因为s3上传中生成的文件更新
-		if (*SrcCheckMap)[name].FType == "0100" {
-
-			if (*DstCheckMap)[name].FSize == (*SrcCheckMap)[name].FSize && (*DstCheckMap)[name].FmTime >= (*SrcCheckMap)[name].FmTime {
-				fmt.Printf("%-23s%s\n", "Attributes check pass: ", info.Filename)
-
-				(*ResultMap)[name] = FileInfo{IsMetaExist: info.IsMetaExist, Filename: info.Filename, FUserAgent: info.FUserAgent, FUID: info.FUID, FGID: info.FGID, FType: info.FType, FPerm: info.FPerm, FaTime: info.FaTime, FmTime: info.FmTime, FSize: info.FSize, CStatus: CopyInfo{CopyStatus: "checkPass", Copytime: time.Now().Unix()}}
-
-			} else {
-				fmt.Printf("%-23s%s\n", "Attributes check fail: ", info.Filename)
-
-				(*ResultMap)[name] = FileInfo{IsMetaExist: info.IsMetaExist, Filename: info.Filename, FUserAgent: info.FUserAgent, FUID: info.FUID, FGID: info.FGID, FType: info.FType, FPerm: info.FPerm, FaTime: info.FaTime, FmTime: info.FmTime, FSize: info.FSize, CStatus: CopyInfo{CopyStatus: "checkFail", Copytime: time.Now().Unix()}}
-			}
-
-		}
-
-	}
-
-}
@@ -0,0 +1,133 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+//HeadObject阶段: 列表循环只负责列出对象，读取属性交给-head-procs个goroutine, 结果再交给sink(FileList或检查的列表)
+//列表中的信息足够时不调用HeadObject, 见objInfo
+type headJob struct {
+	Key          string
+	Filename     string //相对路径，目录以/结尾
+	LastModified int64
+	Size         int64
+	Version      string //-inventory中的版本和ETag
+	ETag         string
+	Entry        *ManifestEntry //-files-from清单中的条目
+}
+
+type headStage struct {
+	jobs chan headJob
+	wg   sync.WaitGroup
+}
+
+func listedJob(prefix string, value types.Object) headJob {
+	key := aws.ToString(value.Key)
+	filename, err := filepath.Rel(prefix, key) //在key上去除掉原来的prefix
+	if err != nil {
+		log.Fatalln("Unable to get relative path:", key, err)
+	}
+	if strings.HasSuffix(key, "/") {
+		filename = filename + "/"
+	}
+	return headJob{Key: key, Filename: filename, LastModified: value.LastModified.Unix(), Size: value.Size}
+}
+
+func newHeadStage(fetch func(headJob) FileInfo, sink func(FileInfo)) *headStage {
+	s := &headStage{jobs: make(chan headJob, 4*headProcs)}
+	s.wg.Add(headProcs)
+	for i := 0; i < headProcs; i++ {
+		go func() {
+			defer s.wg.Done()
+			for job := range s.jobs {
+				objInfo := fetch(job)
+				if objInfo.CStatus.CopyStatus == "notFound" {
+					continue //如果获取Key信息的时候报错，就直接跳过这个对象
+				}
+				//这里去掉. ..两个目录
+				if objInfo.Filename == "./" || objInfo.Filename == "../" || objInfo.Filename == ".." || objInfo.Filename == "." {
+					continue
+				}
+				sink(objInfo)
+			}
+		}()
+	}
+	return s
+}
+
+func (s *headStage) Add(job headJob) {
+	s.jobs <- job
+}
+
+//等待所有对象处理完成
+func (s *headStage) Close() {
+	close(s.jobs)
+	s.wg.Wait()
+}
+
+//o2o的CopyObject在服务端复制metadata, 只有按属性选择存储类型、改名或替换header时才需要读取属性
+func copyNeedsAttr() bool {
+	if o2oReplaceHeaders {
+		return true
+	}
+	for _, rule := range storageClassRules {
+		if rule.UID != nil || rule.GID != nil || rule.User != "" || rule.Group != "" || rule.MinAge != "" || rule.MaxAge != "" {
+			return true
+		}
+	}
+	for _, rule := range renameRules {
+		if renameAttrTemplate.MatchString(rule.Replace) {
+			return true
+		}
+	}
+	return false
+}
+
+//增量时，上次HeadObject记录的对象(prev)没有任何metadata, 并且大小和修改时间都没有变化，直接使用记录的属性
+//修改metadata需要重新写入对象，LastModified会变化
+func (f FileWalk) unchangedObjInfo(job headJob, prev FileInfo, ok bool) (FileInfo, bool) {
+	if !f.withAttr || cseKey != nil {
+		return FileInfo{}, false
+	}
+	if !ok || prev.FUserAgent != "admt" || prev.IsMetaExist || len(prev.FUserMeta) > 0 || prev.FPack != nil {
+		return FileInfo{}, false
+	}
+	if prev.FSize != job.Size || prev.FmTime != job.LastModified || prev.FType == "" {
+		return FileInfo{}, false
+	}
+	prev.Filename = job.Filename
+	prev.CStatus = CopyInfo{}
+	return prev, true
+}
+
+//读取对象属性，不带属性时只有客户端加密或压缩才需要HeadObject
+func (f FileWalk) objInfo(client *s3.Client, Bucket string, Prefix string, job headJob, sse *SSEConfig) FileInfo {
+	if f.withAttr {
+		return GetObjMetadata(client, Bucket, Prefix, job.Key, sse)
+	}
+	return GetObjMetadataWithoutAttr(client, Bucket, Prefix, job.Key, job.LastModified, job.Size, sse)
+}
+
+//拷贝阶段源对象的属性
+func (f FileWalk) copyObjInfo(client *s3.Client, job headJob) FileInfo {
+	if f.withAttr && mode == "o2o" && !copyNeedsAttr() {
+		return GetObjMetadataWithoutAttr(client, srcBucket, srcPrefix, job.Key, job.LastModified, job.Size, srcSSE)
+	}
+	if !f.IsInitialCopy {
+		prev, ok := f.FileMap[job.Filename]
+		if objInfo, ok := f.unchangedObjInfo(job, prev, ok); ok {
+			return objInfo
+		}
+	}
+	return f.objInfo(client, srcBucket, srcPrefix, job, srcSSE)
+}
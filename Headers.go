@@ -0,0 +1,153 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+//-header-rules指定的规则文件，json数组，按顺序匹配，第一个匹配的规则生效，例如
+//[
+//  {"pattern": "*.html", "cacheControl": "max-age=300", "contentLanguage": "en"},
+//  {"pattern": "downloads/*", "contentDisposition": "attachment"},
+//  {"pattern": "*.csv.gz", "contentType": "text/csv", "contentEncoding": "gzip"}
+//]
+//pattern为path.Match格式，不含/时匹配文件名，含/时匹配相对路径
+type HeaderRule struct {
+	Pattern            string `json:"pattern"`
+	ContentType        string `json:"contentType"`
+	CacheControl       string `json:"cacheControl"`
+	ContentDisposition string `json:"contentDisposition"`
+	ContentEncoding    string `json:"contentEncoding"`
+	ContentLanguage    string `json:"contentLanguage"`
+}
+
+//对象的http header, 空字符串代表不设置
+type ObjectHeaders struct {
+	ContentType        string
+	CacheControl       string
+	ContentDisposition string
+	ContentEncoding    string
+	ContentLanguage    string
+}
+
+func LoadHeaderRules(rulesFile string) []HeaderRule {
+	content, err := os.ReadFile(rulesFile)
+	if err != nil {
+		log.Fatalln("Failed to read header rules file:", err)
+	}
+	var rules []HeaderRule
+	err = json.Unmarshal(content, &rules)
+	if err != nil {
+		log.Fatalln("Invalid header rules file:", rulesFile, err)
+	}
+	for _, rule := range rules {
+		if !validPattern(rule.Pattern) {
+			log.Fatalln("Invalid pattern in header rules file:", rule.Pattern)
+		}
+	}
+	return rules
+}
+
+func validPattern(pattern string) bool {
+	_, err := path.Match(pattern, "")
+	return err == nil
+}
+
+func matchPattern(pattern string, filename string) bool {
+	filename = strings.TrimSuffix(filename, "/")
+	if !strings.Contains(pattern, "/") {
+		filename = path.Base(filename)
+	}
+	matched, _ := path.Match(pattern, filename)
+	return matched
+}
+
+func matchHeaderRule(filename string) *HeaderRule {
+	for i := range headerRules {
+		if matchPattern(headerRules[i].Pattern, filename) {
+			return &headerRules[i]
+		}
+	}
+	return nil
+}
+
+//先按扩展名判断，扩展名无法判断时读取文件开头的512字节判断，读取后把文件位置恢复到开头
+//不能seek的流(例如tar中的大文件)只按扩展名判断
+func DetectContentType(filename string, body io.Reader) string {
+	if contentType := mime.TypeByExtension(path.Ext(filename)); contentType != "" {
+		return contentType
+	}
+	file, ok := body.(io.ReadSeeker)
+	if contentTypeMode != "auto" || !ok || file == nil {
+		return ""
+	}
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		log.Println("Failed to read file for content type:", filename, err)
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		log.Println("Failed to seek file:", filename, err)
+	}
+	if n == 0 {
+		return ""
+	}
+	return http.DetectContentType(buf[:n])
+}
+
+//规则中指定了Content-Type时优先使用规则
+func GetObjectHeaders(filename string, file io.Reader) ObjectHeaders {
+	var headers ObjectHeaders
+	rule := matchHeaderRule(filename)
+	if rule != nil {
+		headers = ObjectHeaders{rule.ContentType, rule.CacheControl, rule.ContentDisposition, rule.ContentEncoding, rule.ContentLanguage}
+	}
+	if headers.ContentType == "" && contentTypeMode != "none" {
+		headers.ContentType = DetectContentType(filename, file)
+	}
+	return headers
+}
+
+func strOrNil(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
+
+func applyPutHeaders(input *s3.PutObjectInput, headers ObjectHeaders) {
+	input.ContentType = strOrNil(headers.ContentType)
+	input.CacheControl = strOrNil(headers.CacheControl)
+	input.ContentDisposition = strOrNil(headers.ContentDisposition)
+	input.ContentEncoding = strOrNil(headers.ContentEncoding)
+	input.ContentLanguage = strOrNil(headers.ContentLanguage)
+}
+
+//o2o替换header时，CopyObject需要使用REPLACE，这时没有被规则覆盖的header和metadata需要从源对象带过来
+func applyCopyHeaders(input *s3.CopyObjectInput, head *s3.HeadObjectOutput, headers ObjectHeaders) {
+	pick := func(newValue string, oldValue *string) *string {
+		if newValue != "" {
+			return aws.String(newValue)
+		}
+		return oldValue
+	}
+	input.ContentType = pick(headers.ContentType, head.ContentType)
+	input.CacheControl = pick(headers.CacheControl, head.CacheControl)
+	input.ContentDisposition = pick(headers.ContentDisposition, head.ContentDisposition)
+	input.ContentEncoding = pick(headers.ContentEncoding, head.ContentEncoding)
+	input.ContentLanguage = pick(headers.ContentLanguage, head.ContentLanguage)
+	input.Metadata = head.Metadata
+}
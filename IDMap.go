@@ -0,0 +1,170 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+)
+
+//-idmap指定的映射文件，json格式，例如
+//{
+//  "uid": {"1001": 2001},
+//  "gid": {"100": 500},
+//  "byName": true,
+//  "user": {"alice": "bob"},
+//  "group": {"research": "lab"},
+//  "squashUID": 65534,
+//  "squashGID": 65534
+//}
+//uid/gid为数字映射，优先级最高；byName为true时用源端保存的用户名/组名在目标端的passwd/group里查找，user/group可以先把名字改成目标端的名字
+//都没有匹配到时，如果设置了squashUID/squashGID，使用squash的值，否则保持原样
+type IDMap struct {
+	UID       map[string]int    `json:"uid"`
+	GID       map[string]int    `json:"gid"`
+	ByName    bool              `json:"byName"`
+	User      map[string]string `json:"user"`
+	Group     map[string]string `json:"group"`
+	SquashUID *int              `json:"squashUID"`
+	SquashGID *int              `json:"squashGID"`
+}
+
+func LoadIDMap(mapFile string) *IDMap {
+	content, err := os.ReadFile(mapFile)
+	if err != nil {
+		log.Fatalln("Failed to read id map file:", err)
+	}
+	var idMap IDMap
+	err = json.Unmarshal(content, &idMap)
+	if err != nil {
+		log.Fatalln("Invalid id map file:", mapFile, err)
+	}
+	return &idMap
+}
+
+//idMap为nil时不做映射
+func (m *IDMap) MapUID(info FileInfo) int {
+	if m == nil {
+		return info.FUID
+	}
+	if uid, ok := m.UID[strconv.Itoa(info.FUID)]; ok {
+		return uid
+	}
+	if m.ByName && info.FUser != "" {
+		name := info.FUser
+		if newName, ok := m.User[name]; ok {
+			name = newName
+		}
+		if uid, ok := lookupUID(name); ok {
+			return uid
+		}
+	}
+	if m.SquashUID != nil {
+		return *m.SquashUID
+	}
+	return info.FUID
+}
+
+func (m *IDMap) MapGID(info FileInfo) int {
+	if m == nil {
+		return info.FGID
+	}
+	if gid, ok := m.GID[strconv.Itoa(info.FGID)]; ok {
+		return gid
+	}
+	if m.ByName && info.FGroup != "" {
+		name := info.FGroup
+		if newName, ok := m.Group[name]; ok {
+			name = newName
+		}
+		if gid, ok := lookupGID(name); ok {
+			return gid
+		}
+	}
+	if m.SquashGID != nil {
+		return *m.SquashGID
+	}
+	return info.FGID
+}
+
+//目标为文件系统时，chown使用映射后的id，所以检查时也用映射后的id比较
+func ownerEqual(src FileInfo, dst FileInfo) bool {
+	if mode == "o2f" || mode == "f2f" {
+		return idMap.MapUID(src) == dst.FUID && idMap.MapGID(src) == dst.FGID
+	}
+	return src.FUID == dst.FUID && src.FGID == dst.FGID
+}
+
+//每个文件都要查找，所以缓存查找结果，不存在的名字也缓存
+var nameCache sync.Map
+
+type nameCacheValue struct {
+	value string
+	ok    bool
+}
+
+func cachedLookup(cacheKey string, lookup func() (string, bool)) (string, bool) {
+	if v, ok := nameCache.Load(cacheKey); ok {
+		return v.(nameCacheValue).value, v.(nameCacheValue).ok
+	}
+	value, ok := lookup()
+	nameCache.Store(cacheKey, nameCacheValue{value, ok})
+	return value, ok
+}
+
+func lookupUserName(uid int) string {
+	name, _ := cachedLookup("uid:"+strconv.Itoa(uid), func() (string, bool) {
+		u, err := user.LookupId(strconv.Itoa(uid))
+		if err != nil {
+			return "", false
+		}
+		return u.Username, true
+	})
+	return name
+}
+
+func lookupGroupName(gid int) string {
+	name, _ := cachedLookup("gid:"+strconv.Itoa(gid), func() (string, bool) {
+		g, err := user.LookupGroupId(strconv.Itoa(gid))
+		if err != nil {
+			return "", false
+		}
+		return g.Name, true
+	})
+	return name
+}
+
+func lookupUID(name string) (int, bool) {
+	value, ok := cachedLookup("user:"+name, func() (string, bool) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", false
+		}
+		return u.Uid, true
+	})
+	if !ok {
+		return 0, false
+	}
+	uid, err := strconv.Atoi(value)
+	return uid, err == nil
+}
+
+func lookupGID(name string) (int, bool) {
+	value, ok := cachedLookup("group:"+name, func() (string, bool) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", false
+		}
+		return g.Gid, true
+	})
+	if !ok {
+		return 0, false
+	}
+	gid, err := strconv.Atoi(value)
+	return gid, err == nil
+}
@@ -0,0 +1,365 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+//-inventory指定S3 Inventory的manifest.json, 本地路径或s3://bucket/key, 代替ListObjectsV2列出源bucket
+//数据文件按manifest中的key读取: manifest在本地时先查找同目录和../data/下的同名文件，找不到时从inventory的目标bucket下载
+//支持CSV, ORC和Parquet格式，ORC和Parquet的读取见InventoryORC.go和InventoryParquet.go
+//包含版本的inventory只使用最新版本，跳过删除标记；GLACIER和DEEP_ARCHIVE中的对象需要先恢复，这里跳过
+type InventoryManifest struct {
+	SourceBucket      string `json:"sourceBucket"`
+	DestinationBucket string `json:"destinationBucket"`
+	FileFormat        string `json:"fileFormat"`
+	FileSchema        string `json:"fileSchema"`
+	Files             []struct {
+		Key         string `json:"key"`
+		Size        int64  `json:"size"`
+		MD5checksum string `json:"MD5checksum"`
+	} `json:"files"`
+}
+
+type InventoryRecord struct {
+	Key          string
+	VersionID    string
+	Size         int64
+	LastModified int64
+	ETag         string
+	StorageClass string
+}
+
+func readInventoryObject(client *s3.Client, Bucket string, Key string) []byte {
+	output, err := client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(Bucket),
+		Key:    aws.String(Key),
+	})
+	if err != nil {
+		log.Fatalln("Failed to read inventory:", Key, err)
+	}
+	defer output.Body.Close()
+	content, err := io.ReadAll(output.Body)
+	if err != nil {
+		log.Fatalln("Failed to read inventory:", Key, err)
+	}
+	return content
+}
+
+func LoadInventoryManifest(client *s3.Client, location string) InventoryManifest {
+	var content []byte
+	if m := s3URI.FindStringSubmatch(location); m != nil {
+		content = readInventoryObject(client, m[1], m[2])
+	} else {
+		var err error
+		content, err = os.ReadFile(location)
+		if err != nil {
+			log.Fatalln("Failed to read inventory:", err)
+		}
+	}
+	var manifest InventoryManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		log.Fatalln("Invalid inventory manifest:", location, err)
+	}
+	switch manifest.FileFormat {
+	case "CSV", "ORC", "Parquet":
+	default:
+		log.Fatalln("Unknown inventory format", manifest.FileFormat, "in", location)
+	}
+	if manifest.SourceBucket != srcBucket {
+		log.Fatalln("Inventory is for bucket", manifest.SourceBucket, "not for", srcBucket)
+	}
+	return manifest
+}
+
+//数据文件的本地路径，从s3下载时返回临时文件，用完后删除
+func inventoryDataFile(client *s3.Client, location string, manifest InventoryManifest, key string) (string, bool) {
+	if s3URI.FindStringSubmatch(location) == nil {
+		dir := filepath.Dir(location)
+		for _, candidate := range []string{filepath.Join(dir, filepath.Base(key)), filepath.Join(dir, "..", "data", filepath.Base(key))} {
+			if _, err := os.Stat(candidate); err == nil {
+				return candidate, false
+			}
+		}
+	}
+	bucket := strings.TrimPrefix(manifest.DestinationBucket, "arn:aws:s3:::")
+	fd := CreateTempFile(dataDir, filepath.Base(key))
+	defer fd.Close()
+	downloader := manager.NewDownloader(client)
+	_, err := downloader.Download(context.TODO(), fd, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		os.Remove(fd.Name())
+		log.Fatalln("Failed to read inventory:", key, err)
+	}
+	return fd.Name(), true
+}
+
+func verifyInventoryFile(name string, checksum string) {
+	if checksum == "" {
+		return
+	}
+	fd, err := os.Open(name)
+	if err != nil {
+		log.Fatalln("Failed to read inventory:", err)
+	}
+	defer fd.Close()
+	m := md5.New()
+	if _, err := io.Copy(m, fd); err != nil {
+		log.Fatalln("Failed to read inventory:", err)
+	}
+	if hex.EncodeToString(m.Sum(nil)) != checksum {
+		log.Fatalln("Inventory file is corrupted, MD5 does not match the manifest:", name)
+	}
+}
+
+//按顺序读取所有数据文件，源prefix下的每个对象调用一次fn
+func ReadInventory(client *s3.Client, location string, fn func(InventoryRecord)) {
+	manifest := LoadInventoryManifest(client, location)
+	columns := map[string]int{}
+	if manifest.FileFormat == "CSV" { //ORC和Parquet的数据文件中有自己的schema
+		for i, name := range strings.Split(manifest.FileSchema, ",") {
+			columns[strings.TrimSpace(name)] = i
+		}
+		if _, ok := columns["Key"]; !ok {
+			log.Fatalln("Inventory schema has no Key field:", manifest.FileSchema)
+		}
+	}
+
+	for _, file := range manifest.Files {
+		name, isTemp := inventoryDataFile(client, location, manifest, file.Key)
+		verifyInventoryFile(name, file.MD5checksum)
+		switch manifest.FileFormat {
+		case "ORC":
+			readInventoryORC(name, fn)
+		case "Parquet":
+			readInventoryParquet(name, fn)
+		default:
+			readInventoryCSV(name, columns, fn)
+		}
+		if isTemp {
+			os.Remove(name)
+		}
+	}
+}
+
+func readInventoryCSV(name string, columns map[string]int, fn func(InventoryRecord)) {
+	fd, err := os.Open(name)
+	if err != nil {
+		log.Fatalln("Failed to read inventory:", err)
+	}
+	defer fd.Close()
+	var r io.Reader = fd
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(fd)
+		if err != nil {
+			log.Fatalln("Failed to read inventory:", name, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Fatalln("Failed to read inventory:", name, err)
+		}
+		field := func(column string) string {
+			i, ok := columns[column]
+			if !ok || i >= len(row) {
+				return ""
+			}
+			return row[i]
+		}
+
+		key, err := url.QueryUnescape(field("Key")) //CSV中的key经过URL编码
+		if err != nil {
+			log.Println("Invalid key in inventory:", field("Key"))
+			continue
+		}
+		inventoryRow(key, field, fn)
+	}
+}
+
+//ORC和Parquet中的字段名，对应CSV的表头
+var inventoryFields = map[string]string{
+	"key":                             "Key",
+	"version_id":                      "VersionId",
+	"is_latest":                       "IsLatest",
+	"is_delete_marker":                "IsDeleteMarker",
+	"size":                            "Size",
+	"last_modified_date":              "LastModifiedDate",
+	"e_tag":                           "ETag",
+	"storage_class":                   "StorageClass",
+	"intelligent_tiering_access_tier": "IntelligentTieringAccessTier",
+}
+
+//输出ORC的一个stripe或Parquet的一个row group, values为CSV字段名到每一行的值
+func emitInventoryRows(rows int, values map[string][]string, fn func(InventoryRecord)) {
+	for i := 0; i < rows; i++ {
+		field := func(column string) string {
+			if v := values[column]; i < len(v) {
+				return v[i]
+			}
+			return ""
+		}
+		inventoryRow(field("Key"), field, fn)
+	}
+}
+
+//inventory中的一行，field按CSV的字段名取值，布尔值为true/false, 时间为RFC3339格式
+func inventoryRow(key string, field func(column string) string, fn func(InventoryRecord)) {
+	if !strings.HasPrefix(key, srcPrefix) || key == srcPrefix {
+		return
+	}
+	if field("IsLatest") == "false" || field("IsDeleteMarker") == "true" {
+		return
+	}
+	record := InventoryRecord{Key: key, VersionID: field("VersionId"), ETag: field("ETag"), StorageClass: field("StorageClass")}
+	if tier := field("IntelligentTieringAccessTier"); record.StorageClass == "GLACIER" || record.StorageClass == "DEEP_ARCHIVE" || strings.HasSuffix(tier, "ARCHIVE_ACCESS") {
+		log.Println("Skip archived object, please restore it first:", key)
+		return
+	}
+	record.Size, _ = strconv.ParseInt(field("Size"), 10, 64)
+	if t, err := time.Parse(time.RFC3339Nano, field("LastModifiedDate")); err == nil {
+		record.LastModified = t.Unix()
+	}
+	fn(record)
+}
+
+func inventoryJob(record InventoryRecord) headJob {
+	return headJob{Key: record.Key, Filename: strings.TrimPrefix(record.Key, srcPrefix), LastModified: record.LastModified, Size: record.Size, Version: record.VersionID, ETag: record.ETag}
+}
+
+func jobRecord(job headJob) InventoryRecord {
+	return InventoryRecord{Key: job.Key, VersionID: job.Version, Size: job.Size, LastModified: job.LastModified, ETag: job.ETag}
+}
+
+//inventory中已经有大小和修改时间，只有带属性或客户端加密时才需要HeadObject
+func inventoryObjInfo(client *s3.Client, record InventoryRecord, withAttr bool) FileInfo {
+	isDir := strings.HasSuffix(record.Key, "/")
+	var objInfo FileInfo
+	if withAttr {
+		objInfo = GetObjVersionMetadata(client, srcBucket, srcPrefix, record.Key, record.VersionID, srcSSE)
+	} else if cseKey != nil && !isDir {
+		objInfo = headObjInfo(client, srcBucket, srcPrefix, record.Key, record.VersionID, srcSSE)
+	} else {
+		filename := strings.TrimPrefix(record.Key, srcPrefix)
+		objInfo = FileInfo{IsMetaExist: false, Filename: filename, FType: "0100", FmTime: record.LastModified, FSize: record.Size}
+		if isDir {
+			objInfo.FType = "0040"
+		}
+	}
+	objInfo.FVersion = record.VersionID
+	objInfo.FETag = strings.Trim(record.ETag, `"`)
+	return objInfo
+}
+
+//读取inventory，代替Listobj把待拷贝的对象放入FileList
+func (f FileWalk) ListInventory(client *s3.Client, location string) {
+	//需要HeadObject时和Listobj一样由headStage并发读取
+	heads := newHeadStage(func(job headJob) FileInfo {
+		return inventoryObjInfo(client, jobRecord(job), f.withAttr)
+	}, func(objInfo FileInfo) {
+		f.FileList <- objInfo
+	})
+	defer heads.Close() //在展开bundle的Flush之后
+	var packs *packExpander
+	if expandPacks() {
+		packs = newPackExpander(client, srcBucket, srcSSE, f.sendPacked)
+		defer packs.Flush()
+	}
+
+	ReadInventory(client, location, func(record InventoryRecord) {
+		if isXattrSidecar(record.Key) {
+			return
+		}
+		job := inventoryJob(record)
+		send := func() {
+			if !f.IsInitialCopy && f.FileMap[job.Filename].CStatus.CopyStatus == "checkPass" {
+				return
+			}
+			heads.Add(job)
+		}
+		if packs != nil {
+			packs.Add(record.Key, record.LastModified, send) //bundle展开为其中的文件
+		} else {
+			send()
+		}
+	})
+}
+
+//检查时用inventory代替ListobjforSrcCheck, incr为true时只记录名字，归并后再读取属性，见listobjforCheck
+func (f FileWalk) InventoryforSrcCheck(client *s3.Client, location string, incr bool) {
+	heads := newHeadStage(func(job headJob) FileInfo {
+		objInfo := inventoryObjInfo(client, jobRecord(job), f.withAttr)
+		if objInfo.CStatus.CopyStatus == "notFound" {
+			return objInfo
+		}
+		return withObjTags(client, srcBucket, job.Key, objInfo)
+	}, f.addSrcCheck)
+	defer heads.Close()
+	var packs *packExpander
+	if expandPacks() {
+		packs = newPackExpander(client, srcBucket, srcSSE, f.addSrcCheck)
+		defer packs.Flush()
+	}
+	lazy := incr && lazySrcCheck()
+
+	ReadInventory(client, location, func(record InventoryRecord) {
+		if isXattrSidecar(record.Key) {
+			return
+		}
+		job := inventoryJob(record)
+		send := func() {
+			if lazy {
+				f.addSrcEntry(FileInfo{Filename: job.Filename}, &checkFetch{Key: job.Key, LastModified: job.LastModified, Size: job.Size, Version: job.Version, ETag: job.ETag})
+				return
+			}
+			heads.Add(job)
+		}
+		if packs != nil {
+			packs.Add(record.Key, record.LastModified, send)
+		} else {
+			send()
+		}
+	})
+}
+
+//inventory中的ETag为对象的MD5时(非分段上传)返回MD5, 否则返回nil
+func etagMD5(info FileInfo) []byte {
+	if info.FPack != nil || len(info.FETag) != 32 {
+		return nil
+	}
+	sum, err := hex.DecodeString(info.FETag)
+	if err != nil {
+		return nil
+	}
+	return sum
+}
@@ -0,0 +1,823 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+//ORC格式的inventory数据文件，按ORC v1的规范读取，只解码inventory需要的顶层列
+//文件末尾是PostScript和protobuf编码的Footer, 每个stripe中需要的列整块读入内存，再按行输出
+//整数支持RLE v1和v2, 字符串支持DIRECT和DICTIONARY, 压缩支持NONE, ZLIB, SNAPPY和ZSTD
+
+var errORCShort = errors.New("truncated ORC data")
+
+//解码后的protobuf message, varint和定长字段放在ints, length-delimited字段放在bytes
+type protoMessage struct {
+	ints  map[int][]uint64
+	bytes map[int][][]byte
+}
+
+func parseProto(b []byte) (protoMessage, error) {
+	m := protoMessage{ints: map[int][]uint64{}, bytes: map[int][][]byte{}}
+	for pos := 0; pos < len(b); {
+		key, n := binary.Uvarint(b[pos:])
+		if n <= 0 {
+			return m, errORCShort
+		}
+		pos += n
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0: //varint
+			v, n := binary.Uvarint(b[pos:])
+			if n <= 0 {
+				return m, errORCShort
+			}
+			pos += n
+			m.ints[field] = append(m.ints[field], v)
+		case 1: //64位
+			if len(b)-pos < 8 {
+				return m, errORCShort
+			}
+			m.ints[field] = append(m.ints[field], binary.LittleEndian.Uint64(b[pos:]))
+			pos += 8
+		case 2: //length-delimited
+			l, n := binary.Uvarint(b[pos:])
+			if n <= 0 || l > uint64(len(b)-pos-n) {
+				return m, errORCShort
+			}
+			pos += n
+			m.bytes[field] = append(m.bytes[field], b[pos:pos+int(l)])
+			pos += int(l)
+		case 5: //32位
+			if len(b)-pos < 4 {
+				return m, errORCShort
+			}
+			m.ints[field] = append(m.ints[field], uint64(binary.LittleEndian.Uint32(b[pos:])))
+			pos += 4
+		default:
+			return m, fmt.Errorf("unsupported protobuf wire type %d", key&7)
+		}
+	}
+	return m, nil
+}
+
+//字段的最后一个值，没有时为0
+func (m protoMessage) num(field int) uint64 {
+	v := m.ints[field]
+	if len(v) == 0 {
+		return 0
+	}
+	return v[len(v)-1]
+}
+
+//repeated的整数字段，packed和非packed两种写法
+func (m protoMessage) nums(field int) ([]uint64, error) {
+	out := append([]uint64{}, m.ints[field]...)
+	for _, b := range m.bytes[field] {
+		for pos := 0; pos < len(b); {
+			v, n := binary.Uvarint(b[pos:])
+			if n <= 0 {
+				return nil, errORCShort
+			}
+			out = append(out, v)
+			pos += n
+		}
+	}
+	return out, nil
+}
+
+func (m protoMessage) strs(field int) []string {
+	var out []string
+	for _, b := range m.bytes[field] {
+		out = append(out, string(b))
+	}
+	return out
+}
+
+func (m protoMessage) messages(field int) ([]protoMessage, error) {
+	var out []protoMessage
+	for _, b := range m.bytes[field] {
+		msg, err := parseProto(b)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, msg)
+	}
+	return out, nil
+}
+
+type orcFile struct {
+	fd          *os.File
+	size        uint64
+	compression uint64 //0 NONE, 1 ZLIB, 2 SNAPPY, 5 ZSTD
+	blockSize   int    //一个chunk解压后的最大大小
+}
+
+//ORC的一个顶层列
+type orcColumn struct {
+	field string //对应的CSV字段名
+	id    int    //列在类型树中的编号
+	kind  uint64 //Type.Kind
+}
+
+func readInventoryORC(name string, fn func(InventoryRecord)) {
+	fd, err := os.Open(name)
+	if err != nil {
+		log.Fatalln("Failed to open inventory:", name, err)
+	}
+	defer fd.Close()
+	o := &orcFile{fd: fd}
+	footer, err := o.footer()
+	if err != nil {
+		log.Fatalln("Failed to read inventory:", name, err)
+	}
+	columns, err := orcColumns(footer)
+	if err != nil {
+		log.Fatalln("Failed to read inventory:", name, err)
+	}
+	stripes, err := footer.messages(3)
+	if err != nil {
+		log.Fatalln("Failed to read inventory:", name, err)
+	}
+	for _, stripe := range stripes {
+		rows := int(stripe.num(5))
+		values, err := o.readStripe(stripe, columns)
+		if err != nil {
+			log.Fatalln("Failed to read inventory:", name, err)
+		}
+		emitInventoryRows(rows, values, fn)
+	}
+}
+
+//读取文件末尾: Footer, PostScript, 1字节的PostScript长度
+func (o *orcFile) footer() (protoMessage, error) {
+	st, err := o.fd.Stat()
+	if err != nil {
+		return protoMessage{}, err
+	}
+	size := uint64(st.Size())
+	o.size = size
+	if size < 4 {
+		return protoMessage{}, errors.New("not an ORC file")
+	}
+	tail := make([]byte, 1)
+	if _, err := o.fd.ReadAt(tail, int64(size-1)); err != nil {
+		return protoMessage{}, err
+	}
+	psLen := uint64(tail[0])
+	if psLen+1 > size {
+		return protoMessage{}, errORCShort
+	}
+	b, err := o.read(size-1-psLen, psLen)
+	if err != nil {
+		return protoMessage{}, err
+	}
+	ps, err := parseProto(b)
+	if err != nil {
+		return protoMessage{}, err
+	}
+	if magic := ps.strs(8000); len(magic) == 0 || magic[0] != "ORC" {
+		return protoMessage{}, errors.New("not an ORC file")
+	}
+	o.compression = ps.num(2)
+	o.blockSize = 256 << 10 //PostScript中没有时的默认值
+	if b := ps.num(3); b > 0 {
+		if b > zstdMaxMemory {
+			return protoMessage{}, fmt.Errorf("invalid compression block size %d", b)
+		}
+		o.blockSize = int(b)
+	}
+	footerLen := ps.num(1)
+	if footerLen+psLen+1 > size {
+		return protoMessage{}, errORCShort
+	}
+	if b, err = o.read(size-1-psLen-footerLen, footerLen); err != nil {
+		return protoMessage{}, err
+	}
+	if b, err = o.decompress(b); err != nil {
+		return protoMessage{}, err
+	}
+	return parseProto(b)
+}
+
+func (o *orcFile) read(offset, length uint64) ([]byte, error) {
+	if offset > o.size || length > o.size-offset {
+		return nil, fmt.Errorf("invalid ORC section at %d, length %d", offset, length)
+	}
+	b := make([]byte, length)
+	if _, err := o.fd.ReadAt(b, int64(offset)); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+//压缩的数据分成多个chunk, 每个chunk有3字节的头: 长度<<1 | 是否未压缩
+func (o *orcFile) decompress(b []byte) ([]byte, error) {
+	if o.compression == 0 {
+		return b, nil
+	}
+	var out []byte
+	for pos := 0; pos < len(b); {
+		if len(b)-pos < 3 {
+			return nil, errORCShort
+		}
+		h := int(b[pos]) | int(b[pos+1])<<8 | int(b[pos+2])<<16
+		pos += 3
+		n := h >> 1
+		if n > len(b)-pos {
+			return nil, errORCShort
+		}
+		chunk := b[pos : pos+n]
+		pos += n
+		if h&1 == 1 {
+			out = append(out, chunk...)
+			continue
+		}
+		var d []byte
+		var err error
+		switch o.compression {
+		case 1: //ZLIB, 没有zlib头的deflate
+			d, err = io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(chunk)), int64(o.blockSize)+1))
+		case 2:
+			if n, err := snappy.DecodedLen(chunk); err != nil || n > o.blockSize {
+				return nil, fmt.Errorf("snappy chunk of %d bytes, larger than the block size %d: %v", n, o.blockSize, err)
+			}
+			d, err = snappy.Decode(nil, chunk)
+		case 5:
+			d, err = zstdDecodeAll(chunk, 0)
+		default:
+			return nil, fmt.Errorf("unsupported ORC compression %d", o.compression)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(d) > o.blockSize {
+			return nil, fmt.Errorf("chunk decompressed to %d bytes, larger than the block size %d", len(d), o.blockSize)
+		}
+		out = append(out, d...)
+	}
+	return out, nil
+}
+
+//根类型(struct)中inventory需要的字段
+func orcColumns(footer protoMessage) ([]orcColumn, error) {
+	types, err := footer.messages(4)
+	if err != nil {
+		return nil, err
+	}
+	if len(types) == 0 || types[0].num(1) != 12 {
+		return nil, errors.New("the root type is not a struct")
+	}
+	subtypes, err := types[0].nums(2)
+	if err != nil {
+		return nil, err
+	}
+	var columns []orcColumn
+	hasKey := false
+	for i, name := range types[0].strs(3) {
+		field, ok := inventoryFields[strings.ToLower(name)]
+		if !ok || i >= len(subtypes) || subtypes[i] >= uint64(len(types)) {
+			continue
+		}
+		hasKey = hasKey || field == "Key"
+		columns = append(columns, orcColumn{field: field, id: int(subtypes[i]), kind: types[subtypes[i]].num(1)})
+	}
+	if !hasKey {
+		return nil, errors.New("no key column")
+	}
+	return columns, nil
+}
+
+//读取一个stripe中需要的列，返回CSV字段名到每一行的值
+func (o *orcFile) readStripe(stripe protoMessage, columns []orcColumn) (map[string][]string, error) {
+	offset, indexLen, dataLen, footerLen := stripe.num(1), stripe.num(2), stripe.num(3), stripe.num(4)
+	rows := int(stripe.num(5))
+	if rows < 0 {
+		return nil, fmt.Errorf("invalid row count %d", rows)
+	}
+	b, err := o.read(offset+indexLen+dataLen, footerLen)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = o.decompress(b); err != nil {
+		return nil, err
+	}
+	sf, err := parseProto(b)
+	if err != nil {
+		return nil, err
+	}
+	streams, err := sf.messages(1)
+	if err != nil {
+		return nil, err
+	}
+	encodings, err := sf.messages(2)
+	if err != nil {
+		return nil, err
+	}
+	loc := time.UTC
+	if tz := sf.strs(3); len(tz) > 0 && tz[0] != "" {
+		if loc, err = time.LoadLocation(tz[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	//stream按顺序从stripe的开头排列，索引的stream在前面
+	type section struct{ offset, length uint64 }
+	located := map[[2]uint64]section{}
+	pos := offset
+	for _, s := range streams {
+		located[[2]uint64{s.num(2), s.num(1)}] = section{pos, s.num(3)}
+		pos += s.num(3)
+	}
+	values := map[string][]string{}
+	for _, col := range columns {
+		if col.id >= len(encodings) {
+			return nil, fmt.Errorf("no encoding for column %d", col.id)
+		}
+		stream := func(kind uint64) ([]byte, error) {
+			s, ok := located[[2]uint64{uint64(col.id), kind}]
+			if !ok {
+				return nil, nil
+			}
+			b, err := o.read(s.offset, s.length)
+			if err != nil {
+				return nil, err
+			}
+			return o.decompress(b)
+		}
+		v, err := readORCColumn(col.kind, encodings[col.id], stream, rows, loc)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %v", col.field, err)
+		}
+		values[col.field] = v
+	}
+	return values, nil
+}
+
+//stream的类型
+const (
+	orcPresent        = 0
+	orcData           = 1
+	orcLength         = 2
+	orcDictionaryData = 3
+	orcSecondary      = 5
+)
+
+//解码一列，null为空字符串, 布尔值为true/false, 时间为RFC3339格式
+func readORCColumn(kind uint64, encoding protoMessage, stream func(kind uint64) ([]byte, error), rows int, loc *time.Location) ([]string, error) {
+	present, err := stream(orcPresent)
+	if err != nil {
+		return nil, err
+	}
+	var isPresent []bool
+	n := rows
+	if present != nil {
+		if isPresent, err = decodeORCBooleans(present, rows); err != nil {
+			return nil, err
+		}
+		n = 0
+		for _, p := range isPresent {
+			if p {
+				n++
+			}
+		}
+	}
+	data, err := stream(orcData)
+	if err != nil {
+		return nil, err
+	}
+	v1 := encoding.num(1) == 0 || encoding.num(1) == 1 //DIRECT和DICTIONARY使用RLE v1
+	var values []string
+	switch kind {
+	case 0: //BOOLEAN
+		bools, err := decodeORCBooleans(data, n)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range bools {
+			values = append(values, strconv.FormatBool(b))
+		}
+	case 1: //BYTE
+		bs, err := decodeORCBytes(data, n)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range bs {
+			values = append(values, strconv.Itoa(int(int8(b))))
+		}
+	case 2, 3, 4: //SHORT, INT, LONG
+		ints, err := decodeORCInts(data, n, true, v1)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range ints {
+			values = append(values, strconv.FormatInt(v, 10))
+		}
+	case 7, 8, 16, 17: //STRING, BINARY, VARCHAR, CHAR
+		lengthData, err := stream(orcLength)
+		if err != nil {
+			return nil, err
+		}
+		if e := encoding.num(1); e == 1 || e == 3 { //DICTIONARY: DATA是字典下标
+			dictData, err := stream(orcDictionaryData)
+			if err != nil {
+				return nil, err
+			}
+			dict, err := splitORCStrings(dictData, lengthData, int(encoding.num(2)), v1)
+			if err != nil {
+				return nil, err
+			}
+			idx, err := decodeORCInts(data, n, false, v1)
+			if err != nil {
+				return nil, err
+			}
+			for _, i := range idx {
+				if i < 0 || i >= int64(len(dict)) {
+					return nil, fmt.Errorf("dictionary index %d out of range", i)
+				}
+				values = append(values, dict[i])
+			}
+		} else if values, err = splitORCStrings(data, lengthData, n, v1); err != nil {
+			return nil, err
+		}
+	case 9, 18: //TIMESTAMP: DATA是2015-01-01以来的秒数, SECONDARY是纳秒; TIMESTAMP_INSTANT使用UTC
+		if kind == 18 {
+			loc = time.UTC
+		}
+		secs, err := decodeORCInts(data, n, true, v1)
+		if err != nil {
+			return nil, err
+		}
+		nanoData, err := stream(orcSecondary)
+		if err != nil {
+			return nil, err
+		}
+		nanos, err := decodeORCInts(nanoData, n, false, v1)
+		if err != nil {
+			return nil, err
+		}
+		base := time.Date(2015, 1, 1, 0, 0, 0, 0, loc).Unix()
+		for i, s := range secs {
+			ns := nanos[i] >> 3
+			if zeros := nanos[i] & 7; zeros != 0 { //低3位是省略的末尾0的个数减1
+				for z := int64(0); z <= zeros; z++ {
+					ns *= 10
+				}
+			}
+			values = append(values, time.Unix(base+s, ns).UTC().Format(time.RFC3339Nano))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported column type %d", kind)
+	}
+	if isPresent == nil {
+		return values, nil
+	}
+	out := make([]string, 0, allocHint(rows))
+	for _, p := range isPresent {
+		if p {
+			out = append(out, values[0])
+			values = values[1:]
+		} else {
+			out = append(out, "")
+		}
+	}
+	return out, nil
+}
+
+//n个字符串: LENGTH是每个的长度，data是所有的内容
+func splitORCStrings(data, lengthData []byte, n int, v1 bool) ([]string, error) {
+	lengths, err := decodeORCInts(lengthData, n, false, v1)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, allocHint(n))
+	for _, l := range lengths {
+		if l < 0 || l > int64(len(data)) {
+			return nil, errORCShort
+		}
+		out = append(out, string(data[:l]))
+		data = data[l:]
+	}
+	return out, nil
+}
+
+//byte RLE: 头为0..127时重复下一个字节头+3次，为负数时后面有-头个字节
+func decodeORCBytes(b []byte, n int) ([]byte, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("invalid value count %d", n)
+	}
+	out := make([]byte, 0, allocHint(n))
+	for pos := 0; len(out) < n; {
+		if pos >= len(b) {
+			return nil, errORCShort
+		}
+		h := int8(b[pos])
+		pos++
+		if h >= 0 {
+			if pos >= len(b) {
+				return nil, errORCShort
+			}
+			for i := 0; i < int(h)+3; i++ {
+				out = append(out, b[pos])
+			}
+			pos++
+		} else {
+			l := -int(h)
+			if l > len(b)-pos {
+				return nil, errORCShort
+			}
+			out = append(out, b[pos:pos+l]...)
+			pos += l
+		}
+	}
+	return out[:n], nil
+}
+
+//boolean RLE: byte RLE的每个字节从高位开始是8个值
+func decodeORCBooleans(b []byte, n int) ([]bool, error) {
+	bs, err := decodeORCBytes(b, (n+7)/8)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]bool, n)
+	for i := range out {
+		out[i] = bs[i/8]>>(7-i%8)&1 == 1
+	}
+	return out, nil
+}
+
+func decodeORCInts(b []byte, n int, signed bool, v1 bool) ([]int64, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("invalid value count %d", n)
+	}
+	if v1 {
+		return decodeRLEv1(b, n, signed)
+	}
+	return decodeRLEv2(b, n, signed)
+}
+
+//base 128 varint, signed时为zigzag编码
+func readORCVarint(b []byte, signed bool) (int64, int, error) {
+	v, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, 0, errORCShort
+	}
+	if signed {
+		return int64(v>>1) ^ -int64(v&1), n, nil
+	}
+	return int64(v), n, nil
+}
+
+//RLE v1: 头为0..127时是头+3个值的等差数列(1字节的差和varint的起始值)，为负数时后面有-头个varint
+func decodeRLEv1(b []byte, n int, signed bool) ([]int64, error) {
+	out := make([]int64, 0, allocHint(n))
+	for pos := 0; len(out) < n; {
+		if pos >= len(b) {
+			return nil, errORCShort
+		}
+		h := int8(b[pos])
+		pos++
+		if h >= 0 {
+			if pos >= len(b) {
+				return nil, errORCShort
+			}
+			delta := int64(int8(b[pos]))
+			pos++
+			base, k, err := readORCVarint(b[pos:], signed)
+			if err != nil {
+				return nil, err
+			}
+			pos += k
+			for i := 0; i < int(h)+3; i++ {
+				out = append(out, base+int64(i)*delta)
+			}
+		} else {
+			for i := 0; i < -int(h); i++ {
+				v, k, err := readORCVarint(b[pos:], signed)
+				if err != nil {
+					return nil, err
+				}
+				pos += k
+				out = append(out, v)
+			}
+		}
+	}
+	return out[:n], nil
+}
+
+//RLE v2中5位的位宽编码
+func orcWidth(code byte) int {
+	switch {
+	case code < 24:
+		return int(code) + 1
+	case code == 24:
+		return 26
+	case code == 25:
+		return 28
+	case code == 26:
+		return 30
+	case code == 27:
+		return 32
+	case code == 28:
+		return 40
+	case code == 29:
+		return 48
+	case code == 30:
+		return 56
+	}
+	return 64
+}
+
+//不小于n的可用位宽
+func orcClosestWidth(n int) int {
+	for _, w := range []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 26, 28, 30, 32, 40, 48, 56} {
+		if n <= w {
+			return w
+		}
+	}
+	return 64
+}
+
+//count个width位的值，高位在前，返回值和用掉的字节数
+func unpackBE(b []byte, count int, width int) ([]uint64, int, error) {
+	size := (count*width + 7) / 8
+	if size > len(b) {
+		return nil, 0, errORCShort
+	}
+	out := make([]uint64, count)
+	bit := 0
+	for i := range out {
+		var v uint64
+		for got := 0; got < width; {
+			shift := bit % 8
+			take := 8 - shift
+			if take > width-got {
+				take = width - got
+			}
+			v = v<<take | uint64(b[bit/8]>>(8-shift-take)&(1<<take-1))
+			got += take
+			bit += take
+		}
+		out[i] = v
+	}
+	return out, size, nil
+}
+
+func bigEndianUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func zigzag(v uint64, signed bool) int64 {
+	if signed {
+		return int64(v>>1) ^ -int64(v&1)
+	}
+	return int64(v)
+}
+
+//RLE v2, 头字节的高2位是编码: SHORT_REPEAT, DIRECT, PATCHED_BASE, DELTA
+func decodeRLEv2(b []byte, n int, signed bool) ([]int64, error) {
+	out := make([]int64, 0, allocHint(n))
+	for pos := 0; len(out) < n; {
+		if pos >= len(b) {
+			return nil, errORCShort
+		}
+		first := b[pos]
+		if first>>6 == 0 { //SHORT_REPEAT: 3位的字节数，3位的重复次数-3
+			width := int(first>>3&7) + 1
+			pos++
+			if width > len(b)-pos {
+				return nil, errORCShort
+			}
+			v := zigzag(bigEndianUint(b[pos:pos+width]), signed)
+			pos += width
+			for i := 0; i < int(first&7)+3; i++ {
+				out = append(out, v)
+			}
+			continue
+		}
+		if len(b)-pos < 2 {
+			return nil, errORCShort
+		}
+		count := (int(first&1)<<8 | int(b[pos+1])) + 1
+		switch first >> 6 {
+		case 1: //DIRECT
+			pos += 2
+			vals, used, err := unpackBE(b[pos:], count, orcWidth(first>>1&0x1f))
+			if err != nil {
+				return nil, err
+			}
+			pos += used
+			for _, v := range vals {
+				out = append(out, zigzag(v, signed))
+			}
+		case 2: //PATCHED_BASE: 值为base加上bit-packed的值，少数大的值的高位在patch列表中
+			if len(b)-pos < 4 {
+				return nil, errORCShort
+			}
+			width := orcWidth(first >> 1 & 0x1f)
+			third, fourth := b[pos+2], b[pos+3]
+			baseWidth := int(third>>5&7) + 1
+			patchWidth := orcWidth(third & 0x1f)
+			gapWidth := int(fourth>>5&7) + 1
+			patchCount := int(fourth & 0x1f)
+			pos += 4
+			if baseWidth > len(b)-pos {
+				return nil, errORCShort
+			}
+			base := bigEndianUint(b[pos : pos+baseWidth])
+			pos += baseWidth
+			signBit := uint64(1) << (uint(baseWidth)*8 - 1) //base的最高位是符号
+			baseValue := int64(base &^ signBit)
+			if base&signBit != 0 {
+				baseValue = -baseValue
+			}
+			vals, used, err := unpackBE(b[pos:], count, width)
+			if err != nil {
+				return nil, err
+			}
+			pos += used
+			patches, used, err := unpackBE(b[pos:], patchCount, orcClosestWidth(gapWidth+patchWidth))
+			if err != nil {
+				return nil, err
+			}
+			pos += used
+			//patch为gap和patch值，gap是和上一个patch的距离，大于255时用patch值为0的项补足
+			patchMask := uint64(1)<<patchWidth - 1
+			at := 0
+			for _, p := range patches {
+				at += int(p >> patchWidth)
+				if p&patchMask == 0 && p>>patchWidth == 255 {
+					continue
+				}
+				if at >= count {
+					return nil, errors.New("invalid patch position")
+				}
+				vals[at] |= (p & patchMask) << width
+			}
+			for _, v := range vals {
+				out = append(out, baseValue+int64(v))
+			}
+		case 3: //DELTA: 起始值, 差的基数, 然后是bit-packed的差的绝对值
+			width := 0
+			if code := first >> 1 & 0x1f; code != 0 {
+				width = orcWidth(code)
+			}
+			pos += 2
+			v, k, err := readORCVarint(b[pos:], signed)
+			if err != nil {
+				return nil, err
+			}
+			pos += k
+			delta, k, err := readORCVarint(b[pos:], true)
+			if err != nil {
+				return nil, err
+			}
+			pos += k
+			out = append(out, v)
+			if width == 0 { //固定的差
+				for i := 1; i < count; i++ {
+					v += delta
+					out = append(out, v)
+				}
+				continue
+			}
+			if count < 2 {
+				return nil, errors.New("invalid delta run")
+			}
+			v += delta
+			out = append(out, v)
+			deltas, used, err := unpackBE(b[pos:], count-2, width)
+			if err != nil {
+				return nil, err
+			}
+			pos += used
+			for _, d := range deltas {
+				if delta < 0 {
+					v -= int64(d)
+				} else {
+					v += int64(d)
+				}
+				out = append(out, v)
+			}
+		}
+	}
+	return out[:n], nil
+}
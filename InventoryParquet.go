@@ -0,0 +1,819 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+//Parquet格式的inventory数据文件，按parquet-format的规范读取，只解码inventory需要的顶层列
+//文件末尾是thrift compact编码的FileMetaData, 每个row group中需要的列整块读入内存，再按行输出
+//支持PLAIN, 字典, RLE和DELTA编码，UNCOMPRESSED, SNAPPY, GZIP和ZSTD压缩
+
+const parquetMagic = "PAR1"
+
+var errParquetShort = errors.New("truncated Parquet data")
+
+//数量来自文件，预分配时限制大小，损坏的文件返回错误而不是分配失败
+func allocHint(n int) int {
+	if n < 0 {
+		return 0
+	}
+	if n > 1<<16 {
+		return 1 << 16
+	}
+	return n
+}
+
+//thrift compact协议的类型
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftByte   = 3
+	thriftI16    = 4
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftDouble = 7
+	thriftBinary = 8
+	thriftList   = 9
+	thriftSet    = 10
+	thriftMap    = 11
+	thriftStruct = 12
+)
+
+//解码后的thrift struct, 字段id到值: 整数为int64, binary为[]byte, list为[]interface{}, struct为thriftFields
+type thriftFields map[int16]interface{}
+
+func (f thriftFields) i64(id int16) int64 {
+	v, _ := f[id].(int64)
+	return v
+}
+
+func (f thriftFields) has(id int16) bool {
+	_, ok := f[id]
+	return ok
+}
+
+func (f thriftFields) str(id int16) string {
+	v, _ := f[id].([]byte)
+	return string(v)
+}
+
+func (f thriftFields) boolean(id int16, def bool) bool {
+	if v, ok := f[id].(bool); ok {
+		return v
+	}
+	return def
+}
+
+func (f thriftFields) child(id int16) thriftFields {
+	v, _ := f[id].(thriftFields)
+	return v
+}
+
+func (f thriftFields) list(id int16) []thriftFields {
+	var out []thriftFields
+	items, _ := f[id].([]interface{})
+	for _, item := range items {
+		v, _ := item.(thriftFields)
+		out = append(out, v)
+	}
+	return out
+}
+
+type thriftReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *thriftReader) byte() (byte, error) {
+	if r.pos >= len(r.b) {
+		return 0, errParquetShort
+	}
+	r.pos++
+	return r.b[r.pos-1], nil
+}
+
+func (r *thriftReader) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(r.b[r.pos:])
+	if n <= 0 {
+		return 0, errParquetShort
+	}
+	r.pos += n
+	return v, nil
+}
+
+func (r *thriftReader) varint() (int64, error) {
+	v, err := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+func (r *thriftReader) readStruct() (thriftFields, error) {
+	fields := thriftFields{}
+	var id int16
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if b == 0 { //STOP
+			return fields, nil
+		}
+		if delta := int16(b >> 4); delta != 0 {
+			id += delta
+		} else {
+			v, err := r.varint()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v)
+		}
+		switch t := b & 0x0f; t {
+		case thriftTrue:
+			fields[id] = true
+		case thriftFalse:
+			fields[id] = false
+		default:
+			if fields[id], err = r.readValue(t); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (r *thriftReader) readValue(t byte) (interface{}, error) {
+	switch t {
+	case thriftTrue, thriftFalse: //list中的布尔值占一个字节
+		b, err := r.byte()
+		return b == thriftTrue, err
+	case thriftByte:
+		b, err := r.byte()
+		return int64(int8(b)), err
+	case thriftI16, thriftI32, thriftI64:
+		return r.varint()
+	case thriftDouble:
+		if r.pos+8 > len(r.b) {
+			return nil, errParquetShort
+		}
+		r.pos += 8
+		return math.Float64frombits(binary.LittleEndian.Uint64(r.b[r.pos-8:])), nil
+	case thriftBinary:
+		n, err := r.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(r.b)-r.pos) {
+			return nil, errParquetShort
+		}
+		r.pos += int(n)
+		return r.b[r.pos-int(n) : r.pos], nil
+	case thriftList, thriftSet:
+		h, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		n := uint64(h >> 4)
+		if n == 15 {
+			if n, err = r.uvarint(); err != nil {
+				return nil, err
+			}
+		}
+		if n > uint64(len(r.b)-r.pos) { //每个元素至少一个字节
+			return nil, errParquetShort
+		}
+		list := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			v, err := r.readValue(h & 0x0f)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case thriftMap: //用不到map的内容，只跳过
+		n, err := r.uvarint()
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		kv, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, err := r.readValue(kv >> 4); err != nil {
+				return nil, err
+			}
+			if _, err := r.readValue(kv & 0x0f); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case thriftStruct:
+		return r.readStruct()
+	}
+	return nil, fmt.Errorf("unknown thrift type %d", t)
+}
+
+//Parquet的一个顶层列
+type parquetColumn struct {
+	field    string        //对应的CSV字段名
+	physical int64         //物理类型: 0 BOOLEAN, 1 INT32, 2 INT64, 3 INT96, 4 FLOAT, 5 DOUBLE, 6 BYTE_ARRAY, 7 FIXED_LEN_BYTE_ARRAY
+	typeLen  int           //FIXED_LEN_BYTE_ARRAY的长度
+	optional bool          //有definition level
+	timeUnit time.Duration //INT64/INT32时间戳的单位，0表示不是时间戳
+}
+
+func readInventoryParquet(name string, fn func(InventoryRecord)) {
+	fd, err := os.Open(name)
+	if err != nil {
+		log.Fatalln("Failed to open inventory:", name, err)
+	}
+	defer fd.Close()
+	st, err := fd.Stat()
+	if err != nil {
+		log.Fatalln("Failed to read inventory:", name, err)
+	}
+	meta, err := readParquetFooter(fd, st.Size())
+	if err != nil {
+		log.Fatalln("Failed to read inventory:", name, err)
+	}
+	columns := parquetColumns(meta.list(2))
+	if _, ok := columns["key"]; !ok {
+		log.Fatalln("Inventory has no key column:", name)
+	}
+	for _, rowGroup := range meta.list(4) {
+		rows := int(rowGroup.i64(3))
+		values := map[string][]string{}
+		for _, chunk := range rowGroup.list(1) {
+			md := chunk.child(3)
+			path, _ := md[3].([]interface{})
+			if len(path) != 1 {
+				continue
+			}
+			column, _ := path[0].([]byte)
+			col, ok := columns[strings.ToLower(string(column))]
+			if !ok {
+				continue
+			}
+			v, err := readParquetChunk(io.NewSectionReader(fd, 0, st.Size()), col, md, rows)
+			if err != nil {
+				log.Fatalln("Failed to read inventory:", name, string(column), err)
+			}
+			values[col.field] = v
+		}
+		emitInventoryRows(rows, values, fn)
+	}
+}
+
+//读取文件末尾的FileMetaData: metadata, 4字节长度, PAR1
+func readParquetFooter(fd io.ReaderAt, size int64) (thriftFields, error) {
+	tail := make([]byte, 8)
+	if size < 12 {
+		return nil, errors.New("not a Parquet file")
+	}
+	if _, err := fd.ReadAt(tail, size-8); err != nil {
+		return nil, err
+	}
+	if string(tail[4:]) != parquetMagic {
+		return nil, errors.New("not a Parquet file, or the footer is encrypted")
+	}
+	n := int64(binary.LittleEndian.Uint32(tail))
+	if n > size-12 {
+		return nil, errParquetShort
+	}
+	buf := make([]byte, n)
+	if _, err := fd.ReadAt(buf, size-8-n); err != nil {
+		return nil, err
+	}
+	r := &thriftReader{b: buf}
+	return r.readStruct()
+}
+
+//schema中inventory需要的顶层列，嵌套的group和REPEATED的列跳过，key为小写的列名
+func parquetColumns(schema []thriftFields) map[string]*parquetColumn {
+	columns := map[string]*parquetColumn{}
+	if len(schema) == 0 {
+		return columns
+	}
+	i := 1
+	for c := int64(0); c < schema[0].i64(5) && i < len(schema); c++ {
+		el := schema[i]
+		i++
+		if n := el.i64(5); n > 0 {
+			i = skipParquetGroup(schema, i, n)
+			continue
+		}
+		name := strings.ToLower(el.str(4))
+		field, ok := inventoryFields[name]
+		if !ok || el.i64(3) == 2 {
+			continue
+		}
+		col := &parquetColumn{field: field, physical: el.i64(1), typeLen: int(el.i64(2)), optional: el.i64(3) == 1}
+		switch el.i64(6) { //ConvertedType
+		case 9:
+			col.timeUnit = time.Millisecond
+		case 10:
+			col.timeUnit = time.Microsecond
+		}
+		if ts := el.child(10).child(8); ts != nil { //LogicalType TIMESTAMP, unit: 1 MILLIS, 2 MICROS, 3 NANOS
+			switch unit := ts.child(2); {
+			case unit.has(1):
+				col.timeUnit = time.Millisecond
+			case unit.has(2):
+				col.timeUnit = time.Microsecond
+			case unit.has(3):
+				col.timeUnit = time.Nanosecond
+			}
+		}
+		columns[name] = col
+	}
+	return columns
+}
+
+func skipParquetGroup(schema []thriftFields, i int, children int64) int {
+	for c := int64(0); c < children && i < len(schema); c++ {
+		el := schema[i]
+		i++
+		if n := el.i64(5); n > 0 {
+			i = skipParquetGroup(schema, i, n)
+		}
+	}
+	return i
+}
+
+//读取一个row group中的一列，null为空字符串
+func readParquetChunk(r *io.SectionReader, col *parquetColumn, md thriftFields, rows int) ([]string, error) {
+	start := md.i64(9)
+	if dict := md.i64(11); dict > 0 && dict < start {
+		start = dict
+	}
+	size := md.i64(7)
+	if start < 0 || size <= 0 || start > r.Size() || size > r.Size()-start {
+		return nil, fmt.Errorf("invalid column chunk at %d, size %d", start, size)
+	}
+	buf := make([]byte, size)
+	if _, err := r.ReadAt(buf, start); err != nil {
+		return nil, err
+	}
+	if rows < 0 {
+		return nil, fmt.Errorf("invalid row count %d", rows)
+	}
+	codec := md.i64(4)
+	var dict []string
+	out := make([]string, 0, allocHint(rows))
+	tr := &thriftReader{b: buf}
+	for len(out) < rows && tr.pos < len(buf) {
+		header, err := tr.readStruct()
+		if err != nil {
+			return nil, err
+		}
+		uncompressed, compressed := int(header.i64(2)), int(header.i64(3))
+		if compressed < 0 || compressed > len(buf)-tr.pos {
+			return nil, errParquetShort
+		}
+		page := buf[tr.pos : tr.pos+compressed]
+		tr.pos += compressed
+		switch header.i64(1) {
+		case 2: //DICTIONARY_PAGE
+			data, err := parquetDecompress(codec, page, uncompressed)
+			if err != nil {
+				return nil, err
+			}
+			if dict, err = col.plain(data, int(header.child(7).i64(1))); err != nil {
+				return nil, err
+			}
+		case 0: //DATA_PAGE, definition level在压缩的数据中
+			data, err := parquetDecompress(codec, page, uncompressed)
+			if err != nil {
+				return nil, err
+			}
+			dh := header.child(5)
+			n := int(dh.i64(1))
+			if n < 0 || n > rows-len(out) {
+				return nil, fmt.Errorf("invalid page with %d values", n)
+			}
+			var defs []uint64
+			if col.optional {
+				if len(data) < 4 || int(binary.LittleEndian.Uint32(data)) > len(data)-4 {
+					return nil, errParquetShort
+				}
+				l := int(binary.LittleEndian.Uint32(data))
+				if defs, err = decodeHybrid(data[4:4+l], 1, n); err != nil {
+					return nil, err
+				}
+				data = data[4+l:]
+			}
+			values, err := col.decode(data, dh.i64(2), countDefined(defs, n), dict)
+			if err != nil {
+				return nil, err
+			}
+			out = mergeNulls(out, defs, values, n)
+		case 3: //DATA_PAGE_V2, level不压缩，在数据前面
+			dh := header.child(8)
+			n := int(dh.i64(1))
+			if n < 0 || n > rows-len(out) {
+				return nil, fmt.Errorf("invalid page with %d values", n)
+			}
+			defLen, repLen := int(dh.i64(5)), int(dh.i64(6))
+			if defLen < 0 || repLen < 0 || defLen+repLen > len(page) {
+				return nil, errParquetShort
+			}
+			var defs []uint64
+			if col.optional {
+				if defs, err = decodeHybrid(page[repLen:repLen+defLen], 1, n); err != nil {
+					return nil, err
+				}
+			}
+			data := page[repLen+defLen:]
+			if dh.boolean(7, true) {
+				if data, err = parquetDecompress(codec, data, uncompressed-repLen-defLen); err != nil {
+					return nil, err
+				}
+			}
+			values, err := col.decode(data, dh.i64(4), countDefined(defs, n), dict)
+			if err != nil {
+				return nil, err
+			}
+			out = mergeNulls(out, defs, values, n)
+		}
+	}
+	if len(out) != rows {
+		return nil, fmt.Errorf("column has %d values, the row group has %d rows", len(out), rows)
+	}
+	return out, nil
+}
+
+func countDefined(defs []uint64, n int) int {
+	if defs == nil {
+		return n
+	}
+	count := 0
+	for _, d := range defs {
+		if d == 1 {
+			count++
+		}
+	}
+	return count
+}
+
+//按definition level把非null的值放回各自的行
+func mergeNulls(out []string, defs []uint64, values []string, n int) []string {
+	if defs == nil {
+		return append(out, values[:n]...)
+	}
+	for _, d := range defs {
+		if d == 1 {
+			out = append(out, values[0])
+			values = values[1:]
+		} else {
+			out = append(out, "")
+		}
+	}
+	return out
+}
+
+//size是页头中未压缩的大小，解压的结果必须一致
+func parquetDecompress(codec int64, data []byte, size int) ([]byte, error) {
+	if size < 0 || size > zstdMaxMemory {
+		return nil, fmt.Errorf("invalid page size %d", size)
+	}
+	var out []byte
+	var err error
+	switch codec {
+	case 0: //UNCOMPRESSED
+		out = data
+	case 1: //SNAPPY
+		if n, err := snappy.DecodedLen(data); err != nil || n != size {
+			return nil, fmt.Errorf("snappy page of %d bytes, want %d: %v", n, size, err)
+		}
+		out, err = snappy.Decode(nil, data)
+	case 2: //GZIP
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+		out, err = io.ReadAll(io.LimitReader(gz, int64(size)+1))
+	case 6: //ZSTD
+		out, err = zstdDecodeAll(data, size)
+	default:
+		return nil, fmt.Errorf("unsupported compression codec %d", codec)
+	}
+	if err == nil && len(out) != size {
+		err = fmt.Errorf("page decompressed to %d bytes, want %d", len(out), size)
+	}
+	return out, err
+}
+
+//ORC和Parquet共用的zstd解码器，一次解压的结果不超过zstdMaxMemory
+const zstdMaxMemory = 256 << 20
+
+var (
+	inventoryZstdOnce sync.Once
+	inventoryZstd     *zstd.Decoder
+)
+
+func zstdDecodeAll(data []byte, size int) ([]byte, error) {
+	inventoryZstdOnce.Do(func() {
+		inventoryZstd, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(zstdMaxMemory))
+	})
+	return inventoryZstd.DecodeAll(data, make([]byte, 0, size))
+}
+
+//按列的类型把n个PLAIN编码的值转换为字符串
+func (c *parquetColumn) plain(data []byte, n int) ([]string, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("invalid value count %d", n)
+	}
+	out := make([]string, 0, allocHint(n))
+	width := map[int64]int{1: 4, 2: 8, 3: 12, 4: 4, 5: 8, 7: c.typeLen}[c.physical]
+	if c.physical == 0 {
+		if n > len(data)*8 {
+			return nil, errParquetShort
+		}
+	} else if width > 0 && n > len(data)/width {
+		return nil, errParquetShort
+	}
+	for i := 0; i < n; i++ {
+		switch c.physical {
+		case 0: //BOOLEAN, 按位从低到高
+			out = append(out, strconv.FormatBool(data[i/8]>>(i%8)&1 == 1))
+		case 1:
+			out = append(out, c.formatInt(int64(int32(binary.LittleEndian.Uint32(data[i*4:])))))
+		case 2:
+			out = append(out, c.formatInt(int64(binary.LittleEndian.Uint64(data[i*8:]))))
+		case 3: //INT96时间戳: 当天的纳秒，儒略日
+			nanos := int64(binary.LittleEndian.Uint64(data[i*12:]))
+			day := int64(binary.LittleEndian.Uint32(data[i*12+8:]))
+			out = append(out, time.Unix((day-2440588)*86400, nanos).UTC().Format(time.RFC3339Nano))
+		case 4:
+			out = append(out, strconv.FormatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:]))), 'g', -1, 32))
+		case 5:
+			out = append(out, strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(data[i*8:])), 'g', -1, 64))
+		case 6: //BYTE_ARRAY: 4字节长度加内容
+			if len(data) < 4 || int(binary.LittleEndian.Uint32(data)) > len(data)-4 {
+				return nil, errParquetShort
+			}
+			l := int(binary.LittleEndian.Uint32(data))
+			out = append(out, string(data[4:4+l]))
+			data = data[4+l:]
+		case 7:
+			out = append(out, string(data[i*c.typeLen:(i+1)*c.typeLen]))
+		default:
+			return nil, fmt.Errorf("unknown physical type %d", c.physical)
+		}
+	}
+	return out, nil
+}
+
+func (c *parquetColumn) formatInt(v int64) string {
+	if c.timeUnit != 0 {
+		return time.Unix(0, v*int64(c.timeUnit)).UTC().Format(time.RFC3339Nano)
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+//解码一个数据页中n个非null的值
+func (c *parquetColumn) decode(data []byte, encoding int64, n int, dict []string) ([]string, error) {
+	switch encoding {
+	case 0: //PLAIN
+		return c.plain(data, n)
+	case 2, 8: //PLAIN_DICTIONARY, RLE_DICTIONARY: 1字节位宽加RLE的字典下标
+		if n == 0 {
+			return nil, nil
+		}
+		if len(data) == 0 {
+			return nil, errParquetShort
+		}
+		idx, err := decodeHybrid(data[1:], int(data[0]), n)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]string, 0, allocHint(n))
+		for _, i := range idx {
+			if i >= uint64(len(dict)) {
+				return nil, fmt.Errorf("dictionary index %d out of range", i)
+			}
+			out = append(out, dict[i])
+		}
+		return out, nil
+	case 3: //RLE, 只用于BOOLEAN, 前面有4字节长度
+		if c.physical != 0 {
+			break
+		}
+		if len(data) < 4 || int(binary.LittleEndian.Uint32(data)) > len(data)-4 {
+			return nil, errParquetShort
+		}
+		bools, err := decodeHybrid(data[4:4+int(binary.LittleEndian.Uint32(data))], 1, n)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]string, 0, allocHint(n))
+		for _, b := range bools {
+			out = append(out, strconv.FormatBool(b == 1))
+		}
+		return out, nil
+	case 5: //DELTA_BINARY_PACKED
+		if c.physical != 1 && c.physical != 2 {
+			break
+		}
+		ints, _, err := decodeDeltaBinary(data, n)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]string, 0, allocHint(n))
+		for _, v := range ints {
+			if c.physical == 1 {
+				v = int64(int32(v))
+			}
+			out = append(out, c.formatInt(v))
+		}
+		return out, nil
+	case 6: //DELTA_LENGTH_BYTE_ARRAY: 长度，然后所有的内容
+		lengths, used, err := decodeDeltaBinary(data, n)
+		if err != nil {
+			return nil, err
+		}
+		data = data[used:]
+		out := make([]string, 0, allocHint(n))
+		for _, l := range lengths {
+			if l < 0 || l > int64(len(data)) {
+				return nil, errParquetShort
+			}
+			out = append(out, string(data[:l]))
+			data = data[l:]
+		}
+		return out, nil
+	case 7: //DELTA_BYTE_ARRAY: 和前一个值相同的前缀长度，后缀的长度，然后所有的后缀
+		prefixes, used, err := decodeDeltaBinary(data, n)
+		if err != nil {
+			return nil, err
+		}
+		data = data[used:]
+		suffixes, used, err := decodeDeltaBinary(data, n)
+		if err != nil {
+			return nil, err
+		}
+		data = data[used:]
+		out := make([]string, 0, allocHint(n))
+		last := ""
+		for i := range prefixes {
+			p, l := prefixes[i], suffixes[i]
+			if p < 0 || p > int64(len(last)) || l < 0 || l > int64(len(data)) {
+				return nil, errParquetShort
+			}
+			last = last[:p] + string(data[:l])
+			data = data[l:]
+			out = append(out, last)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %d for physical type %d", encoding, c.physical)
+}
+
+//RLE和bit-packing混合编码的n个值，每个值width位
+func decodeHybrid(data []byte, width int, n int) ([]uint64, error) {
+	if width > 32 || n < 0 {
+		return nil, fmt.Errorf("invalid bit width %d for %d values", width, n)
+	}
+	out := make([]uint64, 0, allocHint(n))
+	byteWidth := (width + 7) / 8
+	for pos := 0; len(out) < n; {
+		h, k := binary.Uvarint(data[pos:])
+		if k <= 0 {
+			return nil, errParquetShort
+		}
+		pos += k
+		if h&1 == 1 { //bit-packed, 每组8个值
+			groups := h >> 1
+			if groups > uint64(len(data)-pos) {
+				return nil, errParquetShort
+			}
+			size := int(groups) * width
+			if size > len(data)-pos {
+				return nil, errParquetShort
+			}
+			for i := 0; i < int(groups)*8 && len(out) < n; i++ {
+				out = append(out, unpackLE(data[pos:], i*width, width))
+			}
+			pos += size
+		} else { //重复h>>1次，值占byteWidth字节
+			if byteWidth > len(data)-pos {
+				return nil, errParquetShort
+			}
+			var v uint64
+			for i := 0; i < byteWidth; i++ {
+				v |= uint64(data[pos+i]) << (8 * i)
+			}
+			pos += byteWidth
+			for count := h >> 1; count > 0 && len(out) < n; count-- {
+				out = append(out, v)
+			}
+		}
+	}
+	return out, nil
+}
+
+//从bit偏移开始读width位，低位在前
+func unpackLE(b []byte, bit int, width int) uint64 {
+	var v uint64
+	for got := 0; got < width; {
+		shift := bit % 8
+		take := 8 - shift
+		if take > width-got {
+			take = width - got
+		}
+		v |= uint64(b[bit/8]>>shift&(1<<take-1)) << got
+		got += take
+		bit += take
+	}
+	return v
+}
+
+//DELTA_BINARY_PACKED, 返回解码的值和用掉的字节数
+func decodeDeltaBinary(data []byte, n int) ([]int64, int, error) {
+	pos := 0
+	uvarint := func() (uint64, error) {
+		v, k := binary.Uvarint(data[pos:])
+		if k <= 0 {
+			return 0, errParquetShort
+		}
+		pos += k
+		return v, nil
+	}
+	varint := func() (int64, error) {
+		v, err := uvarint()
+		return int64(v>>1) ^ -int64(v&1), err
+	}
+	blockSize, err := uvarint()
+	if err != nil {
+		return nil, 0, err
+	}
+	miniBlocks, err := uvarint()
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := uvarint()
+	if err != nil {
+		return nil, 0, err
+	}
+	last, err := varint()
+	if err != nil {
+		return nil, 0, err
+	}
+	if miniBlocks == 0 || blockSize%miniBlocks != 0 || blockSize/miniBlocks%8 != 0 || blockSize > 1<<20 {
+		return nil, 0, fmt.Errorf("invalid delta block size %d with %d miniblocks", blockSize, miniBlocks)
+	}
+	if n < 0 || total < uint64(n) {
+		return nil, 0, fmt.Errorf("delta encoding has %d values, want %d", total, n)
+	}
+	perMini := int(blockSize / miniBlocks)
+	out := make([]int64, 0, allocHint(n))
+	if total > 0 {
+		out = append(out, last)
+	}
+	for uint64(len(out)) < total {
+		minDelta, err := varint()
+		if err != nil {
+			return nil, 0, err
+		}
+		if int(miniBlocks) > len(data)-pos {
+			return nil, 0, errParquetShort
+		}
+		widths := data[pos : pos+int(miniBlocks)]
+		pos += int(miniBlocks)
+		for _, w := range widths {
+			if uint64(len(out)) >= total { //最后一个block中没有值的miniblock不占空间
+				break
+			}
+			if w > 64 {
+				return nil, 0, fmt.Errorf("invalid bit width %d", w)
+			}
+			size := perMini * int(w) / 8
+			if size > len(data)-pos {
+				return nil, 0, errParquetShort
+			}
+			for i := 0; i < perMini && uint64(len(out)) < total; i++ {
+				last += minDelta + int64(unpackLE(data[pos:], i*int(w), int(w)))
+				out = append(out, last)
+			}
+			pos += size
+		}
+	}
+	return out[:n], pos, nil
+}
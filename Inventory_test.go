@@ -0,0 +1,677 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+//ORC规范中的例子
+func TestDecodeRLEv2(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		signed bool
+		want   []int64
+	}{
+		{"short repeat", []byte{0x0a, 0x27, 0x10}, false, []int64{10000, 10000, 10000, 10000, 10000}},
+		{"direct", []byte{0x5e, 0x03, 0x5c, 0xa1, 0xab, 0x1e, 0xde, 0xad, 0xbe, 0xef}, false, []int64{23713, 43806, 57005, 48879}},
+		{"patched base", []byte{0x8e, 0x13, 0x2b, 0x21, 0x07, 0xd0, 0x1e, 0x00, 0x14, 0x70, 0x28, 0x32, 0x3c, 0x46, 0x50, 0x5a, 0x64, 0x6e, 0x78, 0x82, 0x8c, 0x96, 0xa0, 0xaa, 0xb4, 0xbe, 0xfc, 0xe8}, false,
+			[]int64{2030, 2000, 2020, 1000000, 2040, 2050, 2060, 2070, 2080, 2090, 2100, 2110, 2120, 2130, 2140, 2150, 2160, 2170, 2180, 2190}},
+		{"delta", []byte{0xc6, 0x09, 0x02, 0x02, 0x22, 0x42, 0x42, 0x46}, false, []int64{2, 3, 5, 7, 11, 13, 17, 19, 23, 29}},
+		{"fixed delta", []byte{0xc0, 0x03, 0x0a, 0x03}, true, []int64{5, 3, 1, -1}},
+		{"signed short repeat", []byte{0x00, 0x03}, true, []int64{-2, -2, -2}},
+		{"two runs", []byte{0x0a, 0x27, 0x10, 0xc6, 0x09, 0x02, 0x02, 0x22, 0x42, 0x42, 0x46}, false,
+			[]int64{10000, 10000, 10000, 10000, 10000, 2, 3, 5, 7, 11, 13, 17, 19, 23, 29}},
+	}
+	for _, tt := range tests {
+		got, err := decodeRLEv2(tt.data, len(tt.want), tt.signed)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: decodeRLEv2 = %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+	if _, err := decodeRLEv2([]byte{0x5e, 0x03, 0x5c}, 4, false); err == nil {
+		t.Error("decodeRLEv2 of a truncated run succeeded")
+	}
+}
+
+func TestDecodeRLEv1(t *testing.T) {
+	hundred := make([]int64, 100)
+	for i := range hundred {
+		hundred[i] = 7
+	}
+	tests := []struct {
+		data []byte
+		want []int64
+	}{
+		{[]byte{0x61, 0x00, 0x07}, hundred},
+		{[]byte{0xfb, 0x02, 0x03, 0x06, 0x07, 0x0b}, []int64{2, 3, 6, 7, 11}},
+		{[]byte{0x00, 0xff, 0x0a}, []int64{10, 9, 8}},
+	}
+	for _, tt := range tests {
+		got, err := decodeRLEv1(tt.data, len(tt.want), false)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("decodeRLEv1(% x) = %v, %v, want %v", tt.data, got, err, tt.want)
+		}
+	}
+}
+
+func TestDecodeORCBytes(t *testing.T) {
+	got, err := decodeORCBytes([]byte{0x61, 0x00}, 100)
+	if err != nil || !bytes.Equal(got, make([]byte, 100)) {
+		t.Errorf("decodeORCBytes(61 00) = %v, %v, want 100 zeros", got, err)
+	}
+	got, err = decodeORCBytes([]byte{0xfe, 0x44, 0x45}, 2)
+	if err != nil || !bytes.Equal(got, []byte{0x44, 0x45}) {
+		t.Errorf("decodeORCBytes(fe 44 45) = % x, %v, want 44 45", got, err)
+	}
+	bools, err := decodeORCBooleans([]byte{0xff, 0x80}, 8)
+	if want := []bool{true, false, false, false, false, false, false, false}; err != nil || !reflect.DeepEqual(bools, want) {
+		t.Errorf("decodeORCBooleans(ff 80) = %v, %v, want %v", bools, err, want)
+	}
+}
+
+//Parquet规范中的例子
+func TestDecodeHybrid(t *testing.T) {
+	tests := []struct {
+		data  []byte
+		width int
+		want  []uint64
+	}{
+		{[]byte{0x03, 0x88, 0xc6, 0xfa}, 3, []uint64{0, 1, 2, 3, 4, 5, 6, 7}},
+		{[]byte{0x0a, 0x04}, 3, []uint64{4, 4, 4, 4, 4}},
+		{[]byte{0x06, 0x01, 0x03, 0x05}, 1, []uint64{1, 1, 1, 1, 0, 1, 0, 0, 0}},
+		{[]byte{0x08, 0x34, 0x12}, 9, []uint64{0x1234, 0x1234, 0x1234, 0x1234}},
+		{[]byte{0x06}, 0, []uint64{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		got, err := decodeHybrid(tt.data, tt.width, len(tt.want))
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("decodeHybrid(% x, %d) = %v, %v, want %v", tt.data, tt.width, got, err, tt.want)
+		}
+	}
+}
+
+func TestDecodeDeltaBinary(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want []int64
+	}{
+		{[]byte{0x80, 0x01, 0x04, 0x05, 0x02, 0x02, 0x00, 0x00, 0x00, 0x00}, []int64{1, 2, 3, 4, 5}},
+		{append([]byte{0x80, 0x01, 0x04, 0x08, 0x0e, 0x03, 0x02, 0x00, 0x00, 0x00, 0xc0, 0x3f}, make([]byte, 6)...), []int64{7, 5, 3, 1, 2, 3, 4, 5}},
+		{[]byte{0x80, 0x01, 0x04, 0x01, 0x0c}, []int64{6}},
+	}
+	for _, tt := range tests {
+		got, used, err := decodeDeltaBinary(tt.data, len(tt.want))
+		if err != nil || !reflect.DeepEqual(got, tt.want) || used != len(tt.data) {
+			t.Errorf("decodeDeltaBinary(% x) = %v, %d, %v, want %v, %d", tt.data, got, used, err, tt.want, len(tt.data))
+		}
+	}
+}
+
+type testInventoryRow struct {
+	key, version, etag, class string //version和class为空时是null
+	latest, deleted           bool
+	size                      int64
+	modified                  time.Time
+}
+
+var testInventoryRows = [][]testInventoryRow{
+	{
+		{key: "data/a.txt", version: "v1", etag: "e1", class: "STANDARD", latest: true, size: 5, modified: time.Date(2021, 3, 4, 5, 6, 7, 123000000, time.UTC)},
+		{key: "data/日本/b", etag: "e2", latest: true, modified: time.Date(2012, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{key: "data/old", version: "v0", etag: "e3", class: "STANDARD", size: 1, modified: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{key: "data/deleted", version: "v2", latest: true, deleted: true, modified: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+	},
+	{
+		{key: "other/c", version: "v1", etag: "e4", class: "STANDARD", latest: true, size: 3, modified: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{key: "data/cold", version: "v1", etag: "e5", class: "GLACIER", latest: true, size: 3, modified: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{key: "data/c", version: "v1", etag: "e6", class: "STANDARD_IA", latest: true, size: 1 << 40, modified: time.Date(2022, 12, 31, 23, 59, 59, 999000000, time.UTC)},
+		{key: "data/", etag: "e7", latest: true, modified: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+	},
+}
+
+var testInventoryRecords = []InventoryRecord{
+	{Key: "data/a.txt", VersionID: "v1", ETag: "e1", StorageClass: "STANDARD", Size: 5, LastModified: time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC).Unix()},
+	{Key: "data/日本/b", ETag: "e2", LastModified: time.Date(2012, 1, 2, 3, 4, 5, 0, time.UTC).Unix()},
+	{Key: "data/c", VersionID: "v1", ETag: "e6", StorageClass: "STANDARD_IA", Size: 1 << 40, LastModified: time.Date(2022, 12, 31, 23, 59, 59, 0, time.UTC).Unix()},
+}
+
+func readTestInventory(t *testing.T, read func(name string, fn func(InventoryRecord)), name string) []InventoryRecord {
+	t.Helper()
+	saved := srcPrefix
+	srcPrefix = "data/"
+	defer func() { srcPrefix = saved }()
+	var records []InventoryRecord
+	read(name, func(r InventoryRecord) { records = append(records, r) })
+	return records
+}
+
+func TestReadInventoryParquet(t *testing.T) {
+	for _, codec := range []int{0, 1, 2, 6} {
+		name := filepath.Join(t.TempDir(), "inventory.parquet")
+		if err := os.WriteFile(name, writeTestParquet(t, codec, testInventoryRows), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got := readTestInventory(t, readInventoryParquet, name); !reflect.DeepEqual(got, testInventoryRecords) {
+			t.Errorf("codec %d: readInventoryParquet = %+v, want %+v", codec, got, testInventoryRecords)
+		}
+	}
+}
+
+func TestReadInventoryORC(t *testing.T) {
+	for _, compression := range []uint64{0, 1, 2, 5} {
+		name := filepath.Join(t.TempDir(), "inventory.orc")
+		if err := os.WriteFile(name, writeTestORC(t, compression, testInventoryRows), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got := readTestInventory(t, readInventoryORC, name); !reflect.DeepEqual(got, testInventoryRecords) {
+			t.Errorf("compression %d: readInventoryORC = %+v, want %+v", compression, got, testInventoryRecords)
+		}
+	}
+}
+
+func uvarintBytes(v uint64) []byte {
+	b := make([]byte, binary.MaxVarintLen64)
+	return b[:binary.PutUvarint(b, v)]
+}
+
+func appendUint32LE(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendUint64LE(b []byte, v uint64) []byte {
+	return appendUint32LE(appendUint32LE(b, uint32(v)), uint32(v>>32))
+}
+
+func appendUint64BE(b []byte, v uint64) []byte {
+	for i := 56; i >= 0; i -= 8 {
+		b = append(b, byte(v>>i))
+	}
+	return b
+}
+
+func zigzagEncode(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+//测试用的thrift compact编码，值为bool, int, string, []string, []tfield(struct)或[][]tfield(struct的list)
+type tfield struct {
+	id int16
+	v  interface{}
+}
+
+func encodeThrift(fields ...tfield) []byte {
+	var b []byte
+	last := int16(0)
+	for _, f := range fields {
+		t, body := encodeThriftValue(f.v)
+		if d := f.id - last; d > 0 && d <= 15 {
+			b = append(b, byte(d)<<4|t)
+		} else {
+			b = append(append(b, t), uvarintBytes(zigzagEncode(int64(f.id)))...)
+		}
+		last = f.id
+		b = append(b, body...)
+	}
+	return append(b, 0)
+}
+
+func encodeThriftValue(v interface{}) (byte, []byte) {
+	listHeader := func(n int, t byte) []byte {
+		if n < 15 {
+			return []byte{byte(n)<<4 | t}
+		}
+		return append([]byte{0xf0 | t}, uvarintBytes(uint64(n))...)
+	}
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return thriftTrue, nil
+		}
+		return thriftFalse, nil
+	case int:
+		return thriftI64, uvarintBytes(zigzagEncode(int64(v)))
+	case string:
+		return thriftBinary, append(uvarintBytes(uint64(len(v))), v...)
+	case []string:
+		b := listHeader(len(v), thriftBinary)
+		for _, s := range v {
+			_, body := encodeThriftValue(s)
+			b = append(b, body...)
+		}
+		return thriftList, b
+	case []tfield:
+		return thriftStruct, encodeThrift(v...)
+	case [][]tfield:
+		b := listHeader(len(v), thriftStruct)
+		for _, s := range v {
+			b = append(b, encodeThrift(s...)...)
+		}
+		return thriftList, b
+	}
+	panic("unsupported thrift value")
+}
+
+//bit-packed的RLE/bit-packing混合编码
+func encodeHybrid(values []uint64, width int) []byte {
+	groups := (len(values) + 7) / 8
+	b := uvarintBytes(uint64(groups)<<1 | 1)
+	packed := make([]byte, groups*width)
+	for i, v := range values {
+		for j := 0; j < width; j++ {
+			if v>>j&1 == 1 {
+				bit := i*width + j
+				packed[bit/8] |= 1 << (bit % 8)
+			}
+		}
+	}
+	return append(b, packed...)
+}
+
+func testCompress(t *testing.T, codec int, b []byte) []byte {
+	switch codec {
+	case 1:
+		return snappy.Encode(nil, b)
+	case 2:
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(b)
+		gz.Close()
+		return buf.Bytes()
+	case 6:
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer enc.Close()
+		return enc.EncodeAll(b, nil)
+	}
+	return b
+}
+
+type testParquetColumn struct {
+	name     string
+	physical int
+	optional bool
+	dict     bool //字典编码
+	v2       bool //DATA_PAGE_V2
+	value    func(r testInventoryRow) interface{}
+}
+
+var testParquetColumns = []testParquetColumn{
+	{name: "bucket", physical: 6, value: func(r testInventoryRow) interface{} { return "bucket" }},
+	{name: "key", physical: 6, value: func(r testInventoryRow) interface{} { return r.key }},
+	{name: "version_id", physical: 6, optional: true, dict: true, value: func(r testInventoryRow) interface{} { return nullString(r.version) }},
+	{name: "is_latest", physical: 0, optional: true, value: func(r testInventoryRow) interface{} { return r.latest }},
+	{name: "is_delete_marker", physical: 0, optional: true, v2: true, value: func(r testInventoryRow) interface{} { return r.deleted }},
+	{name: "size", physical: 2, optional: true, value: func(r testInventoryRow) interface{} { return r.size }},
+	{name: "last_modified_date", physical: 2, optional: true, value: func(r testInventoryRow) interface{} { return r.modified.UnixMilli() }},
+	{name: "e_tag", physical: 6, optional: true, v2: true, value: func(r testInventoryRow) interface{} { return r.etag }},
+	{name: "storage_class", physical: 6, optional: true, dict: true, v2: true, value: func(r testInventoryRow) interface{} { return nullString(r.class) }},
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func encodePlain(values []interface{}) []byte {
+	var b []byte
+	for i, v := range values {
+		switch v := v.(type) {
+		case bool:
+			if i%8 == 0 {
+				b = append(b, 0)
+			}
+			if v {
+				b[len(b)-1] |= 1 << (i % 8)
+			}
+		case int64:
+			b = appendUint64LE(b, uint64(v))
+		case string:
+			b = append(appendUint32LE(b, uint32(len(v))), v...)
+		}
+	}
+	return b
+}
+
+//测试用的Parquet文件，每个元素是一个row group, 还有一个会被跳过的嵌套列
+func writeTestParquet(t *testing.T, codec int, rowGroups [][]testInventoryRow) []byte {
+	file := []byte(parquetMagic)
+	schema := [][]tfield{
+		{{4, "schema"}, {5, len(testParquetColumns) + 1}},
+		{{3, 1}, {4, "tags"}, {5, 1}},
+		{{1, 6}, {3, 1}, {4, "tag"}},
+	}
+	for _, c := range testParquetColumns {
+		el := []tfield{{1, c.physical}, {3, map[bool]int{false: 0, true: 1}[c.optional]}, {4, c.name}}
+		if c.name == "last_modified_date" { //LogicalType TIMESTAMP(isAdjustedToUTC, MILLIS)
+			el = append(el, tfield{10, []tfield{{8, []tfield{{1, true}, {2, []tfield{{1, []tfield{}}}}}}}})
+		}
+		schema = append(schema, el)
+	}
+	var groups [][]tfield
+	total := 0
+	for _, rows := range rowGroups {
+		chunks := [][]tfield{{{1, 0}, {3, []tfield{{1, 6}, {3, []string{"tags", "tag"}}, {4, codec}, {5, 0}, {6, 0}, {7, 0}, {9, 0}}}}}
+		for _, c := range testParquetColumns {
+			var values []interface{}
+			var defs []uint64
+			for _, r := range rows {
+				if v := c.value(r); v != nil {
+					values = append(values, v)
+					defs = append(defs, 1)
+				} else {
+					defs = append(defs, 0)
+				}
+			}
+			start := len(file)
+			dictOffset := 0
+			encoding, body := 0, encodePlain(values)
+			if c.dict {
+				var dict []interface{}
+				index := map[interface{}]uint64{}
+				var idx []uint64
+				for _, v := range values {
+					if _, ok := index[v]; !ok {
+						index[v] = uint64(len(dict))
+						dict = append(dict, v)
+					}
+					idx = append(idx, index[v])
+				}
+				page := encodePlain(dict)
+				compressed := testCompress(t, codec, page)
+				dictOffset = len(file)
+				file = append(file, encodeThrift(tfield{1, 2}, tfield{2, len(page)}, tfield{3, len(compressed)}, tfield{7, []tfield{{1, len(dict)}, {2, 0}}})...)
+				file = append(file, compressed...)
+				encoding, body = 8, append([]byte{2}, encodeHybrid(idx, 2)...)
+			} else if c.physical == 0 && c.v2 { //布尔值的RLE编码
+				var bools []uint64
+				for _, v := range values {
+					bools = append(bools, map[bool]uint64{false: 0, true: 1}[v.(bool)])
+				}
+				packed := encodeHybrid(bools, 1)
+				encoding, body = 3, append(appendUint32LE(nil, uint32(len(packed))), packed...)
+			}
+			var levels []byte
+			if c.optional {
+				levels = encodeHybrid(defs, 1)
+			}
+			dataOffset := len(file)
+			if c.v2 {
+				compressed := testCompress(t, codec, body)
+				file = append(file, encodeThrift(tfield{1, 3}, tfield{2, len(levels) + len(body)}, tfield{3, len(levels) + len(compressed)},
+					tfield{8, []tfield{{1, len(rows)}, {2, len(rows) - len(values)}, {3, len(rows)}, {4, encoding}, {5, len(levels)}, {6, 0}}})...)
+				file = append(append(file, levels...), compressed...)
+			} else {
+				var page []byte
+				if c.optional {
+					page = append(appendUint32LE(nil, uint32(len(levels))), levels...)
+				}
+				page = append(page, body...)
+				compressed := testCompress(t, codec, page)
+				file = append(file, encodeThrift(tfield{1, 0}, tfield{2, len(page)}, tfield{3, len(compressed)},
+					tfield{5, []tfield{{1, len(rows)}, {2, encoding}, {3, 3}, {4, 3}}})...)
+				file = append(file, compressed...)
+			}
+			md := []tfield{{1, c.physical}, {3, []string{c.name}}, {4, codec}, {5, len(rows)}, {6, len(file) - start}, {7, len(file) - start}, {9, dataOffset}}
+			if dictOffset > 0 {
+				md = append(md, tfield{11, dictOffset})
+			}
+			chunks = append(chunks, []tfield{{1, start}, {3, md}})
+		}
+		groups = append(groups, []tfield{{1, chunks}, {2, 0}, {3, len(rows)}})
+		total += len(rows)
+	}
+	meta := encodeThrift(tfield{1, 1}, tfield{2, schema}, tfield{3, total}, tfield{4, groups})
+	file = append(file, meta...)
+	file = appendUint32LE(file, uint32(len(meta)))
+	return append(file, parquetMagic...)
+}
+
+//测试用的protobuf编码
+func pbVarint(field int, v uint64) []byte {
+	return append(uvarintBytes(uint64(field)<<3), uvarintBytes(v)...)
+}
+
+func pbBytes(field int, b []byte) []byte {
+	return append(append(uvarintBytes(uint64(field)<<3|2), uvarintBytes(uint64(len(b)))...), b...)
+}
+
+func pbMessage(fields ...[]byte) []byte {
+	return bytes.Join(fields, nil)
+}
+
+func orcByteRLE(b []byte) []byte {
+	var out []byte
+	for len(b) > 0 {
+		n := len(b)
+		if n > 128 {
+			n = 128
+		}
+		out = append(append(out, byte(-int8(n))), b[:n]...)
+		b = b[n:]
+	}
+	return out
+}
+
+func orcBoolRLE(values []bool) []byte {
+	b := make([]byte, (len(values)+7)/8)
+	for i, v := range values {
+		if v {
+			b[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return orcByteRLE(b)
+}
+
+//RLE v1的literal, 或者RLE v2的64位DIRECT
+func orcIntRLE(values []int64, signed bool, v1 bool) []byte {
+	var out []byte
+	for len(values) > 0 {
+		n := len(values)
+		if n > 128 {
+			n = 128
+		}
+		if v1 {
+			out = append(out, byte(-int8(n)))
+		} else {
+			out = append(out, 0x40|31<<1|byte((n-1)>>8), byte(n-1))
+		}
+		for _, v := range values[:n] {
+			u := uint64(v)
+			if signed {
+				u = zigzagEncode(v)
+			}
+			if v1 {
+				out = append(out, uvarintBytes(u)...)
+			} else {
+				out = appendUint64BE(out, u)
+			}
+		}
+		values = values[n:]
+	}
+	return out
+}
+
+//按ORC writer的方法去掉纳秒末尾的0
+func orcNanos(ns int) int64 {
+	if ns == 0 || ns%100 != 0 {
+		return int64(ns) << 3
+	}
+	ns /= 100
+	zeros := 1
+	for ns%10 == 0 && zeros < 7 {
+		ns /= 10
+		zeros++
+	}
+	return int64(ns)<<3 | int64(zeros)
+}
+
+func orcCompress(t *testing.T, compression uint64, b []byte) []byte {
+	var c []byte
+	switch compression {
+	case 0:
+		return b
+	case 1:
+		var buf bytes.Buffer
+		w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+		w.Write(b)
+		w.Close()
+		c = buf.Bytes()
+	case 2:
+		c = snappy.Encode(nil, b)
+	case 5:
+		c = testCompress(t, 6, b)
+	}
+	if len(c) >= len(b) { //压缩后没有变小时保存原始数据
+		return append([]byte{byte(len(b)<<1 | 1), byte(len(b) >> 7), byte(len(b) >> 15)}, b...)
+	}
+	return append([]byte{byte(len(c) << 1), byte(len(c) >> 7), byte(len(c) >> 15)}, c...)
+}
+
+//测试用的ORC文件，每个元素是一个stripe; 字符串列覆盖DIRECT, DIRECT_V2, DICTIONARY和DICTIONARY_V2
+func writeTestORC(t *testing.T, compression uint64, stripes [][]testInventoryRow) []byte {
+	type column struct {
+		name     string
+		kind     uint64
+		encoding uint64
+	}
+	columns := []column{
+		{"bucket", 7, 2}, {"key", 7, 2}, {"version_id", 7, 3}, {"is_latest", 0, 0}, {"is_delete_marker", 0, 0},
+		{"size", 4, 2}, {"last_modified_date", 9, 2}, {"e_tag", 7, 0}, {"storage_class", 7, 1},
+	}
+	strValue := map[string]func(r testInventoryRow) string{
+		"bucket":        func(r testInventoryRow) string { return "bucket" },
+		"key":           func(r testInventoryRow) string { return r.key },
+		"version_id":    func(r testInventoryRow) string { return r.version },
+		"e_tag":         func(r testInventoryRow) string { return r.etag },
+		"storage_class": func(r testInventoryRow) string { return r.class },
+	}
+	file := []byte("ORC")
+	var stripeInfo []byte
+	total := 0
+	for _, rows := range stripes {
+		offset := len(file)
+		var streamInfo []byte
+		addStream := func(kind uint64, col int, b []byte) {
+			b = orcCompress(t, compression, b)
+			file = append(file, b...)
+			streamInfo = append(streamInfo, pbBytes(1, pbMessage(pbVarint(1, kind), pbVarint(2, uint64(col)), pbVarint(3, uint64(len(b)))))...)
+		}
+		encodings := pbBytes(2, pbVarint(1, 0))
+		for i, c := range columns {
+			id := i + 1
+			v1 := c.encoding < 2
+			switch c.kind {
+			case 7:
+				var present []bool
+				var values []string
+				for _, r := range rows {
+					s := strValue[c.name](r)
+					present = append(present, s != "")
+					if s != "" {
+						values = append(values, s)
+					}
+				}
+				if len(values) < len(rows) {
+					addStream(orcPresent, id, orcBoolRLE(present))
+				}
+				if c.encoding == 1 || c.encoding == 3 {
+					dict := map[string]int64{}
+					var sorted []string
+					for _, s := range values {
+						if _, ok := dict[s]; !ok {
+							dict[s] = 0
+							sorted = append(sorted, s)
+						}
+					}
+					sort.Strings(sorted)
+					var data []byte
+					var lengths []int64
+					for i, s := range sorted {
+						dict[s] = int64(i)
+						data = append(data, s...)
+						lengths = append(lengths, int64(len(s)))
+					}
+					var idx []int64
+					for _, s := range values {
+						idx = append(idx, dict[s])
+					}
+					addStream(orcData, id, orcIntRLE(idx, false, v1))
+					addStream(orcLength, id, orcIntRLE(lengths, false, v1))
+					addStream(orcDictionaryData, id, data)
+					encodings = append(encodings, pbBytes(2, pbMessage(pbVarint(1, c.encoding), pbVarint(2, uint64(len(sorted)))))...)
+					continue
+				}
+				var data []byte
+				var lengths []int64
+				for _, s := range values {
+					data = append(data, s...)
+					lengths = append(lengths, int64(len(s)))
+				}
+				addStream(orcData, id, data)
+				addStream(orcLength, id, orcIntRLE(lengths, false, v1))
+			case 0:
+				var values []bool
+				for _, r := range rows {
+					values = append(values, map[string]bool{"is_latest": r.latest, "is_delete_marker": r.deleted}[c.name])
+				}
+				addStream(orcData, id, orcBoolRLE(values))
+			case 4:
+				var values []int64
+				for _, r := range rows {
+					values = append(values, r.size)
+				}
+				addStream(orcData, id, orcIntRLE(values, true, v1))
+			case 9:
+				var secs, nanos []int64
+				base := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+				for _, r := range rows {
+					secs = append(secs, r.modified.Unix()-base)
+					nanos = append(nanos, orcNanos(r.modified.Nanosecond()))
+				}
+				addStream(orcData, id, orcIntRLE(secs, true, v1))
+				addStream(orcSecondary, id, orcIntRLE(nanos, false, v1))
+			}
+			encodings = append(encodings, pbBytes(2, pbVarint(1, c.encoding))...)
+		}
+		dataLen := len(file) - offset
+		footer := orcCompress(t, compression, pbMessage(streamInfo, encodings, pbBytes(3, []byte("UTC"))))
+		file = append(file, footer...)
+		stripeInfo = append(stripeInfo, pbBytes(3, pbMessage(pbVarint(1, uint64(offset)), pbVarint(2, 0),
+			pbVarint(3, uint64(dataLen)), pbVarint(4, uint64(len(footer))), pbVarint(5, uint64(len(rows)))))...)
+		total += len(rows)
+	}
+
+	var subtypes, names []byte
+	for i, c := range columns {
+		subtypes = append(subtypes, uvarintBytes(uint64(i+1))...)
+		names = append(names, pbBytes(3, []byte(c.name))...)
+	}
+	types := pbBytes(4, pbMessage(pbVarint(1, 12), pbBytes(2, subtypes), names))
+	for _, c := range columns {
+		types = append(types, pbBytes(4, pbVarint(1, c.kind))...)
+	}
+	footer := orcCompress(t, compression, pbMessage(pbVarint(1, 3), pbVarint(2, uint64(len(file)-3)), stripeInfo, types, pbVarint(6, uint64(total))))
+	file = append(file, footer...)
+	ps := pbMessage(pbVarint(1, uint64(len(footer))), pbVarint(2, compression), pbVarint(3, 262144), pbBytes(8000, []byte("ORC")))
+	file = append(file, ps...)
+	return append(file, byte(len(ps)))
+}
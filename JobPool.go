@@ -0,0 +1,281 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+//job文件中的transfer同时运行，每个transfer在一个子进程中运行，因为参数都保存在全局变量中
+//所有子进程的拷贝和检查worker共用job进程的一个procs大小的名额池: 每处理一个条目前取一个名额，处理完归还
+//子进程的fd 3写请求('a'取名额, 'r'归还), fd 4读发放的名额, fd 5写运行结果
+//子进程退出时，job进程收回它没有归还的名额，所以一个transfer失败不会让其他transfer等不到名额
+const jobTransferEnv = "ADMT_JOB_TRANSFER"
+
+//子进程从环境变量读取的transfer, 包含job文件的vars和defaults
+type childTransfer struct {
+	Spec     *JobSpec `json:"spec"`
+	Transfer Transfer `json:"transfer"`
+}
+
+type workerPool struct {
+	slots chan struct{}
+}
+
+func newWorkerPool(size int) *workerPool {
+	return &workerPool{slots: make(chan struct{}, size)}
+}
+
+//处理一个子进程的请求，直到子进程退出，关闭请求的管道
+func (p *workerPool) serve(req *os.File, grant *os.File) {
+	var mu sync.Mutex
+	held, exited := 0, false
+	buf := make([]byte, 256)
+	for {
+		n, err := req.Read(buf)
+		for _, b := range buf[:n] {
+			switch b {
+			case 'a':
+				go func() { //等待名额时继续读取这个子进程归还的名额
+					p.slots <- struct{}{}
+					mu.Lock()
+					if exited {
+						mu.Unlock()
+						<-p.slots
+						return
+					}
+					held++
+					mu.Unlock()
+					grant.Write([]byte{'g'})
+				}()
+			case 'r':
+				mu.Lock()
+				held--
+				mu.Unlock()
+				<-p.slots
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	mu.Lock()
+	exited = true
+	for ; held > 0; held-- {
+		<-p.slots
+	}
+	mu.Unlock()
+	req.Close()
+	grant.Close()
+}
+
+//在子进程中运行一个transfer, 输出的每一行加上transfer的名字
+//ownGroup为true时子进程使用自己的进程组，终端的Ctrl-C只发给serve, 由serve决定是否等待这次运行结束
+func (s *JobSpec) runChild(t Transfer, pool *workerPool, ownGroup bool) transferReport {
+	report := transferReport{Transfer: t}
+	report.Result.Start = time.Now()
+	failed := func(err error) transferReport {
+		report.Result.End = time.Now()
+		report.Err = err.Error()
+		log.Println("Transfer", t.Name, "failed:", err)
+		return report
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return failed(err)
+	}
+	content, err := json.Marshal(childTransfer{s, t})
+	if err != nil {
+		return failed(err)
+	}
+	var files []*os.File //reqR, reqW, grantR, grantW, resultR, resultW
+	for i := 0; i < 3; i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return failed(err)
+		}
+		files = append(files, r, w)
+	}
+	reqR, reqW, grantR, grantW, resultR, resultW := files[0], files[1], files[2], files[3], files[4], files[5]
+
+	stdout, stderr := newPrefixWriter(os.Stdout, t.Name), newPrefixWriter(os.Stderr, t.Name)
+	cmd := exec.Command(exe, "job")
+	cmd.Env = append(transferEnv(t.Credentials), jobTransferEnv+"="+string(content))
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	cmd.ExtraFiles = []*os.File{reqW, grantR, resultW}
+	if ownGroup {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+	err = cmd.Start()
+	reqW.Close()
+	grantR.Close()
+	resultW.Close()
+	if err != nil {
+		reqR.Close()
+		grantW.Close()
+		resultR.Close()
+		return failed(err)
+	}
+	go pool.serve(reqR, grantW)
+	result, _ := io.ReadAll(resultR)
+	resultR.Close()
+	err = cmd.Wait()
+	stdout.Flush()
+	stderr.Flush()
+	if err != nil {
+		return failed(err)
+	}
+	if err := json.Unmarshal(result, &report.Result); err != nil {
+		return failed(fmt.Errorf("no result from the transfer: %v", err))
+	}
+	return report
+}
+
+//子进程的环境变量，transfer指定credentials时代替job进程的AWS环境变量
+func transferEnv(c *Credentials) []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if name == jobTransferEnv {
+			continue
+		}
+		if c != nil && containsString(credentialEnv, name) {
+			continue
+		}
+		env = append(env, kv)
+	}
+	if c == nil {
+		return env
+	}
+	values := []string{c.Profile, c.AccessKeyID, c.SecretAccessKey, c.SessionToken}
+	for i, name := range credentialEnv {
+		if values[i] != "" {
+			env = append(env, name+"="+values[i])
+		}
+	}
+	return env
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+//子进程: 运行环境变量中的transfer, 结果写入fd 5
+func runChildTransfer(value string) {
+	var child childTransfer
+	if err := json.Unmarshal([]byte(value), &child); err != nil || child.Spec == nil {
+		log.Fatalln("Invalid transfer from the job process:", err)
+	}
+	sharedWorkers = &workerSlots{req: os.NewFile(3, "worker-request"), grant: os.NewFile(4, "worker-grant")}
+	//同时运行的transfer使用各自的临时目录
+	dataDir = filepath.Join(dataDir, "transfer-"+strconv.Itoa(os.Getpid())) + "/"
+	child.Spec.prepare(child.Transfer)
+	result := runJob(child.Transfer.Command)
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fd := os.NewFile(5, "transfer-result")
+	if _, err := fd.Write(b); err != nil {
+		log.Fatalln("Failed to send the result to the job process:", err)
+	}
+	fd.Close()
+}
+
+//子进程中job的worker名额，不在job文件中运行时为nil, 不限制
+type workerSlots struct {
+	req   *os.File
+	grant *os.File
+}
+
+var sharedWorkers *workerSlots
+
+func (w *workerSlots) Acquire() {
+	if w == nil {
+		return
+	}
+	if _, err := w.req.Write([]byte{'a'}); err != nil {
+		log.Fatalln("Lost the worker pool of the job:", err)
+	}
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(w.grant, b); err != nil {
+		log.Fatalln("Lost the worker pool of the job:", err)
+	}
+}
+
+func (w *workerSlots) Release() {
+	if w == nil {
+		return
+	}
+	if _, err := w.req.Write([]byte{'r'}); err != nil {
+		log.Fatalln("Lost the worker pool of the job:", err)
+	}
+}
+
+//按行输出子进程的输出，每行前面加上[transfer名字]
+type prefixWriter struct {
+	mu     sync.Mutex
+	out    io.Writer
+	prefix []byte
+	buf    []byte
+}
+
+var prefixOutputMu sync.Mutex //不同transfer的行不交错
+
+func newPrefixWriter(out io.Writer, name string) *prefixWriter {
+	return &prefixWriter{out: out, prefix: []byte("[" + name + "] ")}
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		p.writeLine(p.buf[:i+1])
+		p.buf = p.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+//输出最后一行没有换行的部分
+func (p *prefixWriter) Flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.buf) > 0 {
+		p.writeLine(append(p.buf, '\n'))
+		p.buf = nil
+	}
+}
+
+func (p *prefixWriter) writeLine(line []byte) {
+	prefixOutputMu.Lock()
+	defer prefixOutputMu.Unlock()
+	p.out.Write(append(append([]byte{}, p.prefix...), line...))
+}
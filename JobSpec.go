@@ -0,0 +1,371 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+//job文件，YAML或JSON(.json扩展名)，例如
+//vars:
+//  bucket: my-bucket
+//defaults:
+//  a: true
+//  c: attr
+//  region: us-east-1
+//credentials:
+//  profile: migration
+//workers: 10
+//report: /tmp/report.csv
+//transfers:
+//  - name: projects
+//    source: /data/projects
+//    destination: s3://${bucket}/projects
+//    options:
+//      sc: GLACIER_IR
+//      exclude: ["*.tmp", "scratch"]
+//  - name: logs
+//    command: cp
+//    source: s3://${bucket}-logs/
+//    destination: s3://${bucket}/logs
+//    credentials:
+//      profile: logs-account
+//options的名字和命令行参数相同，不带-, 列表代表重复的参数。transfer的options覆盖defaults, 指定credentials时代替上层的credentials
+//source, destination, options和credentials中可以使用${name}, 先在vars中查找，再查找环境变量
+type JobSpec struct {
+	Vars        map[string]string      `yaml:"vars" json:"vars"`
+	Defaults    map[string]interface{} `yaml:"defaults" json:"defaults"`
+	Credentials *Credentials           `yaml:"credentials" json:"credentials"`
+	Workers     int                    `yaml:"workers" json:"workers"`
+	Report      string                 `yaml:"report" json:"report"`
+	Schedule    string                 `yaml:"schedule" json:"schedule"` //serve使用的cron表达式，transfer没有指定时使用
+	Transfers   []Transfer             `yaml:"transfers" json:"transfers"`
+}
+
+type Transfer struct {
+	Name        string                 `yaml:"name" json:"name"`
+	Command     string                 `yaml:"command" json:"command"` //sync, cp, verify, 默认sync
+	Source      string                 `yaml:"source" json:"source"`
+	Destination string                 `yaml:"destination" json:"destination"`
+	Options     map[string]interface{} `yaml:"options" json:"options"`
+	Credentials *Credentials           `yaml:"credentials" json:"credentials"`
+	Schedule    string                 `yaml:"schedule" json:"schedule"`
+}
+
+//transfer运行时设置的AWS环境变量，SDK的默认配置从环境变量和~/.aws读取
+type Credentials struct {
+	Profile         string `yaml:"profile" json:"profile"`
+	AccessKeyID     string `yaml:"accessKeyId" json:"accessKeyId"`
+	SecretAccessKey string `yaml:"secretAccessKey" json:"secretAccessKey"`
+	SessionToken    string `yaml:"sessionToken" json:"sessionToken"`
+}
+
+var specVar = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+func LoadJobSpec(specFile string) *JobSpec {
+	content, err := os.ReadFile(specFile)
+	if err != nil {
+		log.Fatalln("Failed to read job file:", err)
+	}
+	var spec JobSpec
+	if strings.ToLower(filepath.Ext(specFile)) == ".json" {
+		err = json.Unmarshal(content, &spec)
+	} else {
+		err = yaml.UnmarshalStrict(content, &spec)
+	}
+	if err != nil {
+		log.Fatalln("Invalid job file:", specFile, err)
+	}
+	if len(spec.Transfers) == 0 {
+		log.Fatalln("No transfers in job file:", specFile)
+	}
+	if spec.Workers < 0 {
+		log.Fatalln("Invalid workers in job file:", spec.Workers)
+	}
+	names := map[string]bool{}
+	for i := range spec.Transfers {
+		t := &spec.Transfers[i]
+		if t.Name == "" {
+			t.Name = "transfer-" + strconv.Itoa(i+1)
+		}
+		if names[t.Name] {
+			log.Fatalln("Duplicate transfer name in job file:", t.Name)
+		}
+		names[t.Name] = true
+		if t.Command == "" {
+			t.Command = "sync"
+		}
+		if !(t.Command == "sync" || t.Command == "cp" || t.Command == "verify") {
+			log.Fatalln("For command of transfer", t.Name, "only 'sync', 'cp', 'verify' are allowed")
+		}
+		if t.Source == "" || t.Destination == "" {
+			log.Fatalln("Transfer", t.Name, "needs source and destination")
+		}
+		if _, ok := t.Options["f"]; ok {
+			log.Fatalln("Option 'f' of transfer", t.Name, "is not allowed, all transfers share 'workers' of the job file")
+		}
+		t.Source = spec.expand(t.Source)
+		t.Destination = spec.expand(t.Destination)
+		if t.Schedule == "" {
+			t.Schedule = spec.Schedule
+		}
+		if t.Credentials == nil {
+			t.Credentials = spec.Credentials
+		}
+		if t.Credentials != nil {
+			c := *t.Credentials
+			c.Profile = spec.expand(c.Profile)
+			c.AccessKeyID = spec.expand(c.AccessKeyID)
+			c.SecretAccessKey = spec.expand(c.SecretAccessKey)
+			c.SessionToken = spec.expand(c.SessionToken)
+			t.Credentials = &c
+		}
+	}
+	if _, ok := spec.Defaults["f"]; ok {
+		log.Fatalln("Option 'f' in defaults is not allowed, please use 'workers'")
+	}
+	return &spec
+}
+
+//替换${name}
+func (s *JobSpec) expand(value string) string {
+	return specVar.ReplaceAllStringFunc(value, func(m string) string {
+		name := specVar.FindStringSubmatch(m)[1]
+		if v, ok := s.Vars[name]; ok {
+			return v
+		}
+		if v, ok := os.LookupEnv(name); ok {
+			return v
+		}
+		log.Fatalln("Undefined variable in job file:", m)
+		return ""
+	})
+}
+
+func (s *JobSpec) optionValues(name string, value interface{}) []string {
+	switch v := value.(type) {
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			values = append(values, s.optionValues(name, item)...)
+		}
+		return values
+	case string:
+		return []string{s.expand(v)}
+	case bool, int, int64:
+		return []string{fmt.Sprint(v)}
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}
+	}
+	log.Fatalln("Invalid value of option", name, "in job file:", value)
+	return nil
+}
+
+func (s *JobSpec) optionArgs(options map[string]interface{}) []string {
+	var names []string
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var args []string
+	for _, name := range names {
+		for _, value := range s.optionValues(name, options[name]) {
+			args = append(args, "-"+strings.TrimLeft(name, "-")+"="+value)
+		}
+	}
+	return args
+}
+
+//transfer的命令行参数和defaults, transfer的options中已有的参数不使用defaults
+//defaults中命令不使用的参数被忽略，例如cp的'-t'
+func (s *JobSpec) args(t Transfer) ([]string, []string) {
+	defaults := map[string]interface{}{}
+	for name, value := range s.Defaults {
+		defaults[strings.TrimLeft(name, "-")] = value
+	}
+	for name := range t.Options {
+		delete(defaults, strings.TrimLeft(name, "-"))
+	}
+	var args []string
+	if s.Workers > 0 {
+		args = append(args, "-f="+strconv.Itoa(s.Workers))
+	}
+	args = append(args, s.optionArgs(t.Options)...)
+	return append(args, t.Source, t.Destination), s.optionArgs(defaults)
+}
+
+var credentialEnv = []string{"AWS_PROFILE", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN"}
+
+//清除上一个transfer的改名、名字报告、存储类型统计和清单的状态
+func resetJobState() {
+	renames = newRenameState()
+	nameReportMu.Lock()
+	nameReport = map[string]nameReportEntry{}
+	nameReportMu.Unlock()
+	classStatsMu.Lock()
+	classStats = map[string]*classStat{}
+	classStatsMu.Unlock()
+	manifestDstMu.Lock()
+	manifestDst = map[string]string{}
+	manifestDstMu.Unlock()
+}
+
+type transferReport struct {
+	Transfer Transfer
+	Result   jobResult
+	Err      string //子进程没有正常结束时的错误
+}
+
+//job: 同时运行job文件中的transfer, 共用一个worker名额池，最后输出一个报告，见JobPool.go
+func RunJobSpec(args []string) {
+	if value, ok := os.LookupEnv(jobTransferEnv); ok {
+		runChildTransfer(value) //job进程启动的子进程
+		return
+	}
+	fs := flag.NewFlagSet("job", flag.ExitOnError)
+	fs.Usage = func() { commandUsage(fs, "job") }
+	var only string
+	fs.StringVar(&only, "only", "", "Comma separated names of the transfers to run, all transfers by default")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	spec := LoadJobSpec(fs.Arg(0))
+
+	transfers := spec.Transfers
+	if only != "" {
+		transfers = nil
+		for _, name := range strings.Split(only, ",") {
+			found := false
+			for _, t := range spec.Transfers {
+				if t.Name == name {
+					transfers = append(transfers, t)
+					found = true
+				}
+			}
+			if !found {
+				log.Fatalln("No transfer in job file:", name)
+			}
+		}
+	}
+
+	spec.validate(transfers)
+	jobFiles := map[string]string{}
+	for _, t := range transfers {
+		jobFile := spec.jobFile(t)
+		if other, ok := jobFiles[jobFile]; ok {
+			log.Fatalln("Transfers", other, "and", t.Name, "have the same source and destination and can not run at the same time")
+		}
+		jobFiles[jobFile] = t.Name
+	}
+
+	//workers设置-f, 所有transfer的拷贝和检查worker共用factor*CPU数个名额
+	procs := factor * runtime.NumCPU()
+	pool := newWorkerPool(procs)
+	log.Printf("Starting %d transfers with %d shared workers\n", len(transfers), procs)
+	reports := make([]transferReport, len(transfers))
+	var wg sync.WaitGroup
+	for i, t := range transfers {
+		wg.Add(1)
+		go func(i int, t Transfer) {
+			defer wg.Done()
+			reports[i] = spec.runChild(t, pool, false)
+		}(i, t)
+	}
+	wg.Wait()
+	printJobReport(reports, spec.Report)
+	for _, r := range reports {
+		if r.Err != "" {
+			os.RemoveAll(dataDir)
+			os.Exit(1)
+		}
+	}
+}
+
+//先检查所有transfer的参数，参数错误时不开始拷贝
+func (s *JobSpec) validate(transfers []Transfer) {
+	for _, t := range transfers {
+		args, defaults := s.args(t)
+		parseOptions(t.Command, args, defaults)
+		if watchMode {
+			log.Fatalln("Option 'watch' of transfer", t.Name, "is not supported in job files")
+		}
+	}
+}
+
+//清除上一个transfer的状态，设置这个transfer的参数
+func (s *JobSpec) prepare(t Transfer) {
+	resetJobState()
+	args, defaults := s.args(t)
+	parseOptions(t.Command, args, defaults)
+}
+
+//解析transfer的参数，返回transfer的job文件
+func (s *JobSpec) jobFile(t Transfer) string {
+	args, defaults := s.args(t)
+	parseOptions(t.Command, args, defaults)
+	return jobFileName(srcPath, dstPath)
+}
+
+func printJobReport(reports []transferReport, reportFile string) {
+	centerPrint(100, "Job File Report", "*")
+	layout := "2006-01-02 15:04:05"
+	fmt.Printf("%-20s%-8s%-21s%10s%10s%10s%10s  %s\n", "Transfer", "Command", "Start time", "Seconds", "Queued", "Pass", "Fail", "Status")
+	var total jobResult
+	for _, r := range reports {
+		status := "done"
+		if r.Err != "" {
+			status = "error: " + r.Err
+		}
+		fmt.Printf("%-20s%-8s%-21s%10.2f%10d%10d%10d  %s\n", r.Transfer.Name, r.Transfer.Command, r.Result.Start.Format(layout), r.Result.End.Sub(r.Result.Start).Seconds(), r.Result.Listed, r.Result.Success, r.Result.Fail, status)
+		total.Listed += r.Result.Listed
+		total.Success += r.Result.Success
+		total.Fail += r.Result.Fail
+		if total.Start.IsZero() || r.Result.Start.Before(total.Start) {
+			total.Start = r.Result.Start
+		}
+		if r.Result.End.After(total.End) {
+			total.End = r.Result.End
+		}
+	}
+	seconds := total.End.Sub(total.Start).Seconds() //transfer同时运行，从最早的开始到最晚的结束
+	fmt.Printf("%-49s%10.2f%10d%10d%10d\n", "Total", seconds, total.Listed, total.Success, total.Fail)
+	if reportFile == "" {
+		return
+	}
+
+	fd, err := os.Create(reportFile)
+	if err != nil {
+		log.Println("Failed to write job report:", err)
+		return
+	}
+	defer fd.Close()
+	w := csv.NewWriter(fd)
+	w.Write([]string{"transfer", "command", "source", "destination", "start", "end", "queued", "check_pass", "check_fail", "error"})
+	for _, r := range reports {
+		w.Write([]string{r.Transfer.Name, r.Transfer.Command, r.Transfer.Source, r.Transfer.Destination,
+			r.Result.Start.Format(time.RFC3339), r.Result.End.Format(time.RFC3339),
+			strconv.FormatInt(r.Result.Listed, 10), strconv.Itoa(r.Result.Success), strconv.Itoa(r.Result.Fail), r.Err})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Println("Failed to write job report:", err)
+	}
+}
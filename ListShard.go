@@ -0,0 +1,280 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+//并行列出对象: 从prefix开始用Delimiter "/"逐级找出子prefix, 到-list-depth级后每个子prefix作为一个shard完整列出
+//最多-list-procs个ListObjectsV2请求同时进行，-list-procs 1时和原来一样顺序列出
+//没有子prefix的大目录按key范围拆分: 一页没有列完并且有空闲的worker时，把剩下的范围从中间拆开，后一半用StartAfter交给空闲的worker
+//每个shard内的key是有序的，同一个目录下的对象在同一个shard中(按key范围拆分时除外)，shard之间的页交错输出
+type listPage struct {
+	Shard    string
+	Contents []types.Object
+	Done     bool //这个shard已经列出完成
+}
+
+//一个shard: prefix下大于startAfter、不大于end的key, end为空时列到prefix结束
+type listTask struct {
+	prefix     string
+	depth      int
+	startAfter string
+	end        string
+}
+
+func (t listTask) shard() string {
+	if t.startAfter == "" {
+		return t.prefix
+	}
+	return t.prefix + "\x00" + t.startAfter
+}
+
+//待列出的shard, 由-list-procs个worker取出，子prefix很多时只增加队列的长度，不增加goroutine
+type listQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	tasks   []listTask
+	pending int //队列中和正在列出的shard数
+	idle    int //等待shard的worker数
+}
+
+func newListQueue() *listQueue {
+	q := &listQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *listQueue) push(t listTask) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.tasks = append(q.tasks, t)
+	q.pending++
+	q.cond.Signal()
+}
+
+//有空闲的worker并且队列为空时才加入，返回是否加入
+func (q *listQueue) trySplit(t listTask) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.idle == 0 || len(q.tasks) > 0 {
+		return false
+	}
+	q.tasks = append(q.tasks, t)
+	q.pending++
+	q.cond.Signal()
+	return true
+}
+
+//所有shard都列出完成后返回false
+func (q *listQueue) pop() (listTask, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.idle++
+	for len(q.tasks) == 0 && q.pending > 0 {
+		q.cond.Wait()
+	}
+	q.idle--
+	if len(q.tasks) == 0 {
+		return listTask{}, false
+	}
+	t := q.tasks[0]
+	q.tasks = q.tasks[1:]
+	return t, true
+}
+
+func (q *listQueue) done() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending--
+	if q.pending == 0 {
+		q.cond.Broadcast()
+	}
+}
+
+func listPaginator(client *s3.Client, bucket string, prefix string, delimiter string, startAfter string) *s3.ListObjectsV2Paginator {
+	params := &s3.ListObjectsV2Input{
+		Bucket:     aws.String(bucket),
+		Prefix:     aws.String(prefix),
+		Delimiter:  strOrNil(delimiter),
+		StartAfter: strOrNil(startAfter),
+	}
+	return s3.NewListObjectsV2Paginator(client, params, func(o *s3.ListObjectsV2PaginatorOptions) {
+		o.Limit = 10000
+	})
+}
+
+//depth小于-list-depth时用Delimiter只列出这一级的对象，子prefix加入队列，否则列出prefix下的所有对象
+//q为nil时不按key范围拆分
+func listShard(client *s3.Client, bucket string, t listTask, q *listQueue, pages chan<- listPage) {
+	delimiter := ""
+	if q != nil && t.depth < listDepth {
+		delimiter = "/"
+	}
+	paginator := listPaginator(client, bucket, t.prefix, delimiter, t.startAfter)
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			log.Printf("error: %v", err)
+			break
+		}
+		contents, more := keysInRange(output.Contents, t)
+		if delimiter != "" {
+			contents = directContents(contents, t.prefix, delimiter)
+		}
+		if len(contents) > 0 {
+			pages <- listPage{Shard: t.shard(), Contents: contents}
+		}
+		for _, common := range output.CommonPrefixes {
+			sub := aws.ToString(common.Prefix)
+			if sub <= t.startAfter {
+				continue //子prefix跨过拆分点时，由前一个范围加入
+			}
+			if t.end != "" && sub > t.end {
+				more = false
+				break
+			}
+			q.push(listTask{prefix: sub, depth: t.depth + 1})
+		}
+		if !more {
+			break
+		}
+		if q != nil && paginator.HasMorePages() && !expandPacks() {
+			t = splitTask(t, lastListed(output), q)
+		}
+	}
+	pages <- listPage{Shard: t.shard(), Done: true}
+}
+
+//只保留t范围内的对象，返回false时已经超过了范围的结尾
+func keysInRange(contents []types.Object, t listTask) ([]types.Object, bool) {
+	in := contents[:0]
+	for _, value := range contents {
+		key := aws.ToString(value.Key)
+		if t.end != "" && key > t.end {
+			return in, false
+		}
+		if key > t.startAfter {
+			in = append(in, value)
+		}
+	}
+	return in, true
+}
+
+//这一页最后的key或子prefix, 下一页从它之后开始
+func lastListed(output *s3.ListObjectsV2Output) string {
+	var last string
+	if n := len(output.Contents); n > 0 {
+		last = aws.ToString(output.Contents[n-1].Key)
+	}
+	if n := len(output.CommonPrefixes); n > 0 && aws.ToString(output.CommonPrefixes[n-1].Prefix) > last {
+		last = aws.ToString(output.CommonPrefixes[n-1].Prefix)
+	}
+	return last
+}
+
+//把t剩下的范围(last, end]从中间拆开，后一半交给空闲的worker, 返回当前worker继续列出的前一半
+//拆分后两个shard各自结束，shard名字不变
+func splitTask(t listTask, last string, q *listQueue) listTask {
+	end := t.end
+	if end == "" {
+		end = t.prefix + "\x7f" //key中常见的ASCII字符都小于\x7f, 更大的key都在后一半中
+	}
+	mid := midKey(last, end)
+	if mid == "" || !strings.HasPrefix(mid, t.prefix) {
+		return t
+	}
+	if q.trySplit(listTask{prefix: t.prefix, depth: t.depth, startAfter: mid, end: t.end}) {
+		t.end = mid
+	}
+	return t
+}
+
+//a和b之间的一个key, 只使用可打印的ASCII字符，作为StartAfter的参数，没有时返回空字符串
+func midKey(a string, b string) string {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	const base = 0x7f - 0x20
+	digit := func(s string, j int) uint64 {
+		if j >= len(s) || s[j] < 0x20 {
+			return 0
+		}
+		if s[j] >= 0x7f {
+			return base - 1
+		}
+		return uint64(s[j] - 0x20)
+	}
+	var x, y uint64
+	for k := 0; k < 8; k++ {
+		x = x*base + digit(a, i+k)
+		y = y*base + digit(b, i+k)
+	}
+	if y <= x+1 {
+		return ""
+	}
+	m := x + (y-x)/2
+	buf := make([]byte, 8)
+	for k := 7; k >= 0; k-- {
+		buf[k] = byte(m%base) + 0x20
+		m /= base
+	}
+	mid := a[:i] + strings.TrimRight(string(buf), " ")
+	if mid <= a || mid >= b {
+		return ""
+	}
+	return mid
+}
+
+//只保留这一级的对象，有的S3兼容存储会把子prefix的目录对象同时放在Contents中，这些对象由子prefix的shard列出
+func directContents(contents []types.Object, prefix string, delimiter string) []types.Object {
+	direct := contents[:0]
+	for _, value := range contents {
+		if !strings.Contains(strings.TrimPrefix(aws.ToString(value.Key), prefix), delimiter) {
+			direct = append(direct, value)
+		}
+	}
+	return direct
+}
+
+//返回列出的对象页，全部列出后关闭
+func listPages(client *s3.Client, bucket string, prefix string) <-chan listPage {
+	pages := make(chan listPage, 2*listProcs+1)
+	go func() {
+		defer close(pages)
+		if listProcs <= 1 {
+			listShard(client, bucket, listTask{prefix: prefix}, nil, pages)
+			return
+		}
+
+		q := newListQueue()
+		q.push(listTask{prefix: prefix})
+		var wg sync.WaitGroup
+		for i := 0; i < listProcs; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					t, ok := q.pop()
+					if !ok {
+						return
+					}
+					listShard(client, bucket, t, q, pages)
+					q.done()
+				}
+			}()
+		}
+		wg.Wait()
+	}()
+	return pages
+}
@@ -4,277 +4,125 @@
 package main
 
 import (
-	"context"
-	"log"
-	"path/filepath"
-	"regexp"
-	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
 func (f FileWalk) Listobj(client *s3.Client, srcBucket string, srcPrefix string) {
 
-	params := &s3.ListObjectsV2Input{
-		Bucket: aws.String(srcBucket),
-		Prefix: aws.String(srcPrefix),
-	}
-	paginator := s3.NewListObjectsV2Paginator(client, params, func(o *s3.ListObjectsV2PaginatorOptions) {
-		o.Limit = 10000
+	//读取属性由headStage并发完成，结果直接放入FileList
+	heads := newHeadStage(func(job headJob) FileInfo {
+		return f.copyObjInfo(client, job)
+	}, func(objInfo FileInfo) {
+		f.FileList <- objInfo
 	})
-
-	for paginator.HasMorePages() {
-
-		output, err := paginator.NextPage(context.TODO())
-		if err != nil {
-			log.Printf("error: %v", err)
-			return
-		}
-
-		for _, value := range output.Contents {
-
-			if f.IsInitialCopy {
-				var objInfo FileInfo
-				if f.withAttr {
-					objInfo = GetObjMetadata(client, srcBucket, srcPrefix, *value.Key)
-
-				} else {
-					objInfo = GetObjMetadataWithoutAttr(client, srcBucket, srcPrefix, *value.Key, value.LastModified.Unix(), value.Size)
-
-				}
-
-				if objInfo.CStatus.CopyStatus == "notFound" {
-					continue //如果获取Key信息的时候报错，就直接跳过这个对象
-				}
-				//这里去掉. ..两个目录
-				if !(objInfo.Filename == "./" || objInfo.Filename == "../" || objInfo.Filename == ".." || objInfo.Filename == ".") {
-					f.FileList <- objInfo
-
-				}
-
-			} else { //增量拷贝
-				filename, err := filepath.Rel(srcPrefix, *value.Key) //在key上去除掉原来的prefix
-				if err != nil {
-					log.Fatalln("Unable to get relative path:", *value.Key, err)
-				}
-				isDir, _ := regexp.MatchString("/$", *value.Key)
-				if isDir {
-					filename = filename + "/"
-				}
-
-				if f.FileMap[filename].CStatus.CopyStatus != "checkPass" {
-					var objInfo FileInfo
-					if f.withAttr {
-						objInfo = GetObjMetadata(client, srcBucket, srcPrefix, *value.Key)
-
-					} else {
-						objInfo = GetObjMetadataWithoutAttr(client, srcBucket, srcPrefix, *value.Key, value.LastModified.Unix(), value.Size)
-
-					}
-					if objInfo.CStatus.CopyStatus == "notFound" {
-						continue //如果获取Key信息的时候报错，就直接跳过这个对象
-					}
-
-					if !(objInfo.Filename == "./" || objInfo.Filename == "../" || objInfo.Filename == ".." || objInfo.Filename == ".") {
-						f.FileList <- objInfo
-
-					}
-
-				}
-
+	defer heads.Close()
+
+	packs := map[string]*packExpander{} //每个shard单独展开bundle
+	for page := range listPages(client, srcBucket, srcPrefix) {
+		if page.Done {
+			if expander, ok := packs[page.Shard]; ok {
+				expander.Flush()
+				delete(packs, page.Shard)
 			}
+			continue
 		}
-
-	}
-}
-
-func (f FileWalk) ListobjforSrcCheck(client *s3.Client, srcBucket string, srcPrefix string) {
-
-	params := &s3.ListObjectsV2Input{
-		Bucket: aws.String(srcBucket),
-		Prefix: aws.String(srcPrefix),
-	}
-	paginator := s3.NewListObjectsV2Paginator(client, params, func(o *s3.ListObjectsV2PaginatorOptions) {
-		o.Limit = 10000
-	})
-
-	for paginator.HasMorePages() {
-
-		output, err := paginator.NextPage(context.TODO())
-		if err != nil {
-			log.Printf("error: %v", err)
-			return
+		expander := packs[page.Shard]
+		if expander == nil && expandPacks() {
+			expander = newPackExpander(client, srcBucket, srcSSE, f.sendPacked)
+			packs[page.Shard] = expander
 		}
 
-		for _, value := range output.Contents {
-
-			var objInfo FileInfo
-			if f.withAttr {
-				objInfo = GetObjMetadata(client, srcBucket, srcPrefix, *value.Key)
-
-			} else {
-				objInfo = GetObjMetadataWithoutAttr(client, srcBucket, srcPrefix, *value.Key, value.LastModified.Unix(), value.Size)
-
+		for _, value := range page.Contents {
+			if isXattrSidecar(*value.Key) {
+				continue //o2o时sidecar和对象一起拷贝
 			}
 
-			if objInfo.CStatus.CopyStatus == "notFound" {
-				continue //如果获取Key信息的时候报错，就直接跳过这个对象
+			job := listedJob(srcPrefix, value)
+			send := func() {
+				if !f.IsInitialCopy && f.FileMap[job.Filename].CStatus.CopyStatus == "checkPass" {
+					return //增量拷贝
+				}
+				heads.Add(job)
 			}
-
-			if !(objInfo.Filename == "./" || objInfo.Filename == "../" || objInfo.Filename == ".." || objInfo.Filename == ".") {
-				f.SrcCheckMap[objInfo.Filename] = objInfo
-
+			if expander != nil {
+				expander.Add(job.Key, job.LastModified, send) //bundle展开为其中的文件
+			} else {
+				send()
 			}
-
 		}
 
 	}
 }
 
-func (f FileWalk) ListobjforDstCheck(client *s3.Client, dstBucket string, dstPrefix string) {
-
-	params := &s3.ListObjectsV2Input{
-		Bucket: aws.String(dstBucket),
-		Prefix: aws.String(dstPrefix),
-	}
-	paginator := s3.NewListObjectsV2Paginator(client, params, func(o *s3.ListObjectsV2PaginatorOptions) {
-		o.Limit = 10000
-	})
-
-	for paginator.HasMorePages() {
-
-		output, err := paginator.NextPage(context.TODO())
-		if err != nil {
-			log.Printf("error: %v", err)
-			return
-		}
-
-		for _, value := range output.Contents {
-
-			var objInfo FileInfo
-			if f.withAttr {
-				objInfo = GetObjMetadata(client, dstBucket, dstPrefix, *value.Key)
-
-			} else {
-				objInfo = GetObjMetadataWithoutAttr(client, dstBucket, dstPrefix, *value.Key, value.LastModified.Unix(), value.Size)
-
-			}
-
-			if objInfo.CStatus.CopyStatus == "notFound" {
-				continue //如果获取Key信息的时候报错，就直接跳过这个对象
-			}
-			if !(objInfo.Filename == "./" || objInfo.Filename == "../" || objInfo.Filename == ".." || objInfo.Filename == ".") {
-				f.DstCheckMap[objInfo.Filename] = objInfo
-
-			}
-
-		}
+func (f FileWalk) ListobjforSrcCheck(client *s3.Client, srcBucket string, srcPrefix string) {
+	f.listobjforCheck(client, srcBucket, srcPrefix, srcSSE, true, false)
+}
 
-	}
+func (f FileWalk) ListobjforDstCheck(client *s3.Client, dstBucket string, dstPrefix string) {
+	f.listobjforCheck(client, dstBucket, dstPrefix, dstSSE, false, false)
 }
 
 func (f FileWalk) ListobjforSrcIncrCheck(client *s3.Client, srcBucket string, srcPrefix string) {
-
-	params := &s3.ListObjectsV2Input{
-		Bucket: aws.String(srcBucket),
-		Prefix: aws.String(srcPrefix),
-	}
-	paginator := s3.NewListObjectsV2Paginator(client, params, func(o *s3.ListObjectsV2PaginatorOptions) {
-		o.Limit = 10000
-	})
-
-	for paginator.HasMorePages() {
-
-		output, err := paginator.NextPage(context.TODO())
-		if err != nil {
-			log.Printf("error: %v", err)
-			return
-		}
-
-		for _, value := range output.Contents {
-
-			filename, err := filepath.Rel(srcPrefix, *value.Key) //在key上去除掉原来的prefix
-			if err != nil {
-				log.Fatalln("Unable to get relative path:", *value.Key, err)
-			}
-			isDir, _ := regexp.MatchString("/$", *value.Key)
-			if isDir {
-				filename = filename + "/"
-			}
-
-			if f.FileMap[filename].CStatus.CopyStatus != "checkPass" {
-				var objInfo FileInfo
-				if f.withAttr {
-					objInfo = GetObjMetadata(client, srcBucket, srcPrefix, *value.Key)
-
-				} else {
-					objInfo = GetObjMetadataWithoutAttr(client, srcBucket, srcPrefix, *value.Key, value.LastModified.Unix(), value.Size)
-
-				}
-				if objInfo.CStatus.CopyStatus == "notFound" {
-					continue //如果获取Key信息的时候报错，就直接跳过这个对象
-				}
-
-				if !(objInfo.Filename == "./" || objInfo.Filename == "../" || objInfo.Filename == ".." || objInfo.Filename == ".") {
-					f.SrcCheckMap[objInfo.Filename] = objInfo
-
-				}
-
-			}
-
-		}
-
-	}
+	f.listobjforCheck(client, srcBucket, srcPrefix, srcSSE, true, true)
 }
 
 func (f FileWalk) ListobjforDstIncrCheck(client *s3.Client, dstBucket string, dstPrefix string) {
+	f.listobjforCheck(client, dstBucket, dstPrefix, dstSSE, false, true)
+}
 
-	params := &s3.ListObjectsV2Input{
-		Bucket: aws.String(dstBucket),
-		Prefix: aws.String(dstPrefix),
+//列出bucket放入检查的列表, incr为true时只记录名字，上次已经检查通过的对象在归并时跳过，其他对象归并后再读取属性
+func (f FileWalk) listobjforCheck(client *s3.Client, Bucket string, Prefix string, sse *SSEConfig, isSrc bool, incr bool) {
+	addEntry := f.addDstEntry
+	if isSrc {
+		addEntry = f.addSrcEntry
 	}
-	paginator := s3.NewListObjectsV2Paginator(client, params, func(o *s3.ListObjectsV2PaginatorOptions) {
-		o.Limit = 10000
-	})
-
-	for paginator.HasMorePages() {
+	add := func(info FileInfo) {
+		addEntry(info, nil)
+	}
+	lazy := incr && (!isSrc || lazySrcCheck())
 
-		output, err := paginator.NextPage(context.TODO())
-		if err != nil {
-			log.Printf("error: %v", err)
-			return
+	heads := newHeadStage(func(job headJob) FileInfo {
+		objInfo := f.objInfo(client, Bucket, Prefix, job, sse)
+		if objInfo.CStatus.CopyStatus == "notFound" {
+			return objInfo
 		}
-
-		for _, value := range output.Contents {
-
-			filename, err := filepath.Rel(dstPrefix, *value.Key) //在key上去除掉原来的prefix
-			if err != nil {
-				log.Fatalln("Unable to get relative path:", *value.Key, err)
+		return withObjTags(client, Bucket, job.Key, objInfo)
+	}, add)
+	defer heads.Close()
+
+	packs := map[string]*packExpander{}
+	for page := range listPages(client, Bucket, Prefix) {
+		if page.Done {
+			if expander, ok := packs[page.Shard]; ok {
+				expander.Flush()
+				delete(packs, page.Shard)
 			}
-			isDir, _ := regexp.MatchString("/$", *value.Key)
-			if isDir {
-				filename = filename + "/"
-			}
-
-			if f.FileMap[filename].CStatus.CopyStatus != "checkPass" {
-				var objInfo FileInfo
-				if f.withAttr {
-					objInfo = GetObjMetadata(client, dstBucket, dstPrefix, *value.Key)
-
-				} else {
-					objInfo = GetObjMetadataWithoutAttr(client, dstBucket, dstPrefix, *value.Key, value.LastModified.Unix(), value.Size)
-
-				}
-				if objInfo.CStatus.CopyStatus == "notFound" {
-					continue //如果获取Key信息的时候报错，就直接跳过这个对象
-				}
+			continue
+		}
+		expander := packs[page.Shard]
+		if expander == nil && expandPacks() {
+			expander = newPackExpander(client, Bucket, sse, add) //bundle中的文件在索引中已经有属性
+			packs[page.Shard] = expander
+		}
 
-				if !(objInfo.Filename == "./" || objInfo.Filename == "../" || objInfo.Filename == ".." || objInfo.Filename == ".") {
-					f.DstCheckMap[objInfo.Filename] = objInfo
+		for _, value := range page.Contents {
+			if isXattrSidecar(*value.Key) {
+				continue
+			}
 
+			job := listedJob(Prefix, value)
+			send := func() {
+				if lazy {
+					addEntry(FileInfo{Filename: job.Filename}, &checkFetch{Key: job.Key, LastModified: job.LastModified, Size: job.Size})
+					return
 				}
-
+				heads.Add(job)
+			}
+			if expander != nil {
+				expander.Add(job.Key, job.LastModified, send)
+			} else {
+				send()
 			}
-
 		}
 
 	}
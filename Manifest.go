@@ -0,0 +1,427 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+//-files-from指定的清单文件，只拷贝清单中列出的文件或对象，不扫描源目录，也不列出源bucket
+//格式按扩展名识别: .csv, .jsonl或.ndjson, 其他为纯文本，每行一个路径，空行和#开头的行忽略, -代表标准输入
+//CSV: path[,dst[,size[,version]]]，第一行包含path或key列时作为表头，按列名识别
+//JSONL: {"path": "logs/a.txt", "dst": "archive/a.txt", "size": 123, "version": "3HL4kqtJlcpXroDTDmJ"}
+//path为源端的相对路径，也可以是源目录下的绝对路径或s3://<源bucket>/<源prefix>下的key, 以/结尾的为目录
+//dst为目标端的相对路径，指定时不使用-rename-rules; version为源对象的版本，只用于源端为s3时
+//size为对象大小，o2o不带属性拷贝时可以省略HeadObject
+type ManifestEntry struct {
+	Path    string `json:"path"`
+	Key     string `json:"key"`
+	Dst     string `json:"dst"`
+	Size    *int64 `json:"size"`
+	Version string `json:"version"`
+}
+
+//清单中指定的目标端名字，源端相对路径到目标端相对路径
+var (
+	manifestDstMu sync.Mutex
+	manifestDst   = map[string]string{}
+)
+
+func manifestFormat(manifestFile string) string {
+	switch strings.ToLower(filepath.Ext(manifestFile)) {
+	case ".csv":
+		return "csv"
+	case ".jsonl", ".ndjson":
+		return "jsonl"
+	}
+	return "text"
+}
+
+//标准输入只能读取一次，第一次读取时保存到临时文件，拷贝之后的检查再从临时文件读取
+var stdinManifest string
+
+func openManifest(manifestFile string) *os.File {
+	if manifestFile != "-" {
+		fd, err := os.Open(manifestFile)
+		if err != nil {
+			log.Fatalln("Failed to read manifest:", err)
+		}
+		return fd
+	}
+	if stdinManifest == "" {
+		tmpfile := CreateTempFile(dataDir, "stdin-manifest")
+		if _, err := io.Copy(tmpfile, os.Stdin); err != nil {
+			log.Fatalln("Failed to read manifest from stdin:", err)
+		}
+		tmpfile.Close()
+		stdinManifest = tmpfile.Name()
+	}
+	fd, err := os.Open(stdinManifest)
+	if err != nil {
+		log.Fatalln("Failed to read manifest:", err)
+	}
+	return fd
+}
+
+//按顺序读取清单，每个条目调用一次fn
+func ReadManifest(manifestFile string, fn func(ManifestEntry)) {
+	fd := openManifest(manifestFile)
+	defer fd.Close()
+	var r io.Reader = fd
+
+	switch manifestFormat(manifestFile) {
+	case "csv":
+		readManifestCSV(r, fn)
+	case "jsonl":
+		readManifestLines(r, func(line string) {
+			var entry ManifestEntry
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				log.Println("Invalid manifest line:", line, err)
+				return
+			}
+			if entry.Path == "" {
+				entry.Path = entry.Key
+			}
+			fn(entry)
+		})
+	default:
+		readManifestLines(r, func(line string) {
+			fn(ManifestEntry{Path: line})
+		})
+	}
+}
+
+func readManifestLines(r io.Reader, fn func(string)) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fn(line)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalln("Failed to read manifest:", err)
+	}
+}
+
+func readManifestCSV(r io.Reader, fn func(ManifestEntry)) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	columns := map[string]int{"path": 0, "dst": 1, "size": 2, "version": 3}
+	first := true
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Fatalln("Failed to read manifest:", err)
+		}
+		if first {
+			first = false
+			if header := manifestHeader(record); header != nil {
+				columns = header
+				continue
+			}
+		}
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		entry := ManifestEntry{Path: field("path"), Dst: field("dst"), Version: field("version")}
+		if value := field("size"); value != "" {
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				log.Println("Invalid size in manifest:", record)
+				continue
+			}
+			entry.Size = &size
+		}
+		if entry.Path == "" {
+			continue
+		}
+		fn(entry)
+	}
+}
+
+//第一行包含path或key列时作为表头
+func manifestHeader(record []string) map[string]int {
+	columns := map[string]int{}
+	for i, name := range record {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "key" {
+			name = "path"
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["path"]; !ok {
+		return nil
+	}
+	return columns
+}
+
+var s3URI = regexp.MustCompile(`^s3://([^/]+)/(.*)$`)
+
+//清单中的路径转换为源端的相对路径，不在源目录或源prefix下时返回false
+func manifestName(p string) (string, bool) {
+	isDir := strings.HasSuffix(p, "/")
+	if strings.HasPrefix(mode, "o2") {
+		if m := s3URI.FindStringSubmatch(p); m != nil {
+			if m[1] != srcBucket || !strings.HasPrefix(m[2], srcPrefix) {
+				return "", false
+			}
+			p = strings.TrimPrefix(m[2], srcPrefix)
+		}
+	} else if filepath.IsAbs(p) {
+		root, err := filepath.Abs(srcPath)
+		if err != nil {
+			return "", false
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return "", false
+		}
+		p = rel
+	}
+	p = filepath.Clean(p) //不能读取源目录以外的文件
+	if p == "." || p == ".." || strings.HasPrefix(p, "../") || filepath.IsAbs(p) {
+		return "", false
+	}
+	if isDir {
+		p = p + "/"
+	}
+	return p, true
+}
+
+//目标端的名字不能是绝对路径或包含.., 否则返回false
+func setManifestDst(src string, dst string) bool {
+	isDir := strings.HasSuffix(src, "/")
+	if strings.HasPrefix(dst, "/") || hasDotDot(dst) {
+		return false
+	}
+	dst = strings.Trim(dst, "/")
+	if dst == "" {
+		return true
+	}
+	if isDir {
+		dst = dst + "/"
+	}
+	manifestDstMu.Lock()
+	defer manifestDstMu.Unlock()
+	manifestDst[src] = dst
+	return true
+}
+
+func manifestDstName(src string) (string, bool) {
+	manifestDstMu.Lock()
+	defer manifestDstMu.Unlock()
+	dst, ok := manifestDst[src]
+	return dst, ok
+}
+
+//-sc-rules或-rename-rules中使用mtime时，不能省略HeadObject
+func needsObjMtime() bool {
+	for _, rule := range storageClassRules {
+		if rule.MinAge != "" || rule.MaxAge != "" {
+			return true
+		}
+	}
+	for _, rule := range renameRules {
+		if strings.Contains(rule.Replace, "{mtime:") {
+			return true
+		}
+	}
+	return false
+}
+
+//不带属性时，通过HeadObject读取大小和修改时间
+func headObjInfo(client *s3.Client, Bucket string, Prefix string, key string, version string, sse *SSEConfig) FileInfo {
+	filename, err := filepath.Rel(Prefix, key)
+	if err != nil {
+		log.Fatalln("Unable to get relative path:", key, err)
+	}
+	isDir := strings.HasSuffix(key, "/")
+	if isDir {
+		filename = filename + "/"
+	}
+	headInput := &s3.HeadObjectInput{
+		Bucket:    aws.String(Bucket),
+		Key:       aws.String(key),
+		VersionId: strOrNil(version),
+	}
+	sse.applyHead(headInput)
+	output, err := client.HeadObject(context.TODO(), headInput)
+	if err != nil {
+		log.Println(key, ":", err)
+		return FileInfo{Filename: filename, CStatus: CopyInfo{CopyStatus: "notFound"}}
+	}
+	if isDir {
+		return FileInfo{IsMetaExist: false, Filename: filename, FType: "0040", FmTime: output.LastModified.Unix(), FSize: plainSize(output)}
+	}
+	return FileInfo{IsMetaExist: false, Filename: filename, FType: "0100", FmTime: output.LastModified.Unix(), FSize: plainSize(output)}
+}
+
+//清单条目对应的源端文件或对象属性, 读取失败时CopyStatus为notFound
+func (f FileWalk) manifestSrcInfo(client *s3.Client, name string, entry ManifestEntry) FileInfo {
+	if !strings.HasPrefix(mode, "o2") {
+		fsrcPath := pathJoin(f.SrcPath, name)
+		if f.withAttr {
+			return GetFileMetadata(f.SrcPath, fsrcPath)
+		}
+		return GetFileMetadataWithoutAttr(f.SrcPath, fsrcPath)
+	}
+
+	key := pathJoin(srcPrefix, name)
+	var objInfo FileInfo
+	if f.withAttr {
+		objInfo = GetObjVersionMetadata(client, srcBucket, srcPrefix, key, entry.Version, srcSSE)
+	} else if entry.Size != nil && mode == "o2o" && !needsObjMtime() {
+		//o2o不需要修改时间，清单中有大小时不用HeadObject
+		objInfo = FileInfo{IsMetaExist: false, Filename: name, FType: "0100", FSize: *entry.Size}
+		if strings.HasSuffix(name, "/") {
+			objInfo.FType = "0040"
+		}
+	} else {
+		objInfo = headObjInfo(client, srcBucket, srcPrefix, key, entry.Version, srcSSE)
+	}
+	if entry.Size != nil && objInfo.CStatus.CopyStatus != "notFound" && objInfo.FSize != *entry.Size {
+		log.Printf("Size of %s is %d, not %d as in manifest\n", name, objInfo.FSize, *entry.Size)
+	}
+	objInfo.FVersion = entry.Version
+	return objInfo
+}
+
+//读取清单，代替filepath.Walk和Listobj把待拷贝的文件放入FileList
+//读取属性和Listobj一样由headStage并发完成
+func (f FileWalk) ListManifest(client *s3.Client, manifestFile string) {
+	heads := newHeadStage(func(job headJob) FileInfo {
+		objInfo := f.manifestSrcInfo(client, job.Filename, *job.Entry)
+		if objInfo.CStatus.CopyStatus == "notFound" {
+			log.Println("Skip manifest entry not found in the source:", job.Entry.Path)
+		}
+		return objInfo
+	}, func(objInfo FileInfo) {
+		if !f.IsInitialCopy && f.FileMap[objInfo.Filename].CStatus.CopyStatus == "checkPass" {
+			return //清单中的目录没有以/结尾时，读取属性后才能确定名字
+		}
+		f.FileList <- objInfo
+	})
+	defer heads.Close()
+
+	ReadManifest(manifestFile, func(entry ManifestEntry) {
+		name, ok := manifestName(entry.Path)
+		if !ok {
+			log.Println("Skip manifest entry outside of the source:", entry.Path)
+			return
+		}
+		if entry.Dst != "" && !setManifestDst(name, entry.Dst) {
+			log.Println("Skip manifest entry with an absolute destination or ..:", entry.Dst)
+			return
+		}
+		if !f.IsInitialCopy && f.FileMap[name].CStatus.CopyStatus == "checkPass" {
+			return
+		}
+		heads.Add(manifestJob(name, entry))
+	})
+}
+
+func manifestJob(name string, entry ManifestEntry) headJob {
+	return headJob{Key: pathJoin(srcPrefix, name), Filename: name, Entry: &entry}
+}
+
+//只检查清单中的文件，目标端逐个读取，不列出整个目标目录或bucket
+//清单中的目录可以不以/结尾，读取属性后才能确定名字，所以incr时也逐个读取，上次已经检查通过的文件在归并时跳过
+func (f FileWalk) ManifestCheck(client *s3.Client, manifestFile string, incr bool) {
+	//f2o打包的小文件没有单独的对象，需要通过bundle的索引查找
+	listDst := mode == "f2o" && packThreshold > 0
+
+	//源端和目标端的属性都在headStage中读取，源端在fetch中，目标端在sink中
+	heads := newHeadStage(func(job headJob) FileInfo {
+		objInfo := f.manifestSrcInfo(client, job.Filename, *job.Entry)
+		if objInfo.CStatus.CopyStatus == "notFound" {
+			return objInfo
+		}
+		if mode == "o2o" {
+			objInfo = withObjTags(client, srcBucket, pathJoin(srcPrefix, objInfo.Filename), objInfo)
+		}
+		return objInfo
+	}, func(objInfo FileInfo) {
+		f.addSrcCheck(objInfo)
+		if !listDst {
+			f.manifestDstCheck(client, objInfo)
+		}
+	})
+
+	ReadManifest(manifestFile, func(entry ManifestEntry) {
+		name, ok := manifestName(entry.Path)
+		if !ok {
+			return
+		}
+		if entry.Dst != "" && !setManifestDst(name, entry.Dst) {
+			return
+		}
+		heads.Add(manifestJob(name, entry))
+	})
+	heads.Close()
+	close(f.FileList)
+
+	if listDst {
+		if incr {
+			f.ListobjforDstIncrCheck(client, dstBucket, dstPrefix)
+		} else {
+			f.ListobjforDstCheck(client, dstBucket, dstPrefix)
+		}
+	}
+}
+
+//清单中的条目在目标端的属性，目标端不存在时不加入检查的列表
+func (f FileWalk) manifestDstCheck(client *s3.Client, objInfo FileInfo) {
+	dstName := RenamePath(objInfo)
+	if dstName == "" {
+		return
+	}
+	var dstInfo FileInfo
+	if strings.HasSuffix(mode, "2f") {
+		fdstPath := pathJoin(f.DstPath, dstName)
+		if f.withAttr {
+			dstInfo = GetFileMetadata(f.DstPath, fdstPath)
+		} else {
+			dstInfo = GetFileMetadataWithoutAttr(f.DstPath, fdstPath)
+		}
+	} else {
+		key := pathJoin(dstPrefix, dstName)
+		if f.withAttr {
+			dstInfo = GetObjMetadata(client, dstBucket, dstPrefix, key, dstSSE)
+		} else {
+			dstInfo = headObjInfo(client, dstBucket, dstPrefix, key, "", dstSSE)
+		}
+	}
+	if dstInfo.CStatus.CopyStatus == "notFound" {
+		return
+	}
+	if strings.HasSuffix(mode, "2o") {
+		dstInfo = withObjTags(client, dstBucket, pathJoin(dstPrefix, dstName), dstInfo)
+	}
+	f.addDstCheck(dstInfo)
+}
@@ -0,0 +1,325 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//--metadata-format支持的格式
+//admt: lustre格式，file-owner/file-group/file-permissions/file-atime/file-mtime
+//s3fs: s3fs-fuse格式，mode为10进制的st_mode，uid/gid，mtime/atime为unix秒
+//rclone: rclone格式，mode为8进制的st_mode，uid/gid，mtime/atime为RFC3339
+//mountpoint: Mountpoint for Amazon S3不保存属性，上传时不写属性metadata
+//auto: 上传时使用admt格式，读取时自动识别以上任意格式
+var metadataFormats = []string{"auto", "admt", "s3fs", "rclone", "mountpoint"}
+
+//各格式使用的metadata key，第一个key以外的任意一个存在，就认为是该格式
+var formatMetaKeys = map[string][]string{
+	"admt":   {"user-agent", "file-owner", "file-group", "file-permissions", "file-atime", "file-mtime", "file-owner-name", "file-group-name"},
+	"s3fs":   {"", "mode", "uid", "gid", "mtime", "atime", "ctime"},
+	"rclone": {"", "mode", "uid", "gid", "mtime", "atime", "btime"},
+}
+
+//其他工具写入的x-amz-meta-*在下载到文件系统时保存在这个xattr命名空间，上传时再还原为metadata
+const userMetaXattrPrefix = "user.admt.meta."
+
+//时间在metadata中按lustre的格式保存，即unix纳秒加ns后缀，如1663921235123456789ns
+func formatNsTime(sec int64, nsec int64) string {
+	return strconv.FormatInt(sec*1e9+nsec, 10) + "ns"
+}
+
+//兼容旧版本admt写入的秒格式，这时返回的precise为false
+func parseNsTime(value string) (int64, int64, bool) {
+	if strings.HasSuffix(value, "ns") {
+		ns, err := strconv.ParseInt(strings.TrimSuffix(value, "ns"), 10, 64)
+		if err == nil {
+			return ns / 1e9, ns % 1e9, true
+		}
+	}
+	sec, _ := strconv.ParseInt(value, 10, 64)
+	return sec, 0, false
+}
+
+//s3fs的时间为unix秒，新版本可能带小数部分
+func parseS3fsTime(value string) (int64, int64, bool) {
+	secStr, nsecStr, hasNsec := strings.Cut(value, ".")
+	sec, err := strconv.ParseInt(secStr, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if !hasNsec {
+		return sec, 0, true
+	}
+	nsecStr = (nsecStr + "000000000")[:9]
+	nsec, err := strconv.ParseInt(nsecStr, 10, 64)
+	if err != nil {
+		return sec, 0, true
+	}
+	return sec, nsec, true
+}
+
+func validMetadataFormat(format string) bool {
+	for _, f := range metadataFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+//把FileInfo中的属性按--metadata-format转换为s3 metadata, UploadS3和F2O_DirCopy共用
+func buildAttrMetadata(info FileInfo) map[string]string {
+	metadata := map[string]string{}
+	for k, v := range info.FUserMeta { //先放用户metadata，避免覆盖属性
+		metadata[k] = v
+	}
+	fileMode := fullFileMode(info.FType, info.FPerm)
+
+	switch metadataFormat {
+	case "s3fs":
+		metadata["mode"] = strconv.FormatUint(uint64(fileMode), 10)
+		metadata["uid"] = strconv.Itoa(info.FUID)
+		metadata["gid"] = strconv.Itoa(info.FGID)
+		metadata["mtime"] = strconv.FormatInt(info.FmTime, 10)
+		metadata["atime"] = strconv.FormatInt(info.FaTime, 10)
+	case "rclone":
+		metadata["mode"] = strconv.FormatUint(uint64(fileMode), 8)
+		metadata["uid"] = strconv.Itoa(info.FUID)
+		metadata["gid"] = strconv.Itoa(info.FGID)
+		metadata["mtime"] = time.Unix(info.FmTime, info.FmTimeNs).UTC().Format(time.RFC3339Nano)
+		metadata["atime"] = time.Unix(info.FaTime, info.FaTimeNs).UTC().Format(time.RFC3339Nano)
+	case "mountpoint":
+		//Mountpoint不读取属性，只保留用户metadata
+	default:
+		metadata["user-agent"] = info.FUserAgent
+		metadata["file-owner"] = strconv.FormatInt(int64(info.FUID), 10)
+		metadata["file-group"] = strconv.FormatInt(int64(info.FGID), 10)
+		metadata["file-permissions"] = info.FType + info.FPerm
+		metadata["file-atime"] = formatNsTime(info.FaTime, info.FaTimeNs)
+		metadata["file-mtime"] = formatNsTime(info.FmTime, info.FmTimeNs)
+		if info.FUser != "" { //用户名和组名用于-idmap按名字映射
+			metadata["file-owner-name"] = info.FUser
+		}
+		if info.FGroup != "" {
+			metadata["file-group-name"] = info.FGroup
+		}
+	}
+	return metadata
+}
+
+//根据key判断metadata的格式，没有属性时返回空字符串
+//指定格式时只识别该格式，auto时依次识别admt, rclone, s3fs
+func detectMetadataFormat(metadata map[string]string) string {
+	switch metadataFormat {
+	case "admt", "s3fs", "rclone":
+		if hasFormatKeys(metadataFormat, metadata) {
+			return metadataFormat
+		}
+		return ""
+	case "mountpoint":
+		return ""
+	}
+
+	if hasFormatKeys("admt", metadata) {
+		return "admt"
+	}
+	if !hasFormatKeys("s3fs", metadata) { //s3fs和rclone的key相同
+		return ""
+	}
+	if _, err := time.Parse(time.RFC3339Nano, metadata["mtime"]); err == nil {
+		return "rclone"
+	}
+	//没有mtime时看mode, s3fs的mode是10进制，小于0200000，rclone的8进制mode按10进制解析会远大于这个值
+	if m, err := strconv.ParseUint(metadata["mode"], 10, 32); err == nil && m >= 0200000 {
+		return "rclone"
+	}
+	return "s3fs"
+}
+
+//user-agent比较通用，不作为判断依据
+func hasFormatKeys(format string, metadata map[string]string) bool {
+	for _, k := range formatMetaKeys[format][1:] {
+		if _, ok := metadata[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func isAttrMetaKey(format string, k string) bool {
+	if k == xattrMetaKey || isCSEMetaKey(k) || isCompressMetaKey(k) {
+		return true
+	}
+	for _, attrKey := range formatMetaKeys[format] {
+		if attrKey != "" && k == attrKey {
+			return true
+		}
+	}
+	return false
+}
+
+//返回属性以外的metadata
+func extraMetadata(format string, metadata map[string]string) map[string]string {
+	var extra map[string]string
+	for k, v := range metadata {
+		if isAttrMetaKey(format, k) {
+			continue
+		}
+		if extra == nil {
+			extra = make(map[string]string)
+		}
+		extra[k] = v
+	}
+	return extra
+}
+
+//每个属性单独校验，缺失或格式错误的属性使用默认值，不影响其他属性
+func parseAttrMetadata(format string, metadata map[string]string, key string, isDir bool, lastModified int64) FileInfo {
+	objInfo := FileInfo{IsMetaExist: true, FUserAgent: "admt", FaTime: lastModified, FmTime: lastModified}
+	var fType, fPerm, atimeKey, mtimeKey, uidKey, gidKey string
+	var ok bool
+	var parseTime func(string) (int64, int64, bool)
+
+	switch format {
+	case "s3fs", "rclone":
+		base := 10
+		if format == "rclone" {
+			base = 8
+		}
+		fType, fPerm, ok = parseFileMode(metadata["mode"], base)
+		atimeKey, mtimeKey, uidKey, gidKey = "atime", "mtime", "uid", "gid"
+		parseTime = parseS3fsTime
+		if format == "rclone" {
+			parseTime = parseRFC3339Time
+		}
+	default:
+		if ua, exist := metadata["user-agent"]; exist {
+			objInfo.FUserAgent = ua
+		}
+		fType, fPerm, ok = parsePermissions(metadata["file-permissions"])
+		atimeKey, mtimeKey, uidKey, gidKey = "file-atime", "file-mtime", "file-owner", "file-group"
+		objInfo.FUser = metadata["file-owner-name"]
+		objInfo.FGroup = metadata["file-group-name"]
+		parseTime = parseNsTime
+	}
+
+	if !ok {
+		for _, k := range []string{"file-permissions", "mode"} {
+			if value, exist := metadata[k]; exist && isAttrMetaKey(format, k) {
+				log.Println("Invalid", k, ":", key, value)
+			}
+		}
+		if isDir {
+			fType = "0040"
+		} else {
+			fType = "0100"
+		}
+		fPerm = defaultFileMode.Mode
+	}
+	objInfo.FType = fType
+	objInfo.FPerm = fPerm
+	objInfo.FUID = parseIDMetadata(metadata, uidKey, key, defaultFileMode.UID)
+	objInfo.FGID = parseIDMetadata(metadata, gidKey, key, defaultFileMode.GID)
+
+	aNsec, mNsec := false, false
+	if value, exist := metadata[atimeKey]; exist {
+		objInfo.FaTime, objInfo.FaTimeNs, aNsec = parseTime(value)
+	}
+	if value, exist := metadata[mtimeKey]; exist {
+		objInfo.FmTime, objInfo.FmTimeNs, mNsec = parseTime(value)
+	}
+	//s3fs只有秒，不作为纳秒精度比较
+	objInfo.FNsec = aNsec && mNsec && format != "s3fs"
+	return objInfo
+}
+
+func parseRFC3339Time(value string) (int64, int64, bool) {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return 0, 0, false
+	}
+	return t.Unix(), int64(t.Nanosecond()), true
+}
+
+//把FType+FPerm转换为完整的st_mode, 如0100+644为0100644
+func fullFileMode(fType string, fPerm string) uint32 {
+	t, _ := strconv.ParseUint(fType, 8, 32)
+	p, _ := strconv.ParseUint(fPerm, 8, 32)
+	return uint32(t<<9 | p)
+}
+
+//把s3fs和rclone的st_mode拆分为FType和FPerm
+func parseFileMode(value string, base int) (string, string, bool) {
+	m, err := strconv.ParseUint(value, base, 32)
+	if err != nil {
+		return "", "", false
+	}
+	typeMap := map[uint64]string{0040000: "0040", 0120000: "0120", 0100000: "0100"}
+	fType, ok := typeMap[m&0170000]
+	if !ok {
+		return "", "", false
+	}
+	return fType, fmt.Sprintf("%03o", m&07777), true
+}
+
+//file-permissions格式为4位类型加3到4位8进制权限，如0100644
+func parsePermissions(value string) (string, string, bool) {
+	if len(value) < 7 || len(value) > 8 {
+		return "", "", false
+	}
+	fType := value[:4]
+	fPerm := value[4:]
+	if !(fType == "0040" || fType == "0120" || fType == "0100") {
+		return "", "", false
+	}
+	if _, err := strconv.ParseUint(fPerm, 8, 32); err != nil {
+		return "", "", false
+	}
+	return fType, fPerm, true
+}
+
+func parseIDMetadata(metadata map[string]string, k string, key string, defaultID int) int {
+	value, ok := metadata[k]
+	if !ok {
+		return defaultID
+	}
+	id, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		log.Println("Invalid", k, ":", key, value)
+		return defaultID
+	}
+	return int(id)
+}
+
+func userMetaToXattr(userMeta map[string]string) map[string][]byte {
+	xattrs := make(map[string][]byte)
+	for k, v := range userMeta {
+		xattrs[userMetaXattrPrefix+k] = []byte(v)
+	}
+	return xattrs
+}
+
+//把保留命名空间里的xattr拆出来作为用户metadata，其余的作为普通xattr
+func splitUserMetaXattr(xattrs map[string][]byte) (map[string][]byte, map[string]string) {
+	var userMeta map[string]string
+	for name, value := range xattrs {
+		if !strings.HasPrefix(name, userMetaXattrPrefix) {
+			continue
+		}
+		if userMeta == nil {
+			userMeta = make(map[string]string)
+		}
+		userMeta[strings.TrimPrefix(name, userMetaXattrPrefix)] = string(value)
+		delete(xattrs, name)
+	}
+	if len(xattrs) == 0 {
+		xattrs = nil
+	}
+	return xattrs, userMeta
+}
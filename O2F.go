@@ -21,7 +21,7 @@ import (
 func O2F_DirCopy(client *s3.Client, info FileInfo, srcBucket string, srcPrefix string, dstPath string, defaultFileMode Filemod) {
 
 	filename := info.Filename
-	fdstPath := pathJoin(dstPath, filename)
+	fdstPath := dstJoin(dstPath, info)
 
 	//os.MkdirAll，当目录存在时，不做任何事，返回nil，所以这个判断是有必要的，当目录存在时，需要更改目录权限，以保证后续文件能有权限写入（有可能因为某些原因目标目录权限更改使得程序没有写入权限）
 	_, err := os.Lstat(fdstPath)
@@ -42,20 +42,23 @@ func O2F_RegCopy(client *s3.Client, info FileInfo, srcBucket string, srcPrefix s
 	})
 
 	filename := info.Filename
-	fdstPath := pathJoin(dstPath, filename)
+	fdstPath := dstJoin(dstPath, info)
 
 	_, err := os.Lstat(filepath.Dir(fdstPath)) //判断目录是否已存在,不存在就新建。
 	if errors.Is(err, os.ErrNotExist) {
 		os.MkdirAll(filepath.Dir(fdstPath), 0775)
 	}
-	fd, err := os.Create(fdstPath)
-	if err != nil {
-		log.Println("Create file failed", err)
+	err = downloadReplace(fdstPath, func(fd *os.File) error {
+		if info.FPack != nil { //打包在bundle中的文件，从bundle中读取
+			return DownloadPacked(client, fd, srcBucket, srcPrefix, info, srcSSE)
+		}
+		fsrcPath := pathJoin(srcPrefix, filename)
+		return DownloadS3Decode(downloader, fd, srcBucket, fsrcPath, info.FVersion, srcSSE)
+	})
+	if err != nil { //下载失败时不修改属性，检查时会发现这个文件失败
+		log.Println("Failed to copy", filename, err)
+		return
 	}
-	defer fd.Close()
-
-	fsrcPath := pathJoin(srcPrefix, filename)
-	DownloadS3(downloader, fd, srcBucket, fsrcPath)
 
 	Chattr(info, fdstPath, true, defaultFileMode) //目录会随着目录下的文件更新而更新，所以这里不更新时间
 
@@ -68,7 +71,7 @@ func O2F_SymCopy(client *s3.Client, info FileInfo, srcBucket string, srcPrefix s
 	downloader := manager.NewDownloader(client)
 
 	filename := info.Filename
-	fdstPath := pathJoin(dstPath, filename)
+	fdstPath := dstJoin(dstPath, info)
 
 	_, err := os.Lstat(filepath.Dir(fdstPath)) //判断目录是否已存在,不存在的话创建
 	if errors.Is(err, os.ErrNotExist) {
@@ -84,7 +87,7 @@ func O2F_SymCopy(client *s3.Client, info FileInfo, srcBucket string, srcPrefix s
 	tmpfile := CreateTempFile(dataDir, filepath.Base(filename))
 
 	fsrcPath := pathJoin(srcPrefix, filename)
-	DownloadS3(downloader, tmpfile, srcBucket, fsrcPath)
+	DownloadS3(downloader, tmpfile, srcBucket, fsrcPath, info.FVersion, srcSSE)
 
 	b, err := os.ReadFile(tmpfile.Name())
 	if err != nil {
@@ -7,6 +7,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/url"
 	"regexp"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -18,35 +19,62 @@ func O2O_ObjectCopy(client *s3.Client, info FileInfo, srcBucket string, srcPrefi
 
 	filename := info.Filename
 	fsrcPath := pathJoin(srcPrefix, filename)
-	fdstPath := pathJoin(dstPrefix, filename)
+	fdstPath := dstJoin(dstPrefix, info)
 	isDir, _ := regexp.MatchString("/$", filename)
-	if isDir {
-		//CopyObject如果是directory,不支持storageclass
-		_, err := client.CopyObject(context.TODO(), &s3.CopyObjectInput{
-			Bucket:       aws.String(dstBucket),
-			CopySource:   aws.String(srcBucket + "/" + fsrcPath),
-			Key:          aws.String(fdstPath),
-		})
-		if err != nil {
-			log.Println("Error:",fsrcPath, err)
-		} else {
-			fmt.Println("Copy:", filename)
+	//CopyObject的MetadataDirective默认为COPY，admt属性和其他x-amz-meta-*都会原样拷贝
+	copySource := srcBucket + "/" + fsrcPath
+	if info.FVersion != "" { //-files-from指定了源对象的版本
+		copySource = copySource + "?versionId=" + url.QueryEscape(info.FVersion)
+	}
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(dstBucket),
+		CopySource: aws.String(copySource),
+		Key:        aws.String(fdstPath),
+	}
+	if !isDir { //CopyObject如果是directory,不支持storageclass
+		input.StorageClass = types.StorageClass(*aws.String(storageClass))
+	}
+	srcSSE.applyCopySource(input)
+	dstSSE.applyCopy(input) //指定目标端加密参数时，会用新的key重新加密
+	if err := applyCopyTags(client, input, srcBucket, fsrcPath, info.FVersion, filename); err != nil {
+		log.Println("Error:", fsrcPath, err)
+		return
+	}
+
+	//-o2o-headers为true时，按-content-type和-header-rules重新设置header，这时需要REPLACE
+	if o2oReplaceHeaders && !isDir && info.FType != "0120" {
+		headInput := &s3.HeadObjectInput{
+			Bucket:    aws.String(srcBucket),
+			Key:       aws.String(fsrcPath),
+			VersionId: strOrNil(info.FVersion),
 		}
-	}else{
-		_, err := client.CopyObject(context.TODO(), &s3.CopyObjectInput{
-			Bucket:       aws.String(dstBucket),
-			CopySource:   aws.String(srcBucket + "/" + fsrcPath),
-			Key:          aws.String(fdstPath),
-			StorageClass: types.StorageClass(*aws.String(storageClass)),
-		})
+		srcSSE.applyHead(headInput)
+		head, err := client.HeadObject(context.TODO(), headInput)
 		if err != nil {
-			log.Println("Error:",fsrcPath, err)
-		} else {
-			fmt.Println("Copy:", filename)
+			log.Println("Error:", fsrcPath, err)
+			return
 		}
+		headers := GetObjectHeaders(filename, nil) //o2o不读取对象内容，只按扩展名判断Content-Type
+		if !isDefaultContentType(head.ContentType) && matchHeaderRule(filename) == nil {
+			headers.ContentType = "" //源对象已经有Content-Type时保留
+		}
+		applyCopyHeaders(input, head, headers)
+		input.MetadataDirective = types.MetadataDirectiveReplace
 	}
 
+	_, err := client.CopyObject(context.TODO(), input)
+	if err != nil {
+		log.Println("Error:", fsrcPath, err)
+	} else {
+		if !isDir {
+			recordStorageClass(storageClass, info.FSize)
+		}
+		CopyXattrSidecar(client, srcBucket, fsrcPath, dstBucket, fdstPath)
+		fmt.Println("Copy:", filename)
+	}
 
+}
 
-
+func isDefaultContentType(contentType *string) bool {
+	return contentType == nil || *contentType == "" || *contentType == "binary/octet-stream" || *contentType == "application/octet-stream"
 }
@@ -0,0 +1,491 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+//小文件打包: F2O时小于-pack-threshold的文件按目录打包成tar，每个tar有一个json格式的索引对象
+//bundle的名字为<目录>/.admt-pack-<20位纳秒时间戳>-<序号>.tar, 索引为同名的.idx.json
+//时间戳保证增量拷贝时不会覆盖旧的bundle, 同一个文件在多个bundle中时，按名字排序后面的(即较新的)生效
+//O2F和检查时，列表中的索引会展开为其中的文件，每个文件通过索引中的偏移量用ranged GET单独读取
+const (
+	packNamePrefix  = ".admt-pack-"
+	packTarSuffix   = ".tar"
+	packIndexSuffix = ".idx.json"
+)
+
+//打包文件在bundle中的位置，Bundle为bundle相对于prefix的路径
+type PackRef struct {
+	Bundle string
+	Offset int64
+	Size   int64
+}
+
+type packIndex struct {
+	Bundle  string
+	Members []FileInfo
+}
+
+type packBundle struct {
+	mu      sync.Mutex
+	name    string //相对路径，不带后缀
+	tmpfile *os.File
+	tw      *tar.Writer
+	members []FileInfo
+	size    int64
+}
+
+type Packer struct {
+	mu        sync.Mutex
+	bundles   map[string]*packBundle //key为目录
+	seq       int
+	client    *s3.Client
+	srcPath   string
+	dstBucket string
+	dstPrefix string
+}
+
+func NewPacker(client *s3.Client, srcPath string, dstBucket string, dstPrefix string) *Packer {
+	return &Packer{bundles: map[string]*packBundle{}, client: client, srcPath: srcPath, dstBucket: dstBucket, dstPrefix: dstPrefix}
+}
+
+func isPackKey(key string) bool {
+	return strings.HasPrefix(path.Base(key), packNamePrefix) && (strings.HasSuffix(key, packTarSuffix) || strings.HasSuffix(key, packIndexSuffix))
+}
+
+func isPackIndexKey(key string) bool {
+	return isPackKey(key) && strings.HasSuffix(key, packIndexSuffix)
+}
+
+//只有f2o的目标端和o2f, o2a的源端展开bundle, o2o时bundle当作普通对象拷贝
+func expandPacks() bool {
+	return mode == "f2o" || mode == "o2f" || mode == "o2a"
+}
+
+func shouldPack(info FileInfo) bool {
+	return packThreshold > 0 && info.FType == "0100" && info.FSize < packThreshold
+}
+
+func (p *Packer) bundle(dir string) *packBundle {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b, ok := p.bundles[dir]
+	if ok {
+		return b
+	}
+	p.seq++
+	name := path.Join(dir, fmt.Sprintf("%s%020d-%d", packNamePrefix, time.Now().UnixNano(), p.seq))
+	tmpfile := CreateTempFile(dataDir, path.Base(name))
+	b = &packBundle{name: name, tmpfile: tmpfile, tw: tar.NewWriter(tmpfile)}
+	p.bundles[dir] = b
+	return b
+}
+
+//把文件加入所在目录的bundle，bundle达到-pack-size时上传
+func (p *Packer) Add(info FileInfo) {
+	fsrcPath := pathJoin(p.srcPath, info.Filename)
+	fd, err := os.Open(fsrcPath)
+	if err != nil {
+		log.Println("Failed opening file", fsrcPath, err)
+		return
+	}
+	defer fd.Close()
+	info.Filename = RenamePath(info) //bundle和索引中使用目标端的名字
+	dir := path.Dir(info.Filename)
+
+	for {
+		b := p.bundle(dir)
+		b.mu.Lock()
+		if b.tw == nil { //bundle已经被其他goroutine上传，重新获取
+			b.mu.Unlock()
+			continue
+		}
+		full := b.add(info, fd)
+		var detached *packBundle
+		if full {
+			p.mu.Lock()
+			delete(p.bundles, dir)
+			p.mu.Unlock()
+			detached = b.detach()
+		}
+		b.mu.Unlock()
+		if detached != nil { //上传时不持有锁，等待这个bundle的goroutine可以马上换到新的bundle
+			p.flush(detached)
+		}
+		break
+	}
+	fmt.Println("Pack:", info.Filename)
+}
+
+//在b.mu中调用，把内容交给返回的bundle上传，b.tw置为nil后其他goroutine不会再写入b
+func (b *packBundle) detach() *packBundle {
+	detached := &packBundle{name: b.name, tmpfile: b.tmpfile, tw: b.tw, members: b.members, size: b.size}
+	b.tw = nil
+	return detached
+}
+
+func (b *packBundle) add(info FileInfo, fd *os.File) bool {
+	perm, err := strconv.ParseInt(info.FPerm, 8, 64)
+	if err != nil {
+		perm = 0644
+	}
+	hdr := &tar.Header{
+		Typeflag:   tar.TypeReg,
+		Name:       path.Base(info.Filename),
+		Size:       info.FSize,
+		Mode:       perm,
+		Uid:        info.FUID,
+		Gid:        info.FGID,
+		Uname:      info.FUser,
+		Gname:      info.FGroup,
+		ModTime:    time.Unix(info.FmTime, info.FmTimeNs),
+		AccessTime: time.Unix(info.FaTime, info.FaTimeNs),
+		Format:     tar.FormatPAX,
+	}
+	if err := b.tw.WriteHeader(hdr); err != nil {
+		log.Println("Failed to pack:", info.Filename, err)
+		return false
+	}
+	offset, _ := b.tmpfile.Seek(0, io.SeekCurrent) //tar.Writer不缓存，header写完后的位置就是文件内容的偏移量
+	n, err := io.CopyN(b.tw, fd, info.FSize)
+	if err != nil {
+		//文件在拷贝过程中被修改，用0补齐header中的大小，保证后面的文件还能继续写入，这个文件不记录到索引中
+		log.Println("Failed to pack:", info.Filename, err)
+		io.CopyN(b.tw, zeroReader{}, info.FSize-n)
+		return false
+	}
+	info.FPack = &PackRef{Bundle: b.name + packTarSuffix, Offset: offset, Size: n}
+	b.members = append(b.members, info)
+	b.size = offset + n
+	return b.size >= packSize
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+//上传tar和索引，索引在tar之后上传，保证有索引时tar一定存在
+func (p *Packer) flush(b *packBundle) {
+	defer os.Remove(b.tmpfile.Name())
+	defer b.tmpfile.Close()
+	err := b.tw.Close()
+	b.tw = nil
+	if err != nil {
+		log.Println("Failed to pack:", b.name, err)
+		return
+	}
+	if len(b.members) == 0 {
+		return
+	}
+	if _, err := b.tmpfile.Seek(0, io.SeekStart); err != nil {
+		log.Println("Failed to pack:", b.name, err)
+		return
+	}
+
+	uploader := manager.NewUploader(p.client, func(u *manager.Uploader) {
+		u.PartSize = partSize * 1024 * 1024
+	})
+	tarInput := &s3.PutObjectInput{
+		Bucket:       aws.String(p.dstBucket),
+		Key:          aws.String(pathJoin(p.dstPrefix, b.name+packTarSuffix)),
+		Body:         b.tmpfile,
+		StorageClass: types.StorageClass(storageClass),
+		ContentType:  aws.String("application/x-tar"),
+	}
+	dstSSE.applyPut(tarInput)
+	applyPutTags(tarInput, b.name+packTarSuffix)
+	if _, err := uploader.Upload(context.TODO(), tarInput); err != nil {
+		log.Println("Failed to upload:", b.name+packTarSuffix, err)
+		return
+	}
+	recordStorageClass(storageClass, b.size)
+
+	index, err := json.Marshal(packIndex{Bundle: b.name + packTarSuffix, Members: b.members})
+	if err != nil {
+		log.Println("Failed to pack:", b.name, err)
+		return
+	}
+	indexInput := &s3.PutObjectInput{
+		Bucket:      aws.String(p.dstBucket),
+		Key:         aws.String(pathJoin(p.dstPrefix, b.name+packIndexSuffix)),
+		Body:        strings.NewReader(string(index)),
+		ContentType: aws.String("application/json"),
+	}
+	dstSSE.applyPut(indexInput)
+	applyPutTags(indexInput, b.name+packIndexSuffix)
+	if _, err := p.client.PutObject(context.TODO(), indexInput); err != nil {
+		log.Println("Failed to upload:", b.name+packIndexSuffix, err)
+		return
+	}
+	fmt.Println("Copy:", b.name+packTarSuffix, len(b.members), "files")
+}
+
+//拷贝结束后上传所有未满的bundle
+func (p *Packer) Close() {
+	p.mu.Lock()
+	bundles := p.bundles
+	p.bundles = map[string]*packBundle{}
+	p.mu.Unlock()
+	for _, b := range bundles {
+		b.mu.Lock()
+		detached := b.detach()
+		b.mu.Unlock()
+		p.flush(detached)
+	}
+}
+
+func ReadPackIndex(client *s3.Client, Bucket string, Key string, sse *SSEConfig) []FileInfo {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(Bucket),
+		Key:    aws.String(Key),
+	}
+	sse.applyGet(input)
+	output, err := client.GetObject(context.TODO(), input)
+	if err != nil {
+		log.Println("Failed to read pack index:", Key, err)
+		return nil
+	}
+	defer output.Body.Close()
+	var index packIndex
+	if err := json.NewDecoder(output.Body).Decode(&index); err != nil {
+		log.Println("Invalid pack index:", Key, err)
+		return nil
+	}
+	return index.Members
+}
+
+//列表中展开bundle: 索引中的文件交给emit, 普通对象在确定没有被bundle覆盖后调用send
+//同一个文件同时是普通对象和bundle中的文件时(例如两次拷贝之间文件大小跨过了-pack-threshold), 上传较晚的生效:
+//普通对象按LastModified, bundle按名字中的时间戳，时间相同时bundle生效
+//列表按key排序，一个目录的bundle是连续的。目录中排在bundle之前的普通对象(名字小于.admt-pack-)暂存到列表越过bundle之后再决定
+//目录中的bundle文件缓存到列表离开这个目录时输出，内存和目录深度以及每个目录中打包的文件数有关
+type packExpander struct {
+	client *s3.Client
+	bucket string
+	sse    *SSEConfig
+	emit   func(FileInfo)
+	dirs   []*packDir //当前key所在的各级目录，外层在前
+}
+
+type packDir struct {
+	prefix  string              //目录的key前缀，以/结尾，顶层为空
+	members map[string]FileInfo //key为不含目录的文件名，较新的bundle覆盖较旧的
+	early   []looseKey          //排在bundle之前的普通对象
+	passed  bool                //列表已经越过这个目录的bundle
+}
+
+type looseKey struct {
+	name         string
+	lastModified int64
+	send         func()
+}
+
+func newPackExpander(client *s3.Client, bucket string, sse *SSEConfig, emit func(FileInfo)) *packExpander {
+	return &packExpander{client: client, bucket: bucket, sse: sse, emit: emit}
+}
+
+//bundle名字中的时间戳，单位为秒
+func bundleTime(bundle string) int64 {
+	ts, _, _ := strings.Cut(strings.TrimPrefix(path.Base(bundle), packNamePrefix), "-")
+	ns, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return ns / int64(time.Second)
+}
+
+//按列表顺序加入一个key, 普通对象由send放入后续的处理
+func (e *packExpander) Add(key string, lastModified int64, send func()) {
+	e.advance(key)
+	dir := ""
+	if i := strings.LastIndex(strings.TrimSuffix(key, "/"), "/"); i >= 0 {
+		dir = key[:i+1]
+	}
+	if isPackKey(key) {
+		if isPackIndexKey(key) {
+			d := e.enter(dir)
+			for _, member := range ReadPackIndex(e.client, e.bucket, key, e.sse) {
+				d.members[path.Base(member.Filename)] = member
+			}
+		}
+		return
+	}
+	if strings.HasSuffix(key, "/") { //目录不会和打包的文件重名
+		send()
+		return
+	}
+	loose := looseKey{name: key[len(dir):], lastModified: lastModified, send: send}
+	if loose.name < packNamePrefix {
+		d := e.enter(dir)
+		if !d.passed {
+			d.early = append(d.early, loose)
+			return
+		}
+	}
+	if n := len(e.dirs); n > 0 && e.dirs[n-1].prefix == dir {
+		e.dirs[n-1].resolve(loose)
+		return
+	}
+	send()
+}
+
+//离开不再包含key的目录，越过bundle的目录处理暂存的普通对象
+func (e *packExpander) advance(key string) {
+	for n := len(e.dirs); n > 0 && !strings.HasPrefix(key, e.dirs[n-1].prefix); n = len(e.dirs) {
+		e.flush(e.dirs[n-1])
+		e.dirs = e.dirs[:n-1]
+	}
+	for _, d := range e.dirs {
+		if !d.passed && key >= d.prefix+strings.TrimSuffix(packNamePrefix, "-")+"." {
+			d.release()
+		}
+	}
+}
+
+func (e *packExpander) enter(dir string) *packDir {
+	if n := len(e.dirs); n > 0 && e.dirs[n-1].prefix == dir {
+		return e.dirs[n-1]
+	}
+	d := &packDir{prefix: dir, members: map[string]FileInfo{}}
+	e.dirs = append(e.dirs, d)
+	return d
+}
+
+func (d *packDir) release() {
+	d.passed = true
+	for _, loose := range d.early {
+		d.resolve(loose)
+	}
+	d.early = nil
+}
+
+func (d *packDir) resolve(loose looseKey) {
+	if member, ok := d.members[loose.name]; ok {
+		if loose.lastModified <= bundleTime(member.FPack.Bundle) {
+			return //bundle较新，跳过普通对象
+		}
+		delete(d.members, loose.name)
+	}
+	loose.send()
+}
+
+func (e *packExpander) flush(d *packDir) {
+	if !d.passed {
+		d.release()
+	}
+	members := make([]FileInfo, 0, len(d.members))
+	for _, member := range d.members {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Filename < members[j].Filename })
+	for _, member := range members {
+		e.emit(member)
+	}
+}
+
+//列表结束时输出所有缓存的文件
+func (e *packExpander) Flush() {
+	for n := len(e.dirs); n > 0; n = len(e.dirs) {
+		e.flush(e.dirs[n-1])
+		e.dirs = e.dirs[:n-1]
+	}
+}
+
+
+//Listobj时把展开后的文件放入FileList
+func (f FileWalk) sendPacked(member FileInfo) {
+	if f.IsInitialCopy || f.FileMap[member.Filename].CStatus.CopyStatus != "checkPass" {
+		f.FileList <- member
+	}
+}
+
+func getPacked(client *s3.Client, Bucket string, Prefix string, ref *PackRef, sse *SSEConfig) (io.ReadCloser, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(Bucket),
+		Key:    aws.String(pathJoin(Prefix, ref.Bundle)),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", ref.Offset, ref.Offset+ref.Size-1)),
+	}
+	if ref.Size == 0 { //空文件不需要读取
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	sse.applyGet(input)
+	output, err := client.GetObject(context.TODO(), input)
+	if err != nil {
+		return nil, err
+	}
+	return output.Body, nil
+}
+
+//用ranged GET从bundle中读取单个文件
+func DownloadPacked(client *s3.Client, fd *os.File, Bucket string, Prefix string, info FileInfo, sse *SSEConfig) error {
+	body, err := getPacked(client, Bucket, Prefix, info.FPack, sse)
+	if err != nil {
+		log.Println("Failed to download", info.Filename, err)
+		return err
+	}
+	defer body.Close()
+	if _, err := io.Copy(fd, body); err != nil {
+		log.Println("Failed to download", info.Filename, err)
+		return err
+	}
+	return nil
+}
+
+//打包的文件通过ranged GET计算md5，其他文件使用MD5Obj
+func MD5ObjInfo(client *s3.Client, Bucket string, Prefix string, info FileInfo, partSize int64, sse *SSEConfig) []byte {
+	if info.FPack == nil {
+		return MD5Obj(client, Bucket, pathJoin(Prefix, info.Filename), info.FVersion, partSize, sse)
+	}
+	m := md5.New()
+	body, err := getPacked(client, Bucket, Prefix, info.FPack, sse)
+	if err != nil {
+		log.Println("Failed to download", info.Filename, err)
+		return nil
+	}
+	defer body.Close()
+	io.Copy(m, body)
+	return m.Sum(nil)
+}
+
+func ParseSize(value string, name string) int64 {
+	if value == "" || value == "0" {
+		return 0
+	}
+	units := map[string]int64{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30}
+	unit := int64(1)
+	if u, ok := units[strings.ToUpper(value[len(value)-1:])]; ok {
+		unit = u
+		value = value[:len(value)-1]
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		log.Fatalln("Invalid size for '"+name+"':", value)
+	}
+	return n * unit
+}
@@ -0,0 +1,230 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//-rename-rules指定的规则文件，json数组，按顺序匹配，第一个匹配的规则生效，例如
+//[
+//  {"match": "^raw/(\\d{4})/(\\d{2})/(.*)$", "replace": "year=$1/month=$2/$3"},
+//  {"match": "^legacy/(.*)$", "replace": "$1"},
+//  {"match": "^logs/(.*)$", "replace": "logs/{mtime:2006-01-02}/$1"}
+//]
+//match为正则表达式，匹配源端的相对路径(目录不含结尾的/)，replace中可以使用$1等分组
+//以及文件属性: {mtime:<Go时间格式>}, {uid}, {gid}, {user}, {group}
+//目标端的key或路径都通过dstJoin生成，源端到目标端的对应关系保存在job文件旁边，增量拷贝和检查时使用
+type RenameRule struct {
+	Match   string `json:"match"`
+	Replace string `json:"replace"`
+
+	re *regexp.Regexp
+}
+
+var renameAttrTemplate = regexp.MustCompile(`\{(mtime:[^}]+|uid|gid|user|group)\}`)
+
+//源端相对路径到目标端相对路径的对应关系，只记录改名的条目
+//current为这次运行中改名得到的目标端名字，两个源文件改名为同一个目标时，后出现的跳过并记录在报告中
+type renameState struct {
+	mu      sync.Mutex
+	toDst   map[string]string
+	toSrc   map[string]string
+	current map[string]bool
+}
+
+func newRenameState() *renameState {
+	return &renameState{toDst: map[string]string{}, toSrc: map[string]string{}, current: map[string]bool{}}
+}
+
+var renames = newRenameState()
+
+func LoadRenameRules(rulesFile string) []RenameRule {
+	content, err := os.ReadFile(rulesFile)
+	if err != nil {
+		log.Fatalln("Failed to read rename rules file:", err)
+	}
+	var rules []RenameRule
+	err = json.Unmarshal(content, &rules)
+	if err != nil {
+		log.Fatalln("Invalid rename rules file:", rulesFile, err)
+	}
+	for i := range rules {
+		rules[i].re, err = regexp.Compile(rules[i].Match)
+		if err != nil {
+			log.Fatalln("Invalid regular expression in rename rules file:", rules[i].Match, err)
+		}
+	}
+	return rules
+}
+
+func expandRenameAttr(template string, info FileInfo) string {
+	return renameAttrTemplate.ReplaceAllStringFunc(template, func(m string) string {
+		name := m[1 : len(m)-1]
+		switch name {
+		case "uid":
+			return strconv.Itoa(info.FUID)
+		case "gid":
+			return strconv.Itoa(info.FGID)
+		case "user":
+			return info.FUser
+		case "group":
+			return info.FGroup
+		}
+		return time.Unix(info.FmTime, info.FmTimeNs).Format(strings.TrimPrefix(name, "mtime:"))
+	})
+}
+
+//返回目标端的相对路径，目录保留结尾的/, 先按规则改名，再做文件名编码的转换
+//返回空字符串时代表名字无法转换，这个条目需要跳过，见skipName
+func RenamePath(info FileInfo) string {
+	renamed := applyRenameRules(info)
+	if renamed == "" {
+		return ""
+	}
+	name := mapDstName(info.Filename, renamed)
+	if name != "" && name != info.Filename {
+		if other := renames.record(info.Filename, name); other != "" {
+			reportName("skipped", "renamed to the same name as "+other, info.Filename, name)
+			return ""
+		}
+	}
+	return name
+}
+
+func applyRenameRules(info FileInfo) string {
+	if dst, ok := manifestDstName(info.Filename); ok {
+		return dst //-files-from中指定了目标端的名字
+	}
+	isDir := strings.HasSuffix(info.Filename, "/")
+	name := strings.TrimSuffix(info.Filename, "/")
+	for _, rule := range renameRules {
+		if !rule.re.MatchString(name) {
+			continue
+		}
+		replaced := rule.re.ReplaceAllString(name, expandRenameAttr(rule.Replace, info))
+		if strings.HasPrefix(replaced, "/") || hasDotDot(replaced) || strings.Trim(replaced, "/") == "" {
+			reportName("skipped", "rename result is empty, absolute or contains ..", info.Filename, replaced)
+			return ""
+		}
+		name = strings.Trim(replaced, "/")
+		if isDir {
+			name = name + "/"
+		}
+		return name
+	}
+	return info.Filename
+}
+
+func hasDotDot(name string) bool {
+	for _, c := range strings.Split(name, "/") {
+		if c == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+//目标端无法表示的名字跳过，并记录在报告中
+func skipName(info FileInfo) bool {
+	return RenamePath(info) == ""
+}
+
+//目标端的key或路径
+func dstJoin(rootPath string, info FileInfo) string {
+	return pathJoin(rootPath, RenamePath(info))
+}
+
+//记录改名，dst已经是这次运行中另一个源文件的目标时不记录，返回那个源文件
+//上次运行保存的对应关系可以被覆盖，例如原来的源文件已经删除
+func (r *renameState) record(src string, dst string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if other, ok := r.toSrc[dst]; ok && other != src && r.current[dst] {
+		return other
+	}
+	if old, ok := r.toDst[src]; ok && old != dst {
+		delete(r.toSrc, old)
+	}
+	r.toDst[src] = dst
+	r.toSrc[dst] = src
+	r.current[dst] = true
+	return ""
+}
+
+//目标端名字对应的源端名字，用于增量检查时在job文件中查找源端的状态
+func srcNameOf(dst string) string {
+	renames.mu.Lock()
+	defer renames.mu.Unlock()
+	if src, ok := renames.toSrc[dst]; ok {
+		return src
+	}
+	return dst
+}
+
+//源端名字对应的目标端名字，用于增量检查时把上次的结果按目标端的名字排序
+func dstNameOf(src string) string {
+	renames.mu.Lock()
+	defer renames.mu.Unlock()
+	if dst, ok := renames.toDst[src]; ok {
+		return dst
+	}
+	return src
+}
+
+func renameFile(jobFile string) string {
+	return jobFile + ".rename"
+}
+
+func LoadRenameState(jobFile string) {
+	content, err := os.ReadFile(renameFile(jobFile))
+	if err != nil {
+		return
+	}
+	var toDst map[string]string
+	if err := json.Unmarshal(content, &toDst); err != nil {
+		log.Println(err)
+		return
+	}
+	encoded := toDst[jobFormatKey] == jobFormatPercent //和job文件一样，旧版本的名字没有编码
+	if encoded {
+		delete(toDst, jobFormatKey)
+	}
+	renames.mu.Lock()
+	defer renames.mu.Unlock()
+	for src, dst := range toDst {
+		if encoded {
+			src, dst = percentDecode(src), percentDecode(dst)
+		}
+		renames.toDst[src] = dst
+		renames.toSrc[dst] = src
+	}
+}
+
+func SaveRenameState(jobFile string) {
+	renames.mu.Lock()
+	defer renames.mu.Unlock()
+	if len(renames.toDst) == 0 {
+		return
+	}
+	toDst := map[string]string{jobFormatKey: jobFormatPercent}
+	for src, dst := range renames.toDst {
+		toDst[percentEncode(src)] = percentEncode(dst)
+	}
+	b, err := json.Marshal(toDst)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err := os.WriteFile(renameFile(jobFile), b, 0644); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+//服务端加密设置，源端和目标端各一份
+//目标端Mode为AES256或aws:kms时用于PutObject, 分段上传和CopyObject
+//CustomerKey为SSE-C的256位密钥，源端用于HeadObject, GetObject和CopySource，目标端用于写入
+//为nil时不设置任何加密参数，使用bucket的默认加密
+type SSEConfig struct {
+	Mode        string
+	KMSKeyID    string
+	Context     string //base64编码的json
+	BucketKey   bool
+	CustomerKey []byte
+}
+
+func LoadSSECKey(keyFile string) []byte {
+	return loadAESKey(keyFile, "SSE-C key")
+}
+
+//密钥文件可以是32字节的原始密钥，也可以是base64编码的密钥
+func loadAESKey(keyFile string, name string) []byte {
+	content, err := os.ReadFile(keyFile)
+	if err != nil {
+		log.Fatalln("Failed to read", name, "file:", err)
+	}
+	if len(content) == 32 {
+		return content
+	}
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(content)))
+	if err != nil || len(key) != 32 {
+		log.Fatalln(name, "file must contain a 256-bit key, raw or base64 encoded:", keyFile)
+	}
+	return key
+}
+
+//加密上下文在命令行中为json，例如{"project":"p1"}，请求中需要base64编码
+func EncodeSSEContext(context string) string {
+	if context == "" {
+		return ""
+	}
+	var m map[string]string
+	if err := json.Unmarshal([]byte(context), &m); err != nil {
+		log.Fatalln("Invalid SSE-KMS encryption context:", err)
+	}
+	return base64.StdEncoding.EncodeToString([]byte(context))
+}
+
+func (c *SSEConfig) customerKey() (*string, *string, *string) {
+	if c == nil || len(c.CustomerKey) == 0 {
+		return nil, nil, nil
+	}
+	sum := md5.Sum(c.CustomerKey)
+	return aws.String("AES256"), aws.String(base64.StdEncoding.EncodeToString(c.CustomerKey)), aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+//目标端写入时的加密参数，PutObject和CopyObject共用
+type sseHeaders struct {
+	customerAlgorithm, customerKey, customerKeyMD5 *string
+	mode                                           types.ServerSideEncryption
+	kmsKeyID, context                              *string
+	bucketKey                                      bool
+}
+
+func (c *SSEConfig) writeHeaders() sseHeaders {
+	var h sseHeaders
+	if c == nil {
+		return h
+	}
+	h.customerAlgorithm, h.customerKey, h.customerKeyMD5 = c.customerKey()
+	if c.Mode == "" || len(c.CustomerKey) > 0 {
+		return h
+	}
+	h.mode = types.ServerSideEncryption(c.Mode)
+	h.kmsKeyID = strOrNil(c.KMSKeyID)
+	h.context = strOrNil(c.Context)
+	h.bucketKey = c.BucketKey
+	return h
+}
+
+func (c *SSEConfig) applyPut(input *s3.PutObjectInput) {
+	h := c.writeHeaders()
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = h.customerAlgorithm, h.customerKey, h.customerKeyMD5
+	input.ServerSideEncryption, input.SSEKMSKeyId, input.SSEKMSEncryptionContext, input.BucketKeyEnabled = h.mode, h.kmsKeyID, h.context, h.bucketKey
+}
+
+//目标端的加密参数，o2o时可以用新的KMS key重新加密
+func (c *SSEConfig) applyCopy(input *s3.CopyObjectInput) {
+	h := c.writeHeaders()
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = h.customerAlgorithm, h.customerKey, h.customerKeyMD5
+	input.ServerSideEncryption, input.SSEKMSKeyId, input.SSEKMSEncryptionContext, input.BucketKeyEnabled = h.mode, h.kmsKeyID, h.context, h.bucketKey
+}
+
+//源端为SSE-C时，CopyObject需要提供源对象的密钥
+func (c *SSEConfig) applyCopySource(input *s3.CopyObjectInput) {
+	input.CopySourceSSECustomerAlgorithm, input.CopySourceSSECustomerKey, input.CopySourceSSECustomerKeyMD5 = c.customerKey()
+}
+
+//SSE-S3和SSE-KMS读取时不需要参数，只有SSE-C需要
+func (c *SSEConfig) applyHead(input *s3.HeadObjectInput) {
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = c.customerKey()
+}
+
+func (c *SSEConfig) applyGet(input *s3.GetObjectInput) {
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = c.customerKey()
+}
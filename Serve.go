@@ -0,0 +1,222 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+//serve: 常驻运行，按job文件中每个transfer的schedule运行
+//同一时间只运行一个transfer, 运行期间到期的其他transfer在结束后运行，这个transfer自己到期的运行被跳过并记录在历史中
+//每次运行在子进程中进行(见JobPool.go), 一次运行出错退出时只记录在历史中，serve继续运行
+//每次运行的开始、结束、数量和检查失败数保存在job文件旁边的.history中，status显示
+const maxRunHistory = 100
+
+type runRecord struct {
+	Transfer string `json:"transfer"`
+	Command  string `json:"command"`
+	Start    int64  `json:"start"`
+	End      int64  `json:"end"` //0代表正在运行，serve启动时把上次没有结束的记录标记为中止
+	Queued   int64  `json:"queued"`
+	Pass     int    `json:"pass"`
+	Fail     int    `json:"fail"`
+	Skipped  int    `json:"skipped"` //因为上一次还在运行而跳过的计划运行次数
+	Error    string `json:"error,omitempty"` //运行出错退出，或者serve在运行中退出
+}
+
+const abortedRun = "aborted, serve stopped during the run"
+
+//serve启动时，上一次serve运行中没有结束的记录标记为中止
+func markAbortedRuns(jobFile string) {
+	runs := readRunHistory(jobFile)
+	aborted := false
+	for i := range runs {
+		if runs[i].End == 0 && runs[i].Error == "" {
+			runs[i].Error = abortedRun
+			aborted = true
+		}
+	}
+	if aborted {
+		writeRunHistory(jobFile, runs)
+	}
+}
+
+func historyFile(jobFile string) string {
+	return jobFile + ".history"
+}
+
+func readRunHistory(jobFile string) []runRecord {
+	content, err := os.ReadFile(historyFile(jobFile))
+	if err != nil {
+		return nil
+	}
+	var runs []runRecord
+	if err := json.Unmarshal(content, &runs); err != nil {
+		log.Println("Invalid run history:", historyFile(jobFile), err)
+		return nil
+	}
+	return runs
+}
+
+//写入一次运行，上一条是这次运行开始时写入的记录时代替上一条
+func saveRun(jobFile string, run runRecord) {
+	runs := readRunHistory(jobFile)
+	if n := len(runs); n > 0 && runs[n-1].End == 0 && runs[n-1].Start == run.Start && runs[n-1].Transfer == run.Transfer {
+		runs[n-1] = run
+	} else {
+		runs = append(runs, run)
+	}
+	if len(runs) > maxRunHistory {
+		runs = runs[len(runs)-maxRunHistory:]
+	}
+	writeRunHistory(jobFile, runs)
+}
+
+func writeRunHistory(jobFile string, runs []runRecord) {
+	b, err := json.Marshal(runs)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	tmp := historyFile(jobFile) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		log.Println("Failed to write run history:", err)
+		return
+	}
+	if err := os.Rename(tmp, historyFile(jobFile)); err != nil {
+		log.Println("Failed to write run history:", err)
+	}
+}
+
+type scheduledTransfer struct {
+	Transfer
+	schedule *cronSchedule
+	next     time.Time
+	jobFile  string
+}
+
+func Serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.Usage = func() { commandUsage(fs, "serve") }
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	spec := LoadJobSpec(fs.Arg(0))
+
+	now := time.Now()
+	var transfers []Transfer
+	var scheduled []*scheduledTransfer
+	for _, t := range spec.Transfers {
+		if t.Schedule == "" {
+			log.Println("Transfer", t.Name, "has no schedule, skipped")
+			continue
+		}
+		schedule, err := parseCron(t.Schedule)
+		if err != nil {
+			log.Fatalln("Invalid schedule of transfer", t.Name+":", err)
+		}
+		next := schedule.Next(now)
+		if next.IsZero() {
+			log.Fatalln("Schedule of transfer", t.Name, "never runs:", t.Schedule)
+		}
+		transfers = append(transfers, t)
+		scheduled = append(scheduled, &scheduledTransfer{t, schedule, next, ""})
+	}
+	if len(scheduled) == 0 {
+		log.Fatalln("No scheduled transfers in job file:", fs.Arg(0))
+	}
+	spec.validate(transfers)
+	for _, st := range scheduled {
+		st.jobFile = spec.jobFile(st.Transfer)
+		markAbortedRuns(st.jobFile)
+	}
+	//运行的拷贝和检查worker使用factor*CPU数个名额
+	pool := newWorkerPool(factor * runtime.NumCPU())
+
+	//第一次信号等待当前的运行结束后退出，第二次立即退出
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Println("Received", sig, "stopping after the current run")
+		close(stop)
+		<-signals
+		os.Exit(1)
+	}()
+
+	for {
+		st := scheduled[0] //下一个到期的transfer, 时间相同时按job文件中的顺序
+		for _, s := range scheduled[1:] {
+			if s.next.Before(st.next) {
+				st = s
+			}
+		}
+		if wait := time.Until(st.next); wait > 0 {
+			log.Println("Next run:", st.Name, "at", st.next.Format("2006-01-02 15:04:05"))
+			timer := time.NewTimer(wait)
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+
+		run := runRecord{Transfer: st.Name, Command: st.Command, Start: time.Now().Unix()}
+		saveRun(st.jobFile, run)
+		report := spec.runChild(st.Transfer, pool, true)
+		result := report.Result
+		os.RemoveAll(dataDir)
+
+		//运行期间错过的计划时间不再补运行
+		end := time.Now()
+		next := st.schedule.Next(st.next)
+		for !next.IsZero() && !next.After(end) {
+			run.Skipped++
+			next = st.schedule.Next(next)
+		}
+		st.next = next
+		if run.Skipped > 0 {
+			log.Printf("Transfer %s: skipped %d scheduled runs while the previous run was still running\n", st.Name, run.Skipped)
+		}
+		if st.schedule.every > 0 {
+			st.next = end.Add(st.schedule.every) //@every从上一次结束开始计算
+		}
+		run.End, run.Queued, run.Pass, run.Fail = end.Unix(), result.Listed, result.Success, result.Fail
+		if report.Err != "" {
+			run.Error = "failed: " + report.Err
+		}
+		saveRun(st.jobFile, run)
+
+		if st.next.IsZero() {
+			log.Println("Schedule of transfer", st.Name, "has no more runs")
+			remaining := scheduled[:0]
+			for _, s := range scheduled {
+				if s != st {
+					remaining = append(remaining, s)
+				}
+			}
+			scheduled = remaining
+			if len(scheduled) == 0 {
+				return
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
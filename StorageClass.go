@@ -0,0 +1,179 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//-sc-rules指定的规则文件，json数组，按顺序匹配，第一个匹配的规则生效，都不匹配时使用-sc
+//[
+//  {"maxSize": "128K", "storageClass": "STANDARD"},
+//  {"pattern": "logs/*", "minAge": "90d", "storageClass": "DEEP_ARCHIVE"},
+//  {"user": "backup", "storageClass": "GLACIER_IR"}
+//]
+//规则中没有指定的条件不参与匹配，大小支持K/M/G后缀，时间为mtime距今的时间，支持d(天)和Go的duration格式
+type StorageClassRule struct {
+	Pattern      string `json:"pattern"`
+	MinSize      string `json:"minSize"` //大小>=minSize
+	MaxSize      string `json:"maxSize"` //大小<maxSize
+	MinAge       string `json:"minAge"`
+	MaxAge       string `json:"maxAge"`
+	UID          *int   `json:"uid"`
+	GID          *int   `json:"gid"`
+	User         string `json:"user"`
+	Group        string `json:"group"`
+	StorageClass string `json:"storageClass"`
+
+	minSize, maxSize int64
+	minAge, maxAge   time.Duration
+}
+
+var validStorageClasses = []string{"STANDARD", "REDUCED_REDUNDANCY", "STANDARD_IA", "ONEZONE_IA", "INTELLIGENT_TIERING", "GLACIER", "DEEP_ARCHIVE", "GLACIER_IR"}
+
+func validStorageClass(sc string) bool {
+	for _, v := range validStorageClasses {
+		if sc == v {
+			return true
+		}
+	}
+	return false
+}
+
+func parseAge(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(value, "d"), 64)
+		if err == nil && days >= 0 {
+			return time.Duration(days * float64(24*time.Hour))
+		}
+	}
+	age, err := time.ParseDuration(value)
+	if err != nil || age < 0 {
+		log.Fatalln("Invalid age in storage class rules file:", value)
+	}
+	return age
+}
+
+func LoadStorageClassRules(rulesFile string) []StorageClassRule {
+	content, err := os.ReadFile(rulesFile)
+	if err != nil {
+		log.Fatalln("Failed to read storage class rules file:", err)
+	}
+	var rules []StorageClassRule
+	err = json.Unmarshal(content, &rules)
+	if err != nil {
+		log.Fatalln("Invalid storage class rules file:", rulesFile, err)
+	}
+	for i := range rules {
+		rule := &rules[i]
+		if !validStorageClass(rule.StorageClass) {
+			log.Fatalln("Invalid storage class in storage class rules file:", rule.StorageClass)
+		}
+		if rule.Pattern != "" && !validPattern(rule.Pattern) {
+			log.Fatalln("Invalid pattern in storage class rules file:", rule.Pattern)
+		}
+		rule.minSize = ParseSize(rule.MinSize, "minSize in storage class rules file")
+		rule.maxSize = ParseSize(rule.MaxSize, "maxSize in storage class rules file")
+		rule.minAge = parseAge(rule.MinAge)
+		rule.maxAge = parseAge(rule.MaxAge)
+	}
+	return rules
+}
+
+func (rule *StorageClassRule) match(info FileInfo, now time.Time) bool {
+	if rule.Pattern != "" && !matchPattern(rule.Pattern, info.Filename) {
+		return false
+	}
+	if rule.MinSize != "" && info.FSize < rule.minSize {
+		return false
+	}
+	if rule.MaxSize != "" && info.FSize >= rule.maxSize {
+		return false
+	}
+	age := now.Sub(time.Unix(info.FmTime, info.FmTimeNs))
+	if rule.MinAge != "" && age < rule.minAge {
+		return false
+	}
+	if rule.MaxAge != "" && age >= rule.maxAge {
+		return false
+	}
+	if rule.UID != nil && info.FUID != *rule.UID {
+		return false
+	}
+	if rule.GID != nil && info.FGID != *rule.GID {
+		return false
+	}
+	if rule.User != "" && info.FUser != rule.User {
+		return false
+	}
+	if rule.Group != "" && info.FGroup != rule.Group {
+		return false
+	}
+	return true
+}
+
+//按-sc-rules选择存储类型，没有匹配的规则时使用-sc
+func SelectStorageClass(info FileInfo) string {
+	now := time.Now()
+	for i := range storageClassRules {
+		if storageClassRules[i].match(info, now) {
+			return storageClassRules[i].StorageClass
+		}
+	}
+	return storageClass
+}
+
+//每种存储类型的对象数和字节数，用于Job Completion Summary
+type classStat struct {
+	Count int64
+	Bytes int64
+}
+
+var (
+	classStatsMu sync.Mutex
+	classStats   = map[string]*classStat{}
+)
+
+func recordStorageClass(sc string, size int64) {
+	if sc == "" {
+		sc = "STANDARD"
+	}
+	classStatsMu.Lock()
+	defer classStatsMu.Unlock()
+	stat, ok := classStats[sc]
+	if !ok {
+		stat = &classStat{}
+		classStats[sc] = stat
+	}
+	stat.Count++
+	stat.Bytes += size
+}
+
+func printStorageClassSummary() {
+	classStatsMu.Lock()
+	defer classStatsMu.Unlock()
+	if len(classStats) == 0 {
+		return
+	}
+	var classes []string
+	for sc := range classStats {
+		classes = append(classes, sc)
+	}
+	sort.Strings(classes)
+	fmt.Println("Objects by storage class:")
+	for _, sc := range classes {
+		fmt.Printf("  %-20s objects: %-10d bytes: %d\n", sc, classStats[sc].Count, classStats[sc].Bytes)
+	}
+}
@@ -11,11 +11,22 @@ type FileInfo struct {
 	FUserAgent string
 	FUID       int
 	FGID       int
+	FUser      string //源端的用户名，用于-idmap按名字映射
+	FGroup     string //源端的组名
 	FType      string
 	FPerm      string
 	FaTime     int64
 	FmTime     int64
+	FaTimeNs   int64 //atime中不足一秒的纳秒部分
+	FmTimeNs   int64 //mtime中不足一秒的纳秒部分
+	FNsec      bool  //时间是否精确到纳秒，s3的LastModified和旧版本的metadata只有秒
 	FSize      int64
+	FXattr     map[string][]byte //扩展属性及POSIX ACL
+	FUserMeta  map[string]string //admt属性以外的x-amz-meta-*
+	FPack      *PackRef          //不为nil时文件打包在tar bundle中
+	FTags      map[string]string //s3对象的tag, 只在-check-tags时读取
+	FVersion   string            //源对象的版本，只在-files-from或-inventory指定version时使用
+	FETag      string            //-inventory中源对象的ETag, md5检查时用于省略下载源对象
 	CStatus CopyInfo
 }
 
@@ -23,9 +34,8 @@ type FileWalk struct {
 	FileList chan FileInfo
 	IsInitialCopy bool
 	FileMap map[string]FileInfo
-	SrcCheckMap map[string]FileInfo
-	DstCheckMap map[string]FileInfo
-	ResultMap map[string]FileInfo
+	SrcCheck *checkSorter //检查时源端的列表，按目标端的名字排序
+	DstCheck *checkSorter
 	SrcPath    string  	//这里留这个的目的是当F2F,F2O时，Filewarlker函数中无法传递SrcPath的值，需要通过结构体来传参，Listojb因为是自定义的，不需要从这里传参数
 	DstPath    string 
 	DefaultMod Filemod
@@ -0,0 +1,205 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+//对象tag: -tag k=v可以指定多次，value可以使用路径模板，按文件的相对路径展开
+//{1} {2} ...: 第n级目录名, {name}: 文件名, {ext}: 扩展名(不含.), {dir}: 所在目录
+//例如 -tag project={1} -tag type={ext}，展开为空的tag不设置
+const maxObjectTags = 10
+
+type TagRule struct {
+	Key   string
+	Value string
+}
+
+//实现flag.Value, 用于可以重复的-tag参数
+type tagFlags []TagRule
+
+var tagTemplate = regexp.MustCompile(`\{([0-9]+|name|ext|dir)\}`)
+
+func (t *tagFlags) String() string {
+	var list []string
+	for _, rule := range *t {
+		list = append(list, rule.Key+"="+rule.Value)
+	}
+	return strings.Join(list, ",")
+}
+
+func (t *tagFlags) Set(value string) error {
+	index := strings.Index(value, "=")
+	if index <= 0 {
+		return fmt.Errorf("invalid tag %q, please use 'key=value'", value)
+	}
+	rule := TagRule{Key: value[:index], Value: value[index+1:]}
+	if utf8.RuneCountInString(rule.Key) > 128 {
+		return fmt.Errorf("tag key %q is longer than 128 characters", rule.Key)
+	}
+	if sanitizeTag(rule.Key) != rule.Key {
+		return fmt.Errorf("tag key %q may only contain letters, numbers, spaces and + - = . _ : / @", rule.Key)
+	}
+	*t = append(*t, rule)
+	if len(*t) > maxObjectTags {
+		return fmt.Errorf("S3 allows at most %d tags per object", maxObjectTags)
+	}
+	return nil
+}
+
+//s3的tag只允许字母、数字、空格和+ - = . _ : / @，展开后的value中其他字符替换为_
+func sanitizeTag(value string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) || r == ' ' || strings.ContainsRune("+-=._:/@", r) {
+			return r
+		}
+		return '_'
+	}, value)
+}
+
+func expandTagTemplate(template string, filename string) string {
+	filename = strings.TrimSuffix(filename, "/")
+	dirs := strings.Split(path.Dir(filename), "/")
+	if path.Dir(filename) == "." {
+		dirs = nil
+	}
+	return tagTemplate.ReplaceAllStringFunc(template, func(m string) string {
+		switch m {
+		case "{name}":
+			return path.Base(filename)
+		case "{ext}":
+			return strings.TrimPrefix(path.Ext(filename), ".")
+		case "{dir}":
+			return strings.Join(dirs, "/")
+		}
+		n, _ := strconv.Atoi(m[1 : len(m)-1])
+		if n < 1 || n > len(dirs) {
+			return ""
+		}
+		return dirs[n-1]
+	})
+}
+
+//按-tag计算文件的tag, base为源对象的tag(o2o COPY时), -tag中的同名tag覆盖base
+func ObjectTags(filename string, base map[string]string) map[string]string {
+	tags := map[string]string{}
+	for k, v := range base {
+		tags[k] = v
+	}
+	for _, rule := range tagRules {
+		value := expandTagTemplate(rule.Value, filename)
+		if value == "" {
+			delete(tags, rule.Key)
+			continue
+		}
+		value = sanitizeTag(value)
+		if runes := []rune(value); len(runes) > 256 {
+			value = string(runes[:256])
+		}
+		tags[rule.Key] = value
+	}
+	return tags
+}
+
+func EncodeTags(tags map[string]string) string {
+	values := url.Values{}
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+	return values.Encode()
+}
+
+func applyPutTags(input *s3.PutObjectInput, filename string) {
+	if len(tagRules) == 0 {
+		return
+	}
+	input.Tagging = strOrNil(EncodeTags(ObjectTags(filename, nil)))
+}
+
+//o2o的TaggingDirective: COPY时保留源对象的tag, 指定-tag时读取源对象的tag合并后REPLACE; REPLACE时只使用-tag
+func applyCopyTags(client *s3.Client, input *s3.CopyObjectInput, srcBucket string, fsrcPath string, version string, filename string) error {
+	if tagDirective == "COPY" && len(tagRules) == 0 {
+		return nil //CopyObject默认就是COPY
+	}
+	var base map[string]string
+	if tagDirective == "COPY" {
+		var err error
+		base, err = GetObjTags(client, srcBucket, fsrcPath, version)
+		if err != nil {
+			return err
+		}
+	}
+	tags := ObjectTags(filename, base)
+	if len(tags) > maxObjectTags {
+		return fmt.Errorf("source tags merged with -tag give %d tags, S3 allows at most %d", len(tags), maxObjectTags)
+	}
+	input.TaggingDirective = types.TaggingDirectiveReplace
+	input.Tagging = aws.String(EncodeTags(tags))
+	return nil
+}
+
+func GetObjTags(client *s3.Client, Bucket string, Key string, version string) (map[string]string, error) {
+	output, err := client.GetObjectTagging(context.TODO(), &s3.GetObjectTaggingInput{
+		Bucket:    aws.String(Bucket),
+		Key:       aws.String(Key),
+		VersionId: strOrNil(version),
+	})
+	if err != nil {
+		return nil, err
+	}
+	tags := map[string]string{}
+	for _, tag := range output.TagSet {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return tags, nil
+}
+
+//-check-tags时，列出s3对象的同时读取tag
+func withObjTags(client *s3.Client, Bucket string, Key string, info FileInfo) FileInfo {
+	if !checkTags || info.FPack != nil || !(mode == "f2o" || mode == "o2o") {
+		return info
+	}
+	tags, err := GetObjTags(client, Bucket, Key, info.FVersion)
+	if err != nil {
+		log.Println("Failed to get tags:", Key, err)
+		return info
+	}
+	info.FTags = tags
+	return info
+}
+
+//只有目标端为s3时比较tag，打包在bundle中的文件没有单独的tag
+func tagsEqual(src FileInfo, dst FileInfo) bool {
+	if !checkTags || dst.FPack != nil || !(mode == "f2o" || mode == "o2o") {
+		return true
+	}
+	var base map[string]string
+	if mode == "o2o" && tagDirective == "COPY" {
+		base = src.FTags
+	}
+	expected := ObjectTags(src.Filename, base)
+	if len(expected) != len(dst.FTags) {
+		return false
+	}
+	for k, v := range expected {
+		if dst.FTags[k] != v {
+			return false
+		}
+	}
+	return true
+}
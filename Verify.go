@@ -0,0 +1,686 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+//校验: 源端和目标端的列表分别按目标端的名字排序，再归并比较，不再把两端全部放入map
+//并行列出和并行遍历的输出是乱序的，先写入checkSorter: 每满-check-batch条排序后写入临时文件(run)，最后多路归并成有序的流
+//源端的key为RenamePath后的名字，目标端的key为列出的名字，两端按字节序对齐后逐个输出结果，属性比较和md5在归并的同时由worker完成
+//增量检查时上次的job文件也排序后作为第三个有序的流，上次检查通过的条目直接保留，不再读取属性
+//内存中最多保存每端一个batch和每个run的一条记录
+type checkEntry struct {
+	Key   string
+	Info  FileInfo
+	Fetch *checkFetch //不为nil时Info中只有名字，属性在归并后读取
+	Prev  *FileInfo   //增量检查时上次的结果，归并时设置
+	Kept  bool        //checkReport.Keep保留的上次结果，名字相同时排在这次的结果之后
+}
+
+//增量检查时列表只记录对象的key或文件的路径，上次检查通过的条目不需要HeadObject或Lstat
+type checkFetch struct {
+	Key          string //s3对象的key或文件的完整路径
+	LastModified int64
+	Size         int64
+	Version      string //-inventory中的版本和ETag
+	ETag         string
+}
+
+func entryLess(a checkEntry, b checkEntry) bool {
+	if a.Key != b.Key {
+		return a.Key < b.Key
+	}
+	if a.Info.Filename != b.Info.Filename {
+		return a.Info.Filename < b.Info.Filename
+	}
+	return !a.Kept && b.Kept
+}
+
+type checkSorter struct {
+	mu    sync.Mutex
+	batch []checkEntry
+	runs  []string
+}
+
+func newCheckSorter() *checkSorter {
+	return &checkSorter{}
+}
+
+//可以被列出或遍历的goroutine并发调用
+func (s *checkSorter) Add(entry checkEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batch = append(s.batch, entry)
+	if len(s.batch) >= checkBatch {
+		s.spill()
+	}
+}
+
+//当前batch排序后写入一个run
+func (s *checkSorter) spill() {
+	sort.Slice(s.batch, func(i, j int) bool { return entryLess(s.batch[i], s.batch[j]) })
+	fd := CreateTempFile(dataDir, "check-run-")
+	w := bufio.NewWriter(fd)
+	enc := gob.NewEncoder(w)
+	for _, entry := range s.batch {
+		if err := enc.Encode(entry); err != nil {
+			log.Fatalln("Failed to write check run:", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatalln("Failed to write check run:", err)
+	}
+	fd.Close()
+	s.runs = append(s.runs, fd.Name())
+	s.batch = s.batch[:0]
+}
+
+//所有Add完成后调用，按key顺序输出，输出完成后删除临时文件
+func (s *checkSorter) Sorted() <-chan checkEntry {
+	out := make(chan checkEntry, 1024)
+	go func() {
+		defer close(out)
+		if len(s.runs) == 0 { //没有超过一个batch时不写临时文件
+			sort.Slice(s.batch, func(i, j int) bool { return entryLess(s.batch[i], s.batch[j]) })
+			for _, entry := range s.batch {
+				out <- entry
+			}
+			s.batch = nil
+			return
+		}
+		if len(s.batch) > 0 {
+			s.spill()
+		}
+		s.batch = nil
+		mergeRuns(s.runs, out)
+		for _, run := range s.runs {
+			os.Remove(run)
+		}
+	}()
+	return out
+}
+
+type runReader struct {
+	fd   *os.File
+	dec  *gob.Decoder
+	head checkEntry
+}
+
+func (r *runReader) next() bool {
+	r.head = checkEntry{} //gob解码到已有的值时会合并map，每次用新的值
+	err := r.dec.Decode(&r.head)
+	if err == io.EOF {
+		return false
+	}
+	if err != nil {
+		log.Fatalln("Failed to read check run:", r.fd.Name(), err)
+	}
+	return true
+}
+
+type runHeap []*runReader
+
+func (h runHeap) Len() int            { return len(h) }
+func (h runHeap) Less(i, j int) bool  { return entryLess(h[i].head, h[j].head) }
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+//多路归并有序的run
+func mergeRuns(runs []string, out chan<- checkEntry) {
+	h := &runHeap{}
+	for _, run := range runs {
+		fd, err := os.Open(run)
+		if err != nil {
+			log.Fatalln("Failed to read check run:", err)
+		}
+		defer fd.Close()
+		r := &runReader{fd: fd, dec: gob.NewDecoder(bufio.NewReaderSize(fd, 64*1024))}
+		if r.next() {
+			*h = append(*h, r)
+		}
+	}
+	heap.Init(h)
+	for h.Len() > 0 {
+		r := (*h)[0]
+		out <- r.head
+		if r.next() {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+}
+
+//按key归并两端的有序流，每个源端的条目调用一次fn, 目标端没有对应的文件或对象时dst为nil
+//extra不为nil时，源端没有对应的目标文件或对象调用extra
+func mergeJoin(src <-chan checkEntry, dst <-chan checkEntry, fn func(src checkEntry, dst *checkEntry), extra func(dst checkEntry)) {
+	d, dok := <-dst
+	matched := false
+	advance := func() {
+		if !matched && extra != nil {
+			extra(d)
+		}
+		d, dok = <-dst
+		matched = false
+	}
+	var last *checkEntry
+	for s := range src {
+		if last != nil && last.Key == s.Key && last.Info.Filename == s.Info.Filename {
+			continue //清单中重复的条目
+		}
+		entry := s
+		last = &entry
+		for dok && d.Key < s.Key {
+			advance()
+		}
+		if dok && d.Key == s.Key {
+			matched = true
+			dstEntry := d
+			fn(s, &dstEntry)
+		} else {
+			fn(s, nil)
+		}
+	}
+	for dok { //读完目标端，删除临时文件
+		advance()
+	}
+}
+
+//增量检查: 按key归并源端和上次的结果，上次检查通过的源端条目不再输出，它的结果交给keep原样保留
+//其他条目带上上次的结果(Prev)输出，没有对应源端条目的上次结果也交给keep
+func skipPassed(src <-chan checkEntry, prev <-chan checkEntry, keep func(info FileInfo)) <-chan checkEntry {
+	out := make(chan checkEntry, 1024)
+	go func() {
+		defer close(out)
+		p, pok := <-prev
+		var last *checkEntry
+		for s := range src {
+			if last != nil && last.Key == s.Key && last.Info.Filename == s.Info.Filename {
+				continue
+			}
+			entry := s
+			last = &entry
+			for pok && entryLess(p, s) {
+				keep(p.Info)
+				p, pok = <-prev
+			}
+			if pok && p.Key == s.Key && p.Info.Filename == s.Info.Filename {
+				info := p.Info
+				p, pok = <-prev
+				if info.CStatus.CopyStatus == "checkPass" {
+					keep(info)
+					continue
+				}
+				s.Prev = &info
+			}
+			out <- s
+		}
+		for pok {
+			keep(p.Info)
+			p, pok = <-prev
+		}
+	}()
+	return out
+}
+
+func (f FileWalk) addSrcCheck(info FileInfo) {
+	f.addSrcEntry(info, nil)
+}
+
+func (f FileWalk) addDstCheck(info FileInfo) {
+	f.addDstEntry(info, nil)
+}
+
+//fetch不为nil时，info中只有名字，见checkFetch
+func (f FileWalk) addSrcEntry(info FileInfo, fetch *checkFetch) {
+	if !selected(info.Filename) {
+		return //-include, -exclude
+	}
+	f.SrcCheck.Add(checkEntry{Key: RenamePath(info), Info: info, Fetch: fetch}) //指定-rename-rules时目标端的名字不同
+}
+
+func (f FileWalk) addDstEntry(info FileInfo, fetch *checkFetch) {
+	if hasFilter() && !selected(srcNameOf(info.Filename)) {
+		return //目标端按对应的源端名字过滤
+	}
+	f.DstCheck.Add(checkEntry{Key: info.Filename, Info: info, Fetch: fetch})
+}
+
+//源端的列表是否可以只记录名字: 改名规则中使用了文件属性时，排序前就需要读取属性
+func lazySrcCheck() bool {
+	for _, rule := range renameRules {
+		if renameAttrTemplate.MatchString(rule.Replace) {
+			return false
+		}
+	}
+	return true
+}
+
+//读取只记录了名字的条目的属性，对象或文件已经不存在时CopyStatus为notFound
+func (f FileWalk) fetchEntry(client *s3.Client, entry checkEntry, isSrc bool) FileInfo {
+	if entry.Fetch == nil {
+		return entry.Info
+	}
+	fetch := entry.Fetch
+	if isSrc && (mode == "f2o" || mode == "f2f") || !isSrc && strings.HasSuffix(mode, "2f") {
+		root := f.SrcPath
+		if !isSrc {
+			root = f.DstPath
+		}
+		if f.withAttr {
+			return GetFileMetadata(root, fetch.Key)
+		}
+		return GetFileMetadataWithoutAttr(root, fetch.Key)
+	}
+
+	Bucket, Prefix, sse := dstBucket, dstPrefix, dstSSE
+	if isSrc {
+		Bucket, Prefix, sse = srcBucket, srcPrefix, srcSSE
+	}
+	if isSrc && srcInventory != "" {
+		record := InventoryRecord{Key: fetch.Key, VersionID: fetch.Version, Size: fetch.Size, LastModified: fetch.LastModified, ETag: fetch.ETag}
+		return withObjTags(client, Bucket, fetch.Key, inventoryObjInfo(client, record, f.withAttr))
+	}
+	job := headJob{Key: fetch.Key, Filename: entry.Info.Filename, LastModified: fetch.LastModified, Size: fetch.Size}
+	if isSrc && entry.Prev != nil {
+		if objInfo, ok := f.unchangedObjInfo(job, *entry.Prev, true); ok {
+			return withObjTags(client, Bucket, job.Key, objInfo)
+		}
+	}
+	objInfo := f.objInfo(client, Bucket, Prefix, job, sse)
+	if objInfo.CStatus.CopyStatus == "notFound" {
+		return objInfo
+	}
+	return withObjTags(client, Bucket, job.Key, objInfo)
+}
+
+//不带属性时s3的列表中是保存的大小，压缩或客户端加密的对象和文件的大小不同
+//大小不同时用HeadObject读取对象的metadata中的原始大小，每个对象按自己的metadata决定，和-compress无关
+func (f FileWalk) plainSizes(client *s3.Client, info *FileInfo, dst *FileInfo) {
+	if f.withAttr || cseKey != nil || dst == nil || info.FType != "0100" || info.FSize == dst.FSize {
+		return //带属性或-cse-key时已经HeadObject
+	}
+	if (mode == "o2f" || mode == "o2o") && info.FPack == nil {
+		info.FSize = objPlainSize(client, srcBucket, pathJoin(srcPrefix, info.Filename), info.FVersion, srcSSE, info.FSize)
+	}
+	if (mode == "f2o" || mode == "o2o") && dst.FPack == nil {
+		dst.FSize = objPlainSize(client, dstBucket, pathJoin(dstPrefix, dst.Filename), "", dstSSE, dst.FSize)
+	}
+}
+
+//对象的原始大小，读取失败时返回列表中的大小
+func objPlainSize(client *s3.Client, Bucket string, key string, version string, sse *SSEConfig, listed int64) int64 {
+	headInput := &s3.HeadObjectInput{
+		Bucket:    aws.String(Bucket),
+		Key:       aws.String(key),
+		VersionId: strOrNil(version),
+	}
+	sse.applyHead(headInput)
+	output, err := client.HeadObject(context.TODO(), headInput)
+	if err != nil {
+		log.Println(key, ":", err)
+		return listed
+	}
+	return plainSize(output)
+}
+
+//比较属性并输出结果
+func attrCheck(info FileInfo, dst *FileInfo) string {
+	status := compareAttr(info, dst)
+	if status == "checkPass" {
+		fmt.Printf("%-23s%s\n", "Attributes check pass: ", info.Filename)
+	}
+	if status == "checkFail" {
+		fmt.Printf("%-23s%s\n", "Attributes check fail: ", info.Filename)
+	}
+	return status
+}
+
+//比较一个源文件和对应的目标文件的属性，返回checkPass或checkFail, 不是文件、目录或symlink时返回空
+func compareAttr(info FileInfo, dst *FileInfo) string {
+	//在dstPath中没有对应的文件或对象
+	if dst == nil {
+		return "checkFail"
+	}
+
+	//找到地应的目标文件或对象
+	//如果是目录或symlink，则直接返回checkPass
+	if info.FType == "0040" || info.FType == "0120" {
+		return "checkPass"
+	}
+
+	//源端和目标端都带属性时，比较xattr和ACL
+	if info.IsMetaExist && dst.IsMetaExist && !xattrEqual(info.FXattr, dst.FXattr) {
+		return "checkFail"
+	}
+
+	//指定-idmap时比较属主和属组
+	if idMap != nil && info.IsMetaExist && dst.IsMetaExist && !ownerEqual(info, *dst) {
+		return "checkFail"
+	}
+
+	//指定-check-tags时比较目标对象的tag
+	if !tagsEqual(info, *dst) {
+		return "checkFail"
+	}
+
+	//如果为文件，则比较大小，和目标对文件或对象的更新时间大于源文件或对象，为什么会出现大于源文件情况，是因为s3上传中生成的文件更新
+	if info.FType == "0100" {
+		if dst.FSize == info.FSize && mtimeNotOlder(*dst, info) {
+			return "checkPass"
+		}
+		return "checkFail"
+	}
+	return ""
+}
+
+//比较源文件和目标文件的md5
+func md5Check(client *s3.Client, info FileInfo, dst FileInfo) string {
+	var srcMD5 []byte
+	var dstMD5 []byte
+	if mode == "f2o" {
+		srcMD5 = MD5File(srcPath + info.Filename)
+		dstMD5 = MD5ObjInfo(client, dstBucket, dstPrefix, dst, partSize, dstSSE) //bundle中的文件按dst的FPack读取
+	}
+	if mode == "o2f" {
+		dstMD5 = MD5File(pathJoin(dstPath, dst.Filename))
+		srcMD5 = etagMD5(info) //inventory中的ETag相同时不用下载源对象
+		if !bytes.Equal(srcMD5, dstMD5) {
+			srcMD5 = MD5ObjInfo(client, srcBucket, srcPrefix, info, partSize, srcSSE)
+		}
+	}
+	if mode == "f2f" {
+		srcMD5 = MD5File(srcPath + info.Filename)
+		dstMD5 = MD5File(pathJoin(dstPath, dst.Filename))
+	}
+	if mode == "o2o" {
+		dstMD5 = MD5Obj(client, dstBucket, pathJoin(dstPrefix, dst.Filename), "", partSize, dstSSE)
+		srcMD5 = etagMD5(info)
+		if !bytes.Equal(srcMD5, dstMD5) {
+			srcMD5 = MD5ObjInfo(client, srcBucket, srcPrefix, info, partSize, srcSSE)
+		}
+	}
+
+	if bytes.Equal(srcMD5, dstMD5) {
+		fmt.Printf("%-23s%s\n", "MD5 check pass: ", info.Filename)
+		return "checkPass"
+	}
+	fmt.Printf("%-23s%s\n", "MD5 check fail: ", info.Filename)
+	return "checkFail"
+}
+
+type checkPair struct {
+	src checkEntry
+	dst *checkEntry
+}
+
+//归并两端的列表，由procs个worker读取属性、比较并输出结果
+//prev不为nil时为增量检查，prev为上次的结果，按目标端的名字排序
+func (f FileWalk) Verify(report *checkReport, procs int, prev <-chan checkEntry) {
+	pairs := make(chan checkPair, procs)
+	var wg sync.WaitGroup
+	wg.Add(procs)
+	for i := 0; i < procs; i++ {
+		go func() {
+			defer wg.Done()
+			client := CreateS3Client(region)
+			for pair := range pairs {
+				sharedWorkers.Acquire()
+				f.checkPair(client, report, pair)
+				sharedWorkers.Release()
+			}
+		}()
+	}
+
+	src := f.SrcCheck.Sorted()
+	if prev != nil {
+		src = skipPassed(src, prev, report.Keep)
+	}
+	mergeJoin(src, f.DstCheck.Sorted(), func(src checkEntry, dst *checkEntry) {
+		pairs <- checkPair{src: src, dst: dst}
+	}, nil)
+	close(pairs)
+	wg.Wait()
+}
+
+func (f FileWalk) checkPair(client *s3.Client, report *checkReport, pair checkPair) {
+	info := f.fetchEntry(client, pair.src, true)
+	if info.CStatus.CopyStatus == "notFound" {
+		if pair.src.Prev != nil {
+			report.Keep(*pair.src.Prev) //列出后被删除
+		}
+		return
+	}
+	var dst *FileInfo
+	if pair.dst != nil {
+		dstInfo := f.fetchEntry(client, *pair.dst, false)
+		if dstInfo.CStatus.CopyStatus != "notFound" {
+			dst = &dstInfo
+		}
+	}
+	f.plainSizes(client, &info, dst)
+	status := attrCheck(info, dst)
+	if check == "md5" && dst != nil && info.FType == "0100" {
+		status = md5Check(client, info, *dst) //md5的结果代替属性检查的结果
+	}
+	if status != "" {
+		report.Add(info, status)
+	}
+}
+
+//检查结果边检查边写入jobFile的临时文件，完成后替换jobFile
+//incr模式的结果和保留的上次结果先写入按名字排序的run, 完成后按顺序写入，不再把上次的结果全部读入FileMap
+//检查失败的名字写入临时文件，最后统一输出
+type checkReport struct {
+	mu      sync.Mutex
+	success int
+	fail    int
+	failed  *os.File
+	jobFile string
+	job     *os.File
+	jobW    *bufio.Writer
+	state   *checkSorter
+}
+
+func newCheckReport(jobFile string, incr bool) *checkReport {
+	r := &checkReport{jobFile: jobFile, failed: CreateTempFile(dataDir, "check-failed-")}
+	if incr {
+		r.state = newCheckSorter()
+	}
+	job, err := os.Create(jobFile + ".tmp")
+	if err != nil {
+		log.Fatalln("Failed to write job file:", err)
+	}
+	r.job = job
+	r.jobW = bufio.NewWriter(job)
+	beginJobState(r.jobW)
+	return r
+}
+
+func (r *checkReport) Add(info FileInfo, status string) {
+	result := FileInfo{IsMetaExist: info.IsMetaExist, Filename: info.Filename, FUserAgent: info.FUserAgent, FUID: info.FUID, FGID: info.FGID, FType: info.FType, FPerm: info.FPerm, FaTime: info.FaTime, FmTime: info.FmTime, FSize: info.FSize, FUserMeta: info.FUserMeta, CStatus: CopyInfo{CopyStatus: status, Copytime: time.Now().Unix()}}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if status == "checkPass" {
+		r.success++
+	} else {
+		r.fail++
+		fmt.Fprintln(r.failed, info.Filename)
+	}
+	if r.state != nil {
+		r.state.Add(checkEntry{Key: result.Filename, Info: result})
+		return
+	}
+	r.write(result)
+}
+
+//incr模式保留上次的结果
+func (r *checkReport) Keep(info FileInfo) {
+	r.state.Add(checkEntry{Key: info.Filename, Info: info, Kept: true})
+}
+
+func (r *checkReport) write(info FileInfo) {
+	if err := writeJobEntry(r.jobW, info); err != nil {
+		log.Println(err)
+	}
+}
+
+//输出检查失败的名字，返回成功和失败的数量
+func (r *checkReport) Result() (int, int) {
+	r.failed.Seek(0, io.SeekStart)
+	io.Copy(os.Stdout, r.failed)
+	return r.success, r.fail
+}
+
+//保存检查结果到jobFile
+func (r *checkReport) Save() {
+	r.failed.Close()
+	os.Remove(r.failed.Name())
+	if r.state != nil {
+		last, first := "", true
+		for entry := range r.state.Sorted() {
+			if !first && entry.Key == last {
+				continue //这次的结果排在保留的上次结果之前
+			}
+			last, first = entry.Key, false
+			r.write(entry.Info)
+		}
+	}
+	r.jobW.WriteString("}")
+	if err := r.jobW.Flush(); err != nil {
+		log.Println(err)
+	}
+	r.job.Close()
+	if err := os.Rename(r.job.Name(), r.jobFile); err != nil {
+		log.Println(err)
+	}
+}
+
+//流式读取jobFile中上次的结果，按key(名字)排序后输出，jobFile不存在时为空
+func readJobState(jobFile string, key func(name string) string) <-chan checkEntry {
+	sorter := newCheckSorter()
+	fd, err := os.Open(jobFile)
+	if err == nil {
+		defer fd.Close()
+		if err := decodeJobState(bufio.NewReader(fd), func(info FileInfo) {
+			sorter.Add(checkEntry{Key: key(info.Filename), Info: info})
+		}); err != nil && err != io.EOF {
+			log.Println("Failed to read job file:", jobFile, err)
+		}
+	}
+	return sorter.Sorted()
+}
+
+//json会把名字中非UTF-8的字节替换为U+FFFD, job文件和改名文件中的名字用percentEncode编码后保存
+//文件的第一个成员jobFormatKey标记名字已经编码，它的值是字符串，旧版本的job文件中的值都是FileInfo
+const (
+	jobFormatKey     = "%"
+	jobFormatPercent = "percent"
+)
+
+func beginJobState(w *bufio.Writer) {
+	w.WriteString(`{"` + jobFormatKey + `":"` + jobFormatPercent + `"`)
+}
+
+//写入一个条目，beginJobState之后调用，结束时需要写入"}"
+func writeJobEntry(w *bufio.Writer, info FileInfo) error {
+	key, _ := json.Marshal(percentEncode(info.Filename))
+	value, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	w.WriteString(",")
+	w.Write(key)
+	w.WriteString(":")
+	w.Write(value)
+	return nil
+}
+
+//把整个状态写入job文件，用于watch保存内存中的状态
+func writeJobFile(jobFile string, state map[string]FileInfo) error {
+	fd, err := os.Create(jobFile)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	w := bufio.NewWriter(fd)
+	beginJobState(w)
+	for name, info := range state {
+		info.Filename = name
+		if err := writeJobEntry(w, info); err != nil {
+			return err
+		}
+	}
+	w.WriteString("}")
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return fd.Close()
+}
+
+//job文件是名字到FileInfo的json对象，逐个解码，不读入整个文件
+//名字以key为准，FileInfo中的Filename可能已经被json改写
+func decodeJobState(r io.Reader, fn func(info FileInfo)) error {
+	dec := json.NewDecoder(r)
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	encoded := false
+	for first := true; dec.More(); first = false {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		name, _ := t.(string)
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+		if first && name == jobFormatKey && len(value) > 0 && value[0] == '"' {
+			var format string
+			json.Unmarshal(value, &format)
+			if format != jobFormatPercent {
+				return errors.New("unknown job file format " + format)
+			}
+			encoded = true
+			continue
+		}
+		var info FileInfo
+		if err := json.Unmarshal(value, &info); err != nil {
+			return err
+		}
+		if encoded {
+			name = percentDecode(name)
+		}
+		info.Filename = name
+		fn(info)
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func entry(key string, filename string) checkEntry {
+	return checkEntry{Key: key, Info: FileInfo{Filename: filename}}
+}
+
+func passed(key string, filename string, status string) checkEntry {
+	e := entry(key, filename)
+	e.Info.CStatus.CopyStatus = status
+	return e
+}
+
+func stream(entries ...checkEntry) <-chan checkEntry {
+	out := make(chan checkEntry, len(entries))
+	for _, e := range entries {
+		out <- e
+	}
+	close(out)
+	return out
+}
+
+//用checkSorter排序，batch较小时会写入多个run再归并
+func sorted(t *testing.T, batch int, entries ...checkEntry) <-chan checkEntry {
+	t.Helper()
+	checkBatch = batch
+	s := newCheckSorter()
+	for _, e := range entries {
+		s.Add(e)
+	}
+	return s.Sorted()
+}
+
+func withDataDir(t *testing.T) {
+	t.Helper()
+	oldDataDir, oldBatch := dataDir, checkBatch
+	dataDir = t.TempDir()
+	t.Cleanup(func() {
+		dataDir, checkBatch = oldDataDir, oldBatch
+	})
+}
+
+func keys(ch <-chan checkEntry) []string {
+	var list []string
+	for e := range ch {
+		list = append(list, e.Key+"|"+e.Info.Filename)
+	}
+	return list
+}
+
+func TestCheckSorter(t *testing.T) {
+	input := []checkEntry{
+		entry("d/2", "d/2"), entry("b", "b"), entry("a/", "a/"), entry("c", "y"), entry("c", "x"),
+		entry("a/1", "a/1"), entry("d/1", "d/1"), entry("b", "b"), entry("", ""),
+	}
+	want := []string{"|", "a/|a/", "a/1|a/1", "b|b", "b|b", "c|x", "c|y", "d/1|d/1", "d/2|d/2"}
+	tests := []struct {
+		name  string
+		batch int
+		runs  int
+	}{
+		{"in memory", 100, 0},
+		{"one entry per run", 1, 9},
+		{"spill to runs", 2, 5},
+		{"last batch not full", 4, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withDataDir(t)
+			checkBatch = tt.batch
+			s := newCheckSorter()
+			for _, e := range input {
+				s.Add(e)
+			}
+			out := s.Sorted()
+			got := keys(out)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("sorted = %v, want %v", got, want)
+			}
+			if len(s.runs) != tt.runs {
+				t.Errorf("runs = %d, want %d", len(s.runs), tt.runs)
+			}
+			left, _ := os.ReadDir(dataDir)
+			if len(left) != 0 {
+				t.Errorf("%d run files left in the data directory", len(left))
+			}
+		})
+	}
+}
+
+func TestMergeJoin(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   []checkEntry
+		dst   []checkEntry
+		pairs []string //源端名字 -> 目标端名字，没有目标时为-
+		extra []string
+	}{
+		{
+			name:  "matched and missing",
+			src:   []checkEntry{entry("a", "a"), entry("b", "b"), entry("c", "c")},
+			dst:   []checkEntry{entry("a", "a"), entry("c", "c")},
+			pairs: []string{"a>a", "b>-", "c>c"},
+		},
+		{
+			name:  "destination only",
+			src:   []checkEntry{entry("b", "b"), entry("d/", "d/")},
+			dst:   []checkEntry{entry("a", "a"), entry("b", "b"), entry("c", "c"), entry("d/", "d/"), entry("d/x", "d/x")},
+			pairs: []string{"b>b", "d/>d/"},
+			extra: []string{"a", "c", "d/x"},
+		},
+		{
+			name:  "empty source",
+			dst:   []checkEntry{entry("a", "a")},
+			extra: []string{"a"},
+		},
+		{
+			name:  "empty destination",
+			src:   []checkEntry{entry("a", "a")},
+			pairs: []string{"a>-"},
+		},
+		{
+			name:  "duplicate source entries",
+			src:   []checkEntry{entry("a", "a"), entry("a", "a"), entry("b", "b"), entry("b", "b")},
+			dst:   []checkEntry{entry("a", "a")},
+			pairs: []string{"a>a", "b>-"},
+		},
+		{
+			name:  "duplicate keys from different sources",
+			src:   []checkEntry{entry("k", "x"), entry("k", "y")},
+			dst:   []checkEntry{entry("k", "k")},
+			pairs: []string{"x>k", "y>k"},
+		},
+		{
+			name:  "renamed keys",
+			src:   []checkEntry{entry("2024/a", "raw/a"), entry("2024/b", "raw/b"), entry("z", "z")},
+			dst:   []checkEntry{entry("2024/a", "2024/a"), entry("raw/b", "raw/b"), entry("z", "z")},
+			pairs: []string{"raw/a>2024/a", "raw/b>-", "z>z"},
+			extra: []string{"raw/b"},
+		},
+	}
+	for _, tt := range tests {
+		for _, batch := range []int{1, 2, 1000} {
+			t.Run(tt.name, func(t *testing.T) {
+				withDataDir(t)
+				var pairs, extra []string
+				src := sorted(t, batch, tt.src...)
+				dst := sorted(t, batch, tt.dst...)
+				mergeJoin(src, dst, func(src checkEntry, dst *checkEntry) {
+					name := "-"
+					if dst != nil {
+						name = dst.Info.Filename
+					}
+					pairs = append(pairs, src.Info.Filename+">"+name)
+				}, func(dst checkEntry) {
+					extra = append(extra, dst.Info.Filename)
+				})
+				if !reflect.DeepEqual(pairs, tt.pairs) {
+					t.Errorf("batch %d: pairs = %v, want %v", batch, pairs, tt.pairs)
+				}
+				if !reflect.DeepEqual(extra, tt.extra) {
+					t.Errorf("batch %d: extra = %v, want %v", batch, extra, tt.extra)
+				}
+			})
+		}
+	}
+}
+
+func TestSkipPassed(t *testing.T) {
+	tests := []struct {
+		name string
+		src  []checkEntry
+		prev []checkEntry
+		out  []string //输出的条目，带上次的结果时为 名字:上次的状态
+		keep []string
+	}{
+		{
+			name: "passed entries are kept",
+			src:  []checkEntry{entry("a", "a"), entry("b", "b"), entry("c", "c")},
+			prev: []checkEntry{passed("a", "a", "checkPass"), passed("b", "b", "checkFail")},
+			out:  []string{"b:checkFail", "c"},
+			keep: []string{"a"},
+		},
+		{
+			name: "previous entries without source",
+			src:  []checkEntry{entry("b", "b")},
+			prev: []checkEntry{passed("a", "a", "checkPass"), passed("c", "c", "checkFail")},
+			out:  []string{"b"},
+			keep: []string{"a", "c"},
+		},
+		{
+			name: "duplicate source entries",
+			src:  []checkEntry{entry("a", "a"), entry("a", "a"), entry("b", "b"), entry("b", "b")},
+			prev: []checkEntry{passed("a", "a", "checkPass")},
+			out:  []string{"b"},
+			keep: []string{"a"},
+		},
+		{
+			name: "renamed keys",
+			src:  []checkEntry{entry("new/a", "old/a"), entry("new/b", "old/b")},
+			prev: []checkEntry{passed("new/a", "old/a", "checkPass"), passed("old/b", "old/b", "checkPass")},
+			out:  []string{"old/b"},
+			keep: []string{"old/a", "old/b"},
+		},
+		{
+			name: "same key from different sources",
+			src:  []checkEntry{entry("k", "x"), entry("k", "y")},
+			prev: []checkEntry{passed("k", "y", "checkPass")},
+			out:  []string{"x"},
+			keep: []string{"y"},
+		},
+	}
+	for _, tt := range tests {
+		for _, batch := range []int{1, 1000} {
+			t.Run(tt.name, func(t *testing.T) {
+				withDataDir(t)
+				var out, keep []string
+				src := sorted(t, batch, tt.src...)
+				prev := sorted(t, batch, tt.prev...)
+				for e := range skipPassed(src, prev, func(info FileInfo) {
+					keep = append(keep, info.Filename)
+				}) {
+					name := e.Info.Filename
+					if e.Prev != nil {
+						name += ":" + e.Prev.CStatus.CopyStatus
+					}
+					out = append(out, name)
+				}
+				if !reflect.DeepEqual(out, tt.out) {
+					t.Errorf("batch %d: out = %v, want %v", batch, out, tt.out)
+				}
+				if !reflect.DeepEqual(keep, tt.keep) {
+					t.Errorf("batch %d: keep = %v, want %v", batch, keep, tt.keep)
+				}
+			})
+		}
+	}
+}
+
+func TestDecodeJobState(t *testing.T) {
+	withDataDir(t)
+	checkBatch = 1
+	jobFile := dataDir + "/job"
+	content := `{"b/":{"Filename":"b/","FType":"0040","CStatus":{"CopyStatus":"checkPass"}},"a":{"FSize":3,"CStatus":{"CopyStatus":"checkFail"}}}`
+	if err := os.WriteFile(jobFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got := keys(readJobState(jobFile, func(name string) string { return "x/" + name }))
+	want := []string{"x/a|a", "x/b/|b/"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("state = %v, want %v", got, want)
+	}
+	if got := keys(readJobState(dataDir+"/missing", dstNameOf)); got != nil {
+		t.Errorf("missing job file gives %v", got)
+	}
+}
+
+func TestCheckReportSave(t *testing.T) {
+	for _, batch := range []int{1, 2, 1000} {
+		withDataDir(t)
+		checkBatch = batch
+		jobFile := dataDir + "/job"
+		r := newCheckReport(jobFile, true)
+		//上次检查通过的b和这次检查失败的b名字相同，这次的结果先加入和后加入都要保留这次的结果
+		r.Keep(FileInfo{Filename: "b", CStatus: CopyInfo{CopyStatus: "checkPass"}})
+		r.Add(FileInfo{Filename: "b"}, "checkFail")
+		r.Add(FileInfo{Filename: "c"}, "checkFail")
+		r.Keep(FileInfo{Filename: "c", CStatus: CopyInfo{CopyStatus: "checkPass"}})
+		r.Keep(FileInfo{Filename: "a", CStatus: CopyInfo{CopyStatus: "checkPass"}})
+		r.Add(FileInfo{Filename: "d"}, "checkPass")
+		r.Save()
+
+		content, err := os.ReadFile(jobFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !json.Valid(content) {
+			t.Fatalf("batch %d: invalid job file %s", batch, content)
+		}
+		got := map[string]string{}
+		for name, info := range readLastTimeCopyInfo(jobFile) {
+			got[name] = info.CStatus.CopyStatus
+		}
+		want := map[string]string{"a": "checkPass", "b": "checkFail", "c": "checkFail", "d": "checkPass"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("batch %d: state = %v, want %v", batch, got, want)
+		}
+	}
+}
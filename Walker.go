@@ -0,0 +1,190 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//并行遍历目录树，代替filepath.Walk, 参数和filepath.WalkFunc相同
+//最多-walk-procs个goroutine同时读取目录，每次用ReadDir读取一批条目(getdents)，不排序，也不对每个条目Lstat
+//fn会被并发调用，传入的info只保证Name, IsDir和Mode中的文件类型，其他属性用到时才Lstat
+//fn返回filepath.SkipDir时不进入这个目录，返回其他错误时停止遍历并返回这个错误
+const walkBatch = 1024
+
+//fs.DirEntry转为os.FileInfo，Size, ModTime, Sys用到时才Lstat
+type dirEntryInfo struct {
+	fs.DirEntry
+	once sync.Once
+	info os.FileInfo
+}
+
+func (d *dirEntryInfo) stat() os.FileInfo {
+	d.once.Do(func() {
+		d.info, _ = d.DirEntry.Info()
+	})
+	return d.info
+}
+
+func (d *dirEntryInfo) Mode() fs.FileMode {
+	if info := d.stat(); info != nil {
+		return info.Mode()
+	}
+	return d.Type()
+}
+
+func (d *dirEntryInfo) Size() int64 {
+	if info := d.stat(); info != nil {
+		return info.Size()
+	}
+	return 0
+}
+
+func (d *dirEntryInfo) ModTime() time.Time {
+	if info := d.stat(); info != nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
+
+func (d *dirEntryInfo) Sys() interface{} {
+	if info := d.stat(); info != nil {
+		return info.Sys()
+	}
+	return nil
+}
+
+//待读取的目录队列，pending为还没有读取完成的目录数，为0时遍历结束
+type walkQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	dirs    []string
+	pending int
+	err     error
+}
+
+func (q *walkQueue) push(dir string) {
+	q.mu.Lock()
+	q.dirs = append(q.dirs, dir)
+	q.pending++
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+//取出一个目录，没有待读取的目录并且其他goroutine也不会再加入时返回false
+func (q *walkQueue) pop() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.dirs) == 0 && q.pending > 0 && q.err == nil {
+		q.cond.Wait()
+	}
+	if len(q.dirs) == 0 || q.err != nil {
+		return "", false
+	}
+	dir := q.dirs[len(q.dirs)-1] //后进先出，优先读取深层的目录，队列不会太长
+	q.dirs = q.dirs[:len(q.dirs)-1]
+	return dir, true
+}
+
+func (q *walkQueue) done(err error) {
+	q.mu.Lock()
+	q.pending--
+	if err != nil && q.err == nil {
+		q.err = err
+	}
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+func (q *walkQueue) failed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.err != nil
+}
+
+func ParallelWalk(root string, fn filepath.WalkFunc) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	err = fn(root, info, nil)
+	if err == filepath.SkipDir || !info.IsDir() {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	q := &walkQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	q.push(root)
+
+	procs := walkProcs
+	if procs < 1 {
+		procs = 1
+	}
+	var wg sync.WaitGroup
+	wg.Add(procs)
+	for i := 0; i < procs; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				dir, ok := q.pop()
+				if !ok {
+					return
+				}
+				q.done(readDir(q, dir, fn))
+			}
+		}()
+	}
+	wg.Wait()
+	return q.err
+}
+
+//分批读取一个目录，子目录加入队列
+func readDir(q *walkQueue, dir string, fn filepath.WalkFunc) error {
+	fd, err := os.Open(dir)
+	if err != nil {
+		info, _ := os.Lstat(dir)
+		if err := fn(dir, info, err); err != nil && err != filepath.SkipDir {
+			return err
+		}
+		return nil
+	}
+	defer fd.Close()
+
+	for !q.failed() {
+		entries, err := fd.ReadDir(walkBatch)
+		for _, entry := range entries {
+			fpath := filepath.Join(dir, entry.Name())
+			info := &dirEntryInfo{DirEntry: entry}
+			err := fn(fpath, info, nil)
+			if err == filepath.SkipDir {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() { //symlink的类型不是目录，不会跟随
+				q.push(fpath)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			info, _ := os.Lstat(dir)
+			if err := fn(dir, info, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,485 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+//-watch: 初次同步后用inotify监听源目录，只把变化的路径交给拷贝和检查，删除的路径从目标端和job文件中删除
+//每个目录一个watch, 新建或移入的目录加入watch并把其中的条目作为变化的路径
+//普通文件等到IN_CLOSE_WRITE才拷贝，同一个路径在-watch-delay内的事件合并为一次
+//link()建立的硬链接只有IN_CREATE, 链接数大于1的新文件直接拷贝；其他IN_CREATE之后-watch-rescan内没有IN_CLOSE_WRITE的文件
+//(例如O_TMPFILE写入后linkat)到时也拷贝，如果仍在写入，关闭时会再拷贝一次
+//IN_Q_OVERFLOW丢失了事件，或者watch数量超过max_user_watches时，改为每隔-watch-rescan扫描一次源目录，和job文件比较
+//job文件的状态在监听期间保存在内存中，每一批变化只用这一批的临时job文件拷贝和检查，每隔-watch-save和退出时写入job文件
+const watchMask = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_DELETE | syscall.IN_DONT_FOLLOW | syscall.IN_EXCL_UNLINK | syscall.IN_ONLYDIR
+
+type pendingPath struct {
+	deleted   bool
+	waitClose bool //IN_CREATE之后还没有IN_CLOSE_WRITE
+	last      time.Time
+}
+
+type watcher struct {
+	fd   int
+	root string
+
+	mu       sync.Mutex
+	wds      map[int]string //watch descriptor到相对路径，根目录为""，其他目录以/结尾
+	pending  map[string]*pendingPath
+	overflow bool //上次扫描后有事件丢失
+	noWatch  bool //无法加入watch, 只能定期扫描
+
+	state map[string]FileInfo //job文件的内容，只在Run的goroutine中使用
+	dirty bool                //state有还没有写入job文件的变化
+	saved time.Time
+}
+
+//在初次同步之前开始监听，同步期间的变化在同步之后处理
+func startWatch(root string) *watcher {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		log.Fatalln("Failed to initialize inotify:", err)
+	}
+	w := &watcher{fd: fd, root: root, wds: map[int]string{}, pending: map[string]*pendingPath{}}
+	w.addTree("", false)
+	go w.readEvents()
+	return w
+}
+
+func (w *watcher) mask() uint32 {
+	if withAttr {
+		return watchMask | syscall.IN_ATTRIB //-a true时权限和属主的变化也要同步
+	}
+	return watchMask
+}
+
+//调用时持有w.mu
+func (w *watcher) addWatch(dir string) {
+	if w.noWatch {
+		return
+	}
+	wd, err := syscall.InotifyAddWatch(w.fd, filepath.Join(w.root, dir), w.mask())
+	if err == syscall.ENOSPC {
+		log.Println("Too many inotify watches, please raise fs.inotify.max_user_watches. Falling back to rescans every", watchRescan)
+		w.noWatch = true
+		return
+	}
+	if err != nil {
+		log.Println("Failed to watch", filepath.Join(w.root, dir), err)
+		return
+	}
+	w.wds[wd] = dir //同一个目录再次加入时返回相同的wd
+}
+
+//调用时持有w.mu
+func (w *watcher) mark(name string, deleted bool) {
+	w.pending[name] = &pendingPath{deleted: deleted, last: time.Now()}
+}
+
+//加入dir及其下所有目录的watch, changed为true时dir下的条目都作为变化的路径
+//先加入watch再读取目录，读取期间新建的文件不会丢失
+func (w *watcher) addTree(dir string, changed bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := filepath.WalkDir(filepath.Join(w.root, dir), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil //目录可能已经被删除
+		}
+		name, _ := filepath.Rel(w.root, p)
+		if d.IsDir() {
+			if name == "." {
+				name = ""
+			} else {
+				name = name + "/"
+			}
+			w.addWatch(name)
+		}
+		if changed && name != "" {
+			w.mark(name, false)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+//移出或删除的目录，去掉其下所有目录的watch
+//调用时持有w.mu
+func (w *watcher) removeTree(dir string) {
+	for wd, name := range w.wds {
+		if strings.HasPrefix(name, dir) {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.wds, wd)
+		}
+	}
+}
+
+//读取inotify事件，记录变化和删除的路径
+func (w *watcher) readEvents() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := syscall.Read(w.fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			log.Fatalln("Failed to read inotify events:", err)
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+			w.handle(event.Wd, event.Mask, strings.TrimRight(string(nameBytes), "\x00"))
+		}
+	}
+}
+
+func (w *watcher) handle(wd int32, mask uint32, base string) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		w.mu.Lock()
+		if !w.overflow {
+			log.Println("Inotify event queue overflow, rescanning the source")
+		}
+		w.overflow = true
+		w.mu.Unlock()
+		return
+	}
+	w.mu.Lock()
+	dir, ok := w.wds[int(wd)]
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(w.wds, int(wd))
+	}
+	w.mu.Unlock()
+	if !ok || base == "" {
+		return
+	}
+	name := dir + base
+	isDir := mask&syscall.IN_ISDIR != 0
+	if isDir {
+		name = name + "/"
+	}
+
+	switch {
+	case isDir && mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+		w.addTree(name, true)
+	case mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+		w.mu.Lock()
+		if isDir {
+			w.removeTree(name)
+		}
+		w.mark(name, true)
+		w.mu.Unlock()
+	case mask&syscall.IN_CREATE != 0:
+		//普通文件等待IN_CLOSE_WRITE, 符号链接没有写入，创建后就可以拷贝
+		fi, err := os.Lstat(filepath.Join(w.root, name))
+		if err != nil {
+			return
+		}
+		w.mu.Lock()
+		if st, ok := fi.Sys().(*syscall.Stat_t); fi.Mode()&os.ModeSymlink != 0 || (fi.Mode().IsRegular() && ok && st.Nlink > 1) {
+			w.mark(name, false) //符号链接，或者link()建立的硬链接
+		} else if fi.Mode().IsRegular() {
+			w.pending[name] = &pendingPath{waitClose: true, last: time.Now()}
+		}
+		w.mu.Unlock()
+	case mask&(syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO|syscall.IN_ATTRIB) != 0:
+		w.mu.Lock()
+		w.mark(name, false)
+		w.mu.Unlock()
+	}
+}
+
+//取出-watch-delay内没有新事件的路径
+func (w *watcher) due() (changed []string, deleted []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for name, p := range w.pending {
+		if time.Since(p.last) < watchDelay || (p.waitClose && time.Since(p.last) < watchRescan) {
+			continue
+		}
+		if p.deleted {
+			deleted = append(deleted, name)
+		} else {
+			changed = append(changed, name)
+		}
+		delete(w.pending, name)
+	}
+	return changed, deleted
+}
+
+//扫描源目录，和job文件比较得到变化和删除的路径，同时补上缺少的watch
+func (w *watcher) rescan(fileMap map[string]FileInfo) (changed []string, deleted []string) {
+	var mu sync.Mutex
+	seen := map[string]bool{}
+	err := ParallelWalk(w.root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		name, _ := filepath.Rel(w.root, p)
+		if info.IsDir() {
+			if name == "." {
+				name = ""
+			} else {
+				name = name + "/"
+			}
+			w.mu.Lock()
+			w.addWatch(name)
+			w.mu.Unlock()
+			if name == "" {
+				return nil
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		seen[name] = true
+		prev, ok := fileMap[name]
+		if !ok || prev.CStatus.CopyStatus != "checkPass" || fileChanged(prev, p) {
+			changed = append(changed, name)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("Rescan failed:", err)
+		return nil, nil
+	}
+	for name := range fileMap {
+		if !seen[name] {
+			deleted = append(deleted, name)
+		}
+	}
+	return changed, deleted
+}
+
+//和上次检查通过时的大小、修改时间和类型比较，-a true时还比较权限和属主
+func fileChanged(prev FileInfo, p string) bool {
+	var info FileInfo
+	if withAttr {
+		info = GetFileMetadata(srcPath, p)
+	} else {
+		info = GetFileMetadataWithoutAttr(srcPath, p)
+	}
+	if info.FType != prev.FType {
+		return true
+	}
+	if withAttr && (info.FPerm != prev.FPerm || info.FUID != prev.FUID || info.FGID != prev.FGID) {
+		return true
+	}
+	return info.FType != "0040" && (info.FSize != prev.FSize || info.FmTime != prev.FmTime)
+}
+
+//删除目标端的文件或目录，目录删除其下的所有对象
+func deleteDst(client *s3.Client, info FileInfo) {
+	if RenamePath(info) == "" {
+		return
+	}
+	if mode == "f2f" {
+		if err := os.RemoveAll(dstJoin(dstPath, info)); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	key := dstJoin(dstPrefix, info)
+	keys := []string{key}
+	if withAttr {
+		keys = append(keys, xattrSidecarKey(key))
+	}
+	if strings.HasSuffix(key, "/") {
+		prefixes := keys
+		keys = nil
+		for _, prefix := range prefixes {
+			paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{Bucket: aws.String(dstBucket), Prefix: aws.String(prefix)})
+			for paginator.HasMorePages() {
+				page, err := paginator.NextPage(context.TODO())
+				if err != nil {
+					log.Println("Failed to list", prefix, err)
+					break
+				}
+				for _, obj := range page.Contents {
+					keys = append(keys, aws.ToString(obj.Key))
+				}
+			}
+		}
+	}
+	for _, k := range keys {
+		_, err := client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{Bucket: aws.String(dstBucket), Key: aws.String(k)})
+		if err != nil {
+			log.Println("Failed to delete", k, err)
+		}
+	}
+}
+
+//job文件中name以及name目录下的条目
+func entriesUnder(fileMap map[string]FileInfo, name string) []FileInfo {
+	var infos []FileInfo
+	if info, ok := fileMap[name]; ok {
+		infos = append(infos, info)
+	} else {
+		infos = append(infos, FileInfo{Filename: name})
+	}
+	if strings.HasSuffix(name, "/") {
+		for n, info := range fileMap {
+			if n != name && strings.HasPrefix(n, name) {
+				infos = append(infos, info)
+			}
+		}
+	}
+	return infos
+}
+
+//处理一批路径: 删除的路径从目标端和job文件中删除，变化的路径写入清单，和-files-from一样拷贝和增量检查
+//拷贝和检查使用只包含这一批的临时job文件，结果合并到w.state, 不每一批都读写整个job文件
+func (w *watcher) sync(jobFile string, changed []string, deleted []string) {
+	var client *s3.Client
+	if mode == "f2o" {
+		client = CreateS3Client(region)
+	}
+	removed := 0
+	for _, name := range deleted {
+		if !selected(name) {
+			continue
+		}
+		for _, info := range entriesUnder(w.state, name) {
+			if _, err := os.Lstat(filepath.Join(srcPath, info.Filename)); err == nil {
+				continue //删除后又创建的路径
+			}
+			deleteDst(client, info)
+			delete(w.state, info.Filename)
+			w.dirty = true
+			removed++
+		}
+	}
+	if removed > 0 {
+		log.Printf("Watch: deleted %d entries from the destination\n", removed)
+	}
+	if len(changed) == 0 {
+		return
+	}
+
+	manifest := CreateTempFile(dataDir, "watch-")
+	enc := json.NewEncoder(manifest)
+	for _, name := range changed {
+		enc.Encode(ManifestEntry{Path: name})
+	}
+	manifest.Close()
+	manifestFile := manifest.Name() + ".jsonl" //按扩展名识别清单格式
+	if err := os.Rename(manifest.Name(), manifestFile); err != nil {
+		log.Println(err)
+		return
+	}
+	defer os.Remove(manifestFile)
+
+	//临时job文件为空，变化的路径不会因为上次检查通过被跳过
+	batchFile := filepath.Join(dataDir, "watch-state")
+	defer func() {
+		for _, f := range jobStateFiles(batchFile) {
+			os.Remove(f)
+		}
+	}()
+	filesFrom = manifestFile
+	kept := renames //改名的对应关系在监听期间一直保存在内存中
+	resetJobState()
+	renames = kept
+	result := runJobState("sync", batchFile)
+	filesFrom = ""
+	for _, name := range changed {
+		delete(w.state, name) //拷贝前已经不存在或者不再选中的路径没有新的结果
+	}
+	for name, info := range readLastTimeCopyInfo(batchFile) {
+		w.state[name] = info
+	}
+	w.dirty = true
+	log.Printf("Watch: copied %d entries, check success: %d, check fail: %d\n", result.Listed, result.Success, result.Fail)
+}
+
+//把内存中的状态写入job文件，先写临时文件再替换，写入中断时保留原来的job文件
+func (w *watcher) save(jobFile string) {
+	w.saved = time.Now()
+	if !w.dirty {
+		return
+	}
+	tmp := jobFile + ".tmp"
+	if err := writeJobFile(tmp, w.state); err != nil {
+		log.Println("Failed to write job file:", err)
+		return
+	}
+	if err := os.Rename(tmp, jobFile); err != nil {
+		log.Println("Failed to write job file:", err)
+		return
+	}
+	SaveRenameState(jobFile)
+	w.dirty = false
+}
+
+//初次同步之后运行，直到收到SIGINT或SIGTERM
+func (w *watcher) Run(jobFile string) {
+	isInitialCopy = false
+	checkMode = "incr" //每次只检查变化的路径
+	w.state = readLastTimeCopyInfo(jobFile)
+	w.saved = time.Now()
+	defer w.save(jobFile)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	centerPrint(100, fmt.Sprintf("Watching %s for changes", srcPath), "*")
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	var nextRescan time.Time
+	for {
+		select {
+		case sig := <-signals:
+			log.Println("Watch stopped by", sig)
+			return
+		case <-tick.C:
+		}
+		if time.Since(w.saved) >= watchSave {
+			w.save(jobFile)
+		}
+
+		w.mu.Lock()
+		overflow, noWatch := w.overflow, w.noWatch
+		w.mu.Unlock()
+		if overflow && nextRescan.IsZero() {
+			nextRescan = time.Now() //溢出后立即扫描一次
+		}
+		if (overflow || noWatch || !nextRescan.IsZero()) && !time.Now().Before(nextRescan) {
+			w.mu.Lock()
+			w.overflow = false
+			w.pending = map[string]*pendingPath{} //扫描包括了所有未处理的事件
+			w.mu.Unlock()
+			changed, deleted := w.rescan(w.state)
+			w.sync(jobFile, changed, deleted)
+			if overflow || noWatch {
+				nextRescan = time.Now().Add(watchRescan) //扫描期间仍然溢出时继续定期扫描
+			} else {
+				nextRescan = time.Time{} //一个周期内没有溢出，恢复按事件同步
+			}
+			continue
+		}
+
+		changed, deleted := w.due()
+		if len(changed) > 0 || len(deleted) > 0 {
+			w.sync(jobFile, changed, deleted)
+		}
+	}
+}
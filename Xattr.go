@@ -0,0 +1,236 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"syscall"
+	"unicode/utf16"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+//扩展属性包括user.*, security.selinux, 以及POSIX ACL(system.posix_acl_access, system.posix_acl_default)
+//在s3中以file-xattr保存，值为json(值为base64), 加上其他metadata超过userMetaLimit时写入sidecar对象 xattrSidecarPrefix+key
+//sidecar放在bucket根下的保留前缀中，不会和用户的对象或目录冲突，列出对象时跳过这个前缀
+const (
+	xattrMetaKey       = "file-xattr"
+	xattrSidecarValue  = "sidecar"
+	xattrSidecarPrefix = ".admt-xattr/"
+	userMetaLimit      = 2048 //s3 user metadata的key和value总共不能超过2KB
+)
+
+func GetXattr(fpath string) map[string][]byte {
+	size, err := syscall.Listxattr(fpath, nil)
+	if err != nil || size <= 0 { //文件系统不支持xattr或没有xattr
+		return nil
+	}
+	buf := make([]byte, size)
+	size, err = syscall.Listxattr(fpath, buf)
+	if err != nil {
+		log.Println("Listxattr failed:", fpath, err)
+		return nil
+	}
+
+	xattrs := make(map[string][]byte)
+	for _, name := range strings.Split(string(buf[:size]), "\x00") {
+		if name == "" {
+			continue
+		}
+		vsize, err := syscall.Getxattr(fpath, name, nil)
+		if err != nil {
+			log.Println("Getxattr failed:", fpath, name, err)
+			continue
+		}
+		value := make([]byte, vsize)
+		if vsize > 0 {
+			vsize, err = syscall.Getxattr(fpath, name, value)
+			if err != nil {
+				log.Println("Getxattr failed:", fpath, name, err)
+				continue
+			}
+		}
+		xattrs[name] = value[:vsize]
+	}
+	if len(xattrs) == 0 {
+		return nil
+	}
+	return xattrs
+}
+
+//ACL需要在chmod之后设置，否则chmod会改写ACL中的mask
+func SetXattr(fpath string, xattrs map[string][]byte) {
+	for name, value := range xattrs {
+		err := syscall.Setxattr(fpath, name, value, 0)
+		if err != nil {
+			log.Println("Setxattr failed:", fpath, name, err)
+		}
+	}
+}
+
+//[]byte在json中已经编码为base64, 名字中的非ASCII字符转义为\uXXXX, 结果可以直接放在metadata中
+func EncodeXattr(xattrs map[string][]byte) string {
+	b, err := json.Marshal(xattrs)
+	if err != nil {
+		log.Println(err)
+		return ""
+	}
+	var sb strings.Builder
+	for _, r := range string(b) {
+		if r < 0x80 {
+			sb.WriteRune(r)
+		} else if r > 0xffff {
+			r1, r2 := utf16.EncodeRune(r)
+			fmt.Fprintf(&sb, "\\u%04x\\u%04x", r1, r2)
+		} else {
+			fmt.Fprintf(&sb, "\\u%04x", r)
+		}
+	}
+	return sb.String()
+}
+
+func DecodeXattr(s string) map[string][]byte {
+	var xattrs map[string][]byte
+	err := json.Unmarshal([]byte(s), &xattrs)
+	if err != nil {
+		log.Println("Invalid xattr metadata:", err)
+		return nil
+	}
+	return xattrs
+}
+
+//把xattr写到metadata里，如果加上其他metadata太大则写sidecar对象，metadata里只放标记
+//metadata需要已经包含其他所有的key，不带xattr也放不下时返回错误
+func PutXattrMeta(client manager.UploadAPIClient, Bucket string, Key string, metadata map[string]string, xattrs map[string][]byte) error {
+	size := metadataSize(metadata)
+	if len(xattrs) == 0 {
+		if size > userMetaLimit {
+			return fmt.Errorf("metadata is %d bytes, larger than the S3 limit of %d bytes", size, userMetaLimit)
+		}
+		return nil
+	}
+	encoded := EncodeXattr(xattrs)
+	if size+len(xattrMetaKey)+len(encoded) <= userMetaLimit {
+		metadata[xattrMetaKey] = encoded
+		return nil
+	}
+	if size+len(xattrMetaKey)+len(xattrSidecarValue) > userMetaLimit {
+		return fmt.Errorf("metadata without xattrs is %d bytes, larger than the S3 limit of %d bytes", size+len(xattrMetaKey)+len(xattrSidecarValue), userMetaLimit)
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(Bucket),
+		Key:    aws.String(xattrSidecarKey(Key)),
+		Body:   bytes.NewReader([]byte(encoded)),
+	}
+	dstSSE.applyPut(input)
+	_, err := client.PutObject(context.TODO(), input)
+	if err != nil {
+		return fmt.Errorf("failed to upload xattr sidecar: %v", err)
+	}
+	metadata[xattrMetaKey] = xattrSidecarValue
+	return nil
+}
+
+//s3按key和value的字节数计算user metadata的大小
+func metadataSize(metadata map[string]string) int {
+	size := 0
+	for k, v := range metadata {
+		size += len(k) + len(v)
+	}
+	return size
+}
+
+func GetXattrMeta(client *s3.Client, Bucket string, Key string, metadata map[string]string, sse *SSEConfig) map[string][]byte {
+	encoded, ok := metadata[xattrMetaKey]
+	if !ok {
+		return nil
+	}
+	if encoded != xattrSidecarValue {
+		return DecodeXattr(encoded)
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(Bucket),
+		Key:    aws.String(xattrSidecarKey(Key)),
+	}
+	sse.applyGet(input)
+	output, err := client.GetObject(context.TODO(), input)
+	if err != nil {
+		log.Println("Failed to download xattr sidecar:", Key, err)
+		return nil
+	}
+	defer output.Body.Close()
+	b, err := io.ReadAll(output.Body)
+	if err != nil {
+		log.Println("Failed to download xattr sidecar:", Key, err)
+		return nil
+	}
+	return DecodeXattr(string(b))
+}
+
+func xattrSidecarKey(key string) string {
+	return xattrSidecarPrefix + key
+}
+
+func isXattrSidecar(key string) bool {
+	return strings.HasPrefix(key, xattrSidecarPrefix)
+}
+
+//o2o时CopyObject只拷贝metadata, 源端有sidecar的对象需要另外拷贝sidecar
+//sidecar只在xattr很大时才有，先列出源端prefix下所有的sidecar, 拷贝时按key查找
+var srcXattrSidecars map[string]bool
+
+func listXattrSidecars(client *s3.Client, Bucket string, Prefix string) map[string]bool {
+	sidecars := map[string]bool{}
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(Bucket),
+		Prefix: aws.String(xattrSidecarKey(Prefix)),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			log.Fatalln("Failed to list xattr sidecars:", err)
+		}
+		for _, obj := range page.Contents {
+			sidecars[strings.TrimPrefix(aws.ToString(obj.Key), xattrSidecarPrefix)] = true
+		}
+	}
+	return sidecars
+}
+
+func CopyXattrSidecar(client *s3.Client, srcBucket string, srcKey string, dstBucket string, dstKey string) {
+	if !srcXattrSidecars[srcKey] {
+		return
+	}
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(dstBucket),
+		CopySource: aws.String(srcBucket + "/" + xattrSidecarKey(srcKey)),
+		Key:        aws.String(xattrSidecarKey(dstKey)),
+	}
+	srcSSE.applyCopySource(input)
+	dstSSE.applyCopy(input)
+	if _, err := client.CopyObject(context.TODO(), input); err != nil {
+		log.Println("Failed to copy xattr sidecar:", srcKey, err)
+	}
+}
+
+//目标端可能会自动附加xattr(例如selinux标签)，所以只比较源端存在的xattr
+func xattrEqual(src map[string][]byte, dst map[string][]byte) bool {
+	for name, value := range src {
+		dstValue, ok := dst[name]
+		if !ok || !bytes.Equal(value, dstValue) {
+			return false
+		}
+	}
+	return true
+}
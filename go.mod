@@ -9,6 +9,9 @@ require (
 	github.com/aws/aws-sdk-go-v2/config v1.18.3
 	github.com/aws/aws-sdk-go-v2/feature/s3/manager v1.11.42
 	github.com/aws/aws-sdk-go-v2/service/s3 v1.29.4
+	github.com/klauspost/compress v1.15.12
+	golang.org/x/text v0.14.0
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
@@ -4,17 +4,17 @@
 package main //go 1.18.6
 
 import (
-	"bytes"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
 	"regexp"
 	"runtime"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
 var (
@@ -25,39 +25,179 @@ var (
 	dstBucket string
 	dstPrefix string
 	mode      string
+
+	srcArchive       string //源端为tar/zip归档文件时的路径
+	srcArchiveFormat string
+	dstArchive       string //目标端为tar/zip归档文件时的路径
+	dstArchiveFormat string
 )
 var ( //参数
-	factor          int
-	isInitialCopy   bool
-	partSize        int64
-	storageClass    string
-	check           string
-	checkMode       string
-	withAttr        bool
-	defaultFileMode Filemod
-	region          string
-	dataDir         string
-	jobDir          string
+	factor            int
+	isInitialCopy     bool
+	partSize          int64
+	storageClass      string
+	check             string
+	checkMode         string
+	withAttr          bool
+	defaultFileMode   Filemod
+	region            string
+	dataDir           string
+	jobDir            string
+	metadataFormat    string
+	idMap             *IDMap
+	contentTypeMode   string
+	headerRules       []HeaderRule
+	o2oReplaceHeaders bool
+	srcSSE            *SSEConfig
+	dstSSE            *SSEConfig
+	cseKey            []byte
+	compressCodec     string
+	compressSkip      []string
+	packThreshold     int64
+	packSize          int64
+	tagRules          tagFlags
+	tagDirective      string
+	checkTags         bool
+	storageClassRules []StorageClassRule
+
+	filesFrom    string //清单文件，指定时只拷贝清单中的文件
+	srcInventory string //S3 Inventory的manifest.json，代替列出源bucket
+
+	listProcs        int //同时进行的ListObjectsV2请求数
+	walkProcs        int //同时读取目录的goroutine数
+	headProcs        int //同时进行的HeadObject请求数
+	listDepth        int //按Delimiter拆分shard的层数
+	checkBatch       int //检查时每个排序run的条目数
+	renameRules      []RenameRule
+	nameEncoding     string
+	unicodeNormalize string
+	nameReportFile   string
+
+	watchMode   bool          //初次同步后监听源目录的变化
+	watchDelay  time.Duration //同一个路径的事件合并的时间
+	watchRescan time.Duration //事件丢失后扫描源目录的间隔
+	watchSave   time.Duration //监听期间写入job文件的间隔
 )
 
-func init() {
+// 解析sync, cp, verify, ls, diff的参数，每个命令有自己的FlagSet, 不使用的参数注册到hidden中，只设置默认值
+// defaults是job文件中的默认参数，在args之前设置
+func parseOptions(cmd string, args []string, defaults []string) {
+	//job文件中的每个transfer都重新解析参数，先清除只在指定时设置的值
+	idMap, headerRules, storageClassRules, renameRules = nil, nil, nil, nil
+	srcSSE, dstSSE, cseKey = nil, nil, nil
+	tagRules, includePatterns, excludePatterns = nil, nil, nil
+	srcArchive, dstArchive = "", ""
+
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	fs.Usage = func() { commandUsage(fs, cmd) }
+	hidden := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	copyFs, syncFs, checkFs, modeFs, jobFs := hidden, hidden, hidden, hidden, hidden
+	if cmd == "sync" || cmd == "cp" {
+		copyFs = fs
+	}
+	if cmd == "sync" {
+		syncFs = fs
+	}
+	if cmd == "sync" || cmd == "cp" || cmd == "verify" {
+		checkFs = fs
+	}
+	if cmd == "sync" || cmd == "verify" {
+		modeFs = fs
+	}
+	if cmd != "ls" {
+		jobFs = fs
+	}
+	checkDefault, checkModeDefault := "nocheck", "incr"
+	checkUsage := "Check mode after copy completion, you can set 'nocheck','attr', 'md5'"
+	if cmd == "verify" {
+		checkDefault, checkModeDefault = "attr", "full"
+		checkUsage = "Check mode, you can set 'attr', 'md5'"
+	}
 
-	centerPrint(150, "Written by 王大伟, Welcome any feedback to login:awsdawei@, WeChat: 374727961", "*")
-	flag.IntVar(&factor, "f", 10, "Factor of goroutines setting, you will get goroutines with number of factor*numOfCPUs")
-	flag.StringVar(&storageClass, "sc", "STANDARD", "Specify one of S3 Storage Classes: 'STANDARD', 'REDUCED_REDUNDANCY', 'STANDARD_IA', 'ONEZONE_IA','INTELLIGENT_TIERING','GLACIER','DEEP_ARCHIVE','GLACIER_IR'")
-	flag.StringVar(&region, "region", "", "Specify your region")
-	flag.Int64Var(&partSize, "p", 100, "Part size, you will decide how much part when s3 leverages multipart feature to upload or download")
+	jobFs.IntVar(&factor, "f", 10, "Factor of goroutines setting, you will get goroutines with number of factor*numOfCPUs")
+	copyFs.StringVar(&storageClass, "sc", "STANDARD", "Specify one of S3 Storage Classes: 'STANDARD', 'REDUCED_REDUNDANCY', 'STANDARD_IA', 'ONEZONE_IA','INTELLIGENT_TIERING','GLACIER','DEEP_ARCHIVE','GLACIER_IR'")
+	fs.StringVar(&region, "region", "", "Specify your region")
+	jobFs.Int64Var(&partSize, "p", 100, "Part size, you will decide how much part when s3 leverages multipart feature to upload or download")
 	var isInitialCopyStr string
-	flag.StringVar(&isInitialCopyStr, "i", "false", "Do you want initial sync?, Please input 'true' or 'false'") //Go里布尔类型必须要使用--i=true这种方式，所以这里用Int做转换
+	syncFs.StringVar(&isInitialCopyStr, "i", "false", "Do you want initial sync?, Please input 'true' or 'false'") //Go里布尔类型必须要使用--i=true这种方式，所以这里用Int做转换
 	var withAttrStr string
-	flag.StringVar(&withAttrStr, "a", "false", "'true': copy with file attributes, 'false': copy without file attributes")
-	flag.StringVar(&check, "c", "nocheck", "Check mode after copy completion, you can set 'nocheck','attr', 'md5'")
-	flag.StringVar(&checkMode, "t", "incr", "'incr': only check the copied files, 'full': check whole dataset")
-	flag.IntVar(&(defaultFileMode.UID), "u", os.Getuid(), "You can specify default UID other than current user")
-	flag.IntVar(&(defaultFileMode.GID), "g", os.Getgid(), "You can specify default GID other than current group")
-	flag.StringVar(&(defaultFileMode.Mode), "m", "775", "You can specify default file mod other than 775")
-
-	flag.Parse() //Parse函数要在参数定义之后解析
+	fs.StringVar(&withAttrStr, "a", "false", "'true': copy with file attributes, 'false': copy without file attributes")
+	checkFs.StringVar(&check, "c", checkDefault, checkUsage)
+	modeFs.StringVar(&checkMode, "t", checkModeDefault, "'incr': only check the copied files, 'full': check whole dataset")
+	copyFs.IntVar(&(defaultFileMode.UID), "u", os.Getuid(), "You can specify default UID other than current user")
+	copyFs.IntVar(&(defaultFileMode.GID), "g", os.Getgid(), "You can specify default GID other than current group")
+	copyFs.StringVar(&(defaultFileMode.Mode), "m", "775", "You can specify default file mod other than 775")
+	fs.StringVar(&metadataFormat, "metadata-format", "auto", "Format of file attributes in object metadata: 'auto', 'admt', 's3fs', 'rclone', 'mountpoint'. 'auto' writes 'admt' and reads any known format")
+
+	var idMapFile string
+	fs.StringVar(&idMapFile, "idmap", "", "JSON file that maps source UID/GID or user/group names to the destination, used by o2f, f2f and attr check")
+
+	copyFs.StringVar(&contentTypeMode, "content-type", "auto", "Content-Type detection for uploads: 'auto': by extension, then by content, 'ext': by extension only, 'none': leave to S3 default")
+	var headerRulesFile string
+	copyFs.StringVar(&headerRulesFile, "header-rules", "", "JSON file with per-pattern Content-Type, Cache-Control, Content-Disposition, Content-Encoding and Content-Language rules")
+	var o2oReplaceHeadersStr string
+	copyFs.StringVar(&o2oReplaceHeadersStr, "o2o-headers", "false", "'true': apply -content-type and -header-rules to o2o copies by replacing object headers, 'false': copy headers unchanged")
+
+	var sse, sseKMSKeyID, sseContext, sseBucketKeyStr, sseCKeyFile, srcSSECKeyFile string
+	copyFs.StringVar(&sse, "sse", "", "Server-side encryption of the destination: 'AES256', 'aws:kms', empty for the bucket default")
+	copyFs.StringVar(&sseKMSKeyID, "sse-kms-key-id", "", "KMS key id or ARN for '-sse aws:kms'")
+	copyFs.StringVar(&sseContext, "sse-context", "", "KMS encryption context for '-sse aws:kms' in JSON, e.g. '{\"project\":\"p1\"}'")
+	copyFs.StringVar(&sseBucketKeyStr, "sse-bucket-key", "false", "'true': use S3 Bucket Key for '-sse aws:kms'")
+	fs.StringVar(&sseCKeyFile, "sse-c-key", "", "File with the 256-bit SSE-C key (raw or base64) used to write the destination")
+	fs.StringVar(&srcSSECKeyFile, "src-sse-c-key", "", "File with the 256-bit SSE-C key (raw or base64) used to read the source")
+
+	var cseKeyFile string
+	fs.StringVar(&cseKeyFile, "cse-key", "", "File with the 256-bit master key (raw or base64) for client-side encryption of F2O uploads and decryption of O2F downloads")
+
+	fs.StringVar(&compressCodec, "compress", "", "Compress F2O uploads with 'zstd' or 'gzip', O2F decompresses automatically")
+	var compressSkipStr string
+	copyFs.StringVar(&compressSkipStr, "compress-skip", defaultCompressSkip, "Comma separated file patterns that are uploaded without compression")
+
+	var packThresholdStr, packSizeStr string
+	fs.StringVar(&packThresholdStr, "pack-threshold", "0", "F2O packs regular files smaller than this size (e.g. '64K') into per-directory tar bundles, '0' disables packing")
+	copyFs.StringVar(&packSizeStr, "pack-size", "256M", "Target size of a tar bundle for '-pack-threshold'")
+
+	copyFs.Var(&tagRules, "tag", "Object tag 'key=value' for F2O uploads and o2o copies, can be repeated. The value can use path templates: {1}, {2}... for directory levels, {name}, {ext}, {dir}")
+	copyFs.StringVar(&tagDirective, "tag-directive", "COPY", "Tags of o2o copies: 'COPY': keep the source tags, merged with '-tag', 'REPLACE': only use '-tag'")
+	var checkTagsStr string
+	jobFs.StringVar(&checkTagsStr, "check-tags", "false", "'true': compare object tags in the attr check of f2o and o2o")
+
+	var renameRulesFile string
+	fs.StringVar(&renameRulesFile, "rename-rules", "", "JSON file with regex rename rules for destination keys and paths, first match wins, the replacement can use $1 groups and {mtime:2006-01-02}, {uid}, {gid}, {user}, {group}")
+	fs.StringVar(&nameEncoding, "name-encoding", "none", "'percent': reversibly encode non-UTF-8 bytes and '%' as %XX in F2O keys and decode them in O2F, 'none': skip and report names S3 can not store")
+	fs.StringVar(&unicodeNormalize, "unicode-normalize", "", "Normalize destination names to 'nfc' or 'nfd', empty keeps names unchanged")
+	fs.StringVar(&nameReportFile, "name-report", "", "CSV file listing every name that was mapped or skipped")
+	var scRulesFile string
+	copyFs.StringVar(&scRulesFile, "sc-rules", "", "JSON file with storage class rules by path pattern, size, mtime age and owner, first match wins, '-sc' is used when nothing matches")
+
+	fs.IntVar(&listProcs, "list-procs", 16, "Number of concurrent ListObjectsV2 requests when listing S3, sub-prefixes are listed as separate shards, '1' lists sequentially")
+	fs.IntVar(&walkProcs, "walk-procs", 16, "Number of directories that are read concurrently when walking a local source or destination")
+	fs.IntVar(&headProcs, "head-procs", 32, "Number of concurrent HeadObject requests that read object metadata after listing S3")
+	jobFs.IntVar(&checkBatch, "check-batch", 100000, "Number of entries per side that are sorted in memory during the check, larger listings are sorted in temporary runs under the data directory and merged")
+	fs.IntVar(&listDepth, "list-depth", 2, "Number of prefix levels that are split into shards with delimiter listing for '-list-procs'")
+	fs.StringVar(&srcInventory, "inventory", "", "S3 Inventory manifest.json of the source bucket, local or 's3://bucket/key', used instead of listing the source in the copy and check phases. CSV, ORC and Parquet reports are supported")
+	fs.StringVar(&filesFrom, "files-from", "", "Only copy the paths or keys listed in this manifest instead of walking or listing the source: plain text, '.csv' or '.jsonl' with optional destination, size and version per line, '-' reads stdin")
+
+	syncFs.BoolVar(&watchMode, "watch", false, "F2O and F2F: after the sync, keep watching the source with inotify and sync changed, renamed and deleted paths until SIGINT or SIGTERM")
+	syncFs.DurationVar(&watchDelay, "watch-delay", 2*time.Second, "With '-watch', wait until a path has no new events for this long before syncing it")
+	syncFs.DurationVar(&watchRescan, "watch-rescan", 10*time.Minute, "With '-watch', interval of full rescans after inotify events were lost")
+	syncFs.DurationVar(&watchSave, "watch-save", time.Minute, "With '-watch', interval of writing the job file, the state of synced paths is kept in memory in between")
+	fs.Var(&includePatterns, "include", "Only copy and check files matching this pattern, can be repeated. A pattern without '/' matches the file name, with '/' the relative path, a matching directory selects everything below it")
+	fs.Var(&excludePatterns, "exclude", "Skip files and directories matching this pattern, can be repeated, same format as '-include' and applied first")
+
+	for _, arg := range defaults {
+		name, value, _ := strings.Cut(strings.TrimPrefix(arg, "-"), "=")
+		if fs.Lookup(name) == nil {
+			if hidden.Lookup(name) == nil {
+				log.Fatalln("Unknown option in job file defaults:", name)
+			}
+			continue //这个命令不使用的参数
+		}
+		if err := fs.Set(name, value); err != nil {
+			log.Fatalln("Invalid value of option", name, "in job file defaults:", err)
+		}
+	}
+	fs.Parse(args) //Parse函数要在参数定义之后解析
 
 	if isInitialCopyStr == "true" {
 		isInitialCopy = true
@@ -75,20 +215,116 @@ func init() {
 		log.Fatalln("For option '-a', only 'true' or 'false' are allowed")
 	}
 
+	if cmd == "cp" {
+		isInitialCopy = true //cp不使用上次的结果，总是完整拷贝
+	}
+
 	if !(check == "nocheck" || check == "attr" || check == "md5") {
 		log.Fatalln("For option '-c', only 'nocheck', 'attr', 'md5' are allowed")
 	}
+	if cmd == "verify" && check == "nocheck" {
+		log.Fatalln("For option '-c' of verify, only 'attr', 'md5' are allowed")
+	}
+
+	if idMapFile != "" {
+		idMap = LoadIDMap(idMapFile)
+	}
+
+	if !(contentTypeMode == "auto" || contentTypeMode == "ext" || contentTypeMode == "none") {
+		log.Fatalln("For option '-content-type', only 'auto', 'ext', 'none' are allowed")
+	}
+
+	if headerRulesFile != "" {
+		headerRules = LoadHeaderRules(headerRulesFile)
+	}
+
+	if o2oReplaceHeadersStr == "true" {
+		o2oReplaceHeaders = true
+	} else if o2oReplaceHeadersStr == "false" {
+		o2oReplaceHeaders = false
+	} else {
+		log.Fatalln("For option '-o2o-headers', only 'true' or 'false' are allowed")
+	}
+
+	if !(sse == "" || sse == "AES256" || sse == "aws:kms") {
+		log.Fatalln("For option '-sse', only 'AES256', 'aws:kms' are allowed")
+	}
+	if !(sseBucketKeyStr == "true" || sseBucketKeyStr == "false") {
+		log.Fatalln("For option '-sse-bucket-key', only 'true' or 'false' are allowed")
+	}
+	if sse != "aws:kms" && (sseKMSKeyID != "" || sseContext != "" || sseBucketKeyStr == "true") {
+		log.Fatalln("Options '-sse-kms-key-id', '-sse-context' and '-sse-bucket-key' require '-sse aws:kms'")
+	}
+	if sseCKeyFile != "" && sse != "" {
+		log.Fatalln("Option '-sse-c-key' can not be used together with '-sse'")
+	}
+	if sse != "" || sseCKeyFile != "" {
+		dstSSE = &SSEConfig{Mode: sse, KMSKeyID: sseKMSKeyID, Context: EncodeSSEContext(sseContext), BucketKey: sseBucketKeyStr == "true"}
+		if sseCKeyFile != "" {
+			dstSSE.CustomerKey = LoadSSECKey(sseCKeyFile)
+		}
+	}
+	if srcSSECKeyFile != "" {
+		srcSSE = &SSEConfig{CustomerKey: LoadSSECKey(srcSSECKeyFile)}
+	}
+
+	if cseKeyFile != "" {
+		cseKey = LoadCSEKey(cseKeyFile)
+	}
+
+	if !validCompressCodec(compressCodec) {
+		log.Fatalln("For option '-compress', only 'zstd', 'gzip' are allowed")
+	}
+	compressSkip = ParseCompressSkip(compressSkipStr)
+
+	if !(tagDirective == "COPY" || tagDirective == "REPLACE") {
+		log.Fatalln("For option '-tag-directive', only 'COPY', 'REPLACE' are allowed")
+	}
+	if !(checkTagsStr == "true" || checkTagsStr == "false") {
+		log.Fatalln("For option '-check-tags', only 'true' or 'false' are allowed")
+	}
+	checkTags = checkTagsStr == "true"
+
+	if !validNameEncoding(nameEncoding) {
+		log.Fatalln("For option '-name-encoding', only 'none', 'percent' are allowed")
+	}
+	if !validNormalize(unicodeNormalize) {
+		log.Fatalln("For option '-unicode-normalize', only 'nfc', 'nfd' are allowed")
+	}
+	if renameRulesFile != "" {
+		renameRules = LoadRenameRules(renameRulesFile)
+	}
+	if scRulesFile != "" {
+		storageClassRules = LoadStorageClassRules(scRulesFile)
+	}
+
+	packThreshold = ParseSize(packThresholdStr, "-pack-threshold")
+	packSize = ParseSize(packSizeStr, "-pack-size")
+	if packThreshold > 0 && packSize <= 0 {
+		log.Fatalln("Option '-pack-size' must be greater than 0")
+	}
+	if packThreshold > 0 && cseKey != nil {
+		log.Fatalln("Option '-pack-threshold' can not be used together with '-cse-key'")
+	}
+
+	if !validMetadataFormat(metadataFormat) {
+		log.Fatalln("For option '-metadata-format', only 'auto', 'admt', 's3fs', 'rclone', 'mountpoint' are allowed")
+	}
 
 	if !(checkMode == "full" || checkMode == "incr") {
 		log.Fatalln("For option '-t', only 'incr', 'full' are allowed")
 	}
 
-	if flag.NArg() != 2 {
-		log.Fatalln("Usage:", os.Args[0], "[options] <Source Path> <Destination Path>")
+	paths := fs.Args()
+	if cmd == "ls" && len(paths) == 1 {
+		paths = append(paths, "/") //ls只有源端，按拷贝到本地目录解析
 	}
-
-	srcPath = flag.Arg(0)
-	dstPath = flag.Arg(1)
+	if len(paths) != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	srcPath = paths[0]
+	dstPath = paths[1]
 
 	endingWithDash, _ := regexp.MatchString("/$", srcPath)
 	if endingWithDash == false {
@@ -102,75 +338,271 @@ func init() {
 
 	mode, srcBucket, srcPrefix, dstBucket, dstPrefix = ParseArgs(srcPath, dstPath)
 
-	dataDir = "/tmp/dataDir/"
-	jobDir = "/tmp/jobDir/"
+	//归档文件按扩展名识别，本地路径的f替换为a, 例如f2o变为a2o
+	srcArchiveFormat = archiveFormat(paths[0])
+	dstArchiveFormat = archiveFormat(paths[1])
+	if srcArchiveFormat != "" && dstArchiveFormat != "" {
+		log.Fatalln("Source and destination can not both be archives")
+	}
+	if srcArchiveFormat != "" {
+		srcArchive = paths[0]
+		mode = "a" + mode[1:]
+	}
+	if dstArchiveFormat != "" {
+		dstArchive = paths[1]
+		mode = mode[:2] + "a"
+	}
+	if walkProcs < 1 {
+		log.Fatalln("Option '-walk-procs' must be at least 1")
+	}
+	if checkBatch < 1 {
+		log.Fatalln("Option '-check-batch' must be at least 1")
+	}
+	if headProcs < 1 {
+		log.Fatalln("Option '-head-procs' must be at least 1")
+	}
+	if listProcs < 1 || listDepth < 0 {
+		log.Fatalln("Option '-list-procs' must be at least 1 and '-list-depth' can not be negative")
+	}
+	if srcInventory != "" && !strings.HasPrefix(mode, "o2") {
+		log.Fatalln("Option '-inventory' requires an S3 source")
+	}
+	if srcInventory != "" && filesFrom != "" {
+		log.Fatalln("Option '-inventory' can not be used together with '-files-from'")
+	}
+	if filesFrom != "" && srcArchive != "" {
+		log.Fatalln("Option '-files-from' can not be used with an archive source")
+	}
+	if watchMode {
+		if !(mode == "f2o" || mode == "f2f") || filesFrom != "" || packThreshold > 0 {
+			log.Fatalln("Option '-watch' only supports f2o and f2f without '-files-from' and '-pack-threshold'")
+		}
+		if check == "nocheck" {
+			log.Fatalln("Option '-watch' requires '-c attr' or '-c md5', the check result is the state that changes are compared with")
+		}
+		if watchDelay < 0 || watchRescan < time.Second || watchSave < 0 {
+			log.Fatalln("Option '-watch-delay' and '-watch-save' can not be negative and '-watch-rescan' must be at least 1s")
+		}
+	}
+	if isArchiveMode() {
+		if cmd == "ls" || cmd == "diff" {
+			log.Fatalln("Archive source or destination is not supported by", cmd)
+		}
+		if check != "nocheck" {
+			log.Fatalln("Option '-c' is not supported for archive source or destination")
+		}
+		isInitialCopy = true //归档总是完整拷贝
+	}
+
 	CreateTempDir(dataDir, jobDir)
 }
 
 func main() {
+	dataDir = "/tmp/dataDir/"
+	jobDir = "/tmp/jobDir/"
+
+	cmd, args := parseCommand(os.Args[1:])
+	switch cmd {
+	case "status":
+		Status(args)
+		return
+	case "clean":
+		Clean(args)
+		return
+	}
+	if (cmd == "sync" || cmd == "cp" || cmd == "verify" || cmd == "job" || cmd == "serve") && os.Getenv(jobTransferEnv) == "" {
+		centerPrint(150, "Written by 王大伟, Welcome any feedback to login:awsdawei@, WeChat: 374727961", "*")
+	}
+	if cmd == "job" || cmd == "serve" {
+		if cmd == "job" {
+			RunJobSpec(args)
+		} else {
+			Serve(args)
+		}
+		os.RemoveAll(dataDir)
+		return
+	}
+	parseOptions(cmd, args, nil)
 	defer os.RemoveAll(dataDir)
 
+	switch cmd {
+	case "ls":
+		List()
+	case "diff":
+		if !Diff() {
+			os.RemoveAll(dataDir)
+			os.Exit(1) //和diff命令一样，有差异时返回1
+		}
+	default:
+		var w *watcher
+		if watchMode {
+			w = startWatch(srcPath)
+		}
+		runJob(cmd)
+		if w != nil {
+			w.Run(jobFileName(srcPath, dstPath))
+		}
+	}
+}
+
+// 一次runJob的结果，job文件中的每个transfer汇总到一个报告
+type jobResult struct {
+	Start, End    time.Time
+	Listed        int64 //放入拷贝队列的条目数
+	Success, Fail int   //检查的结果，不检查时为0
+}
+
+// sync, cp, verify: 拷贝后按-c检查，verify跳过拷贝
+func runJob(cmd string) jobResult {
+	return runJobState(cmd, jobFileName(srcPath, dstPath))
+}
+
+// 使用指定的job文件运行，-watch的每一批变化使用只包含这一批的临时job文件
+func runJobState(cmd string, jobFile string) (result jobResult) {
+
 	start := time.Now()
+	result.Start = start
 	defer func() {
+		result.End = time.Now()
 		centerPrint(100, "Job Completion Summary", "*")
 		layout := "2006-01-02 15:04:05"
 		fmt.Println("Start time     :", start.Format(layout))
 		fmt.Println("Completion time:", time.Now().Format(layout))
 		fmt.Printf("Total copy time: %.2f \n", time.Since(start).Seconds())
+		printStorageClassSummary()
+		printNameReport()
 	}()
 
-	//构造timefile的文件名
-	strlist := strings.Split(srcPath, "/")
-	srcjob := strings.Join(strlist, "")
-	strlist = strings.Split(dstPath, "/")
-	dstjob := strings.Join(strlist, "")
-	jobFile := "_" + srcjob + "_" + dstjob
-	jobFile = filepath.Join(jobDir, jobFile)
 	if isInitialCopy {
 		os.RemoveAll(jobFile)
+		os.RemoveAll(renameFile(jobFile))
 	}
+	LoadRenameState(jobFile) //改名的对应关系和job文件保存在一起
+	defer SaveRenameState(jobFile)
 
 	walker := FileWalk{
 		make(chan FileInfo, 100000), //注意这里设置缓冲区，不然会死锁
 		isInitialCopy,
-		readLastTimeCopyInfo(jobFile),
-		map[string]FileInfo{},
-		map[string]FileInfo{},
-		map[string]FileInfo{},
+		nil,
+		nil, //拷贝时不使用检查的列表
+		nil,
 		srcPath,
 		dstPath,
 		defaultFileMode,
 		withAttr,
 	}
-	if mode == "f2o" || mode == "f2f" {
-		go func() {
-			// Gather the files to upload by walking the path recursively
-			if err := filepath.Walk(srcPath, walker.Walk); err != nil {
-				log.Fatalln("Walk failed:", err)
-			}
-			close(walker.FileList)
+	procs := factor * runtime.NumCPU()
+	runtime.GOMAXPROCS(procs)
+
+	if cmd != "verify" { //verify只检查，不拷贝
+		walker.FileMap = readLastTimeCopyInfo(jobFile) //增量拷贝跳过上次检查通过的文件，检查时不使用FileMap
+		result.Listed = runCopy(walker, procs)
+		walker.FileMap = nil
+	}
+
+	//////////////////////////////////////////////////////////////////////////////////////////////
+	//迁移后检查
+
+	if checkMode == "full" && (check == "attr" || check == "md5") {
+
+		// atrributes check检查计时
+		centerPrint(100, "Starting Check between Source and Destination", "*")
+		checkStart := time.Now()
+
+		checker := FileWalk{
+			make(chan FileInfo, 100000), //注意这里设置缓冲区，不然会死锁
+			isInitialCopy,
+			nil,
+			newCheckSorter(),
+			newCheckSorter(),
+			srcPath,
+			dstPath,
+			defaultFileMode,
+			withAttr,
+		}
+
+		checker.GetCheck(false)
+
+		report := newCheckReport(jobFile, false)
+		checker.Verify(report, procs, nil)
+
+		// atrributes check检查计时
+		centerPrint(100, "Full Check between Source and Destination Completion", "*")
+		centerPrint(50, "Files which fail to pass check", "+")
+		success, fail := report.Result()
+		centerPrint(50, "", "+")
+
+		fmt.Printf("File check success: %d, File check fail: %d \n", success, fail)
+		result.Success, result.Fail = success, fail
+		func() {
+			layout := "2006-01-02 15:04:05"
+			fmt.Println("File check start time     :", checkStart.Format(layout))
+			fmt.Println("File check completion time:", time.Now().Format(layout))
+			fmt.Printf("Total check time : %.2f \n", time.Since(checkStart).Seconds())
 		}()
+		report.Save()
+
 	}
-	if mode == "o2f" || mode == "o2o" {
 
-		go func() {
-			client := CreateS3Client(region)
-			walker.Listobj(client, srcBucket, srcPrefix)
-			close(walker.FileList)
+	//Incr模式
+	if checkMode == "incr" && (check == "attr" || check == "md5") {
 
+		// atrributes check检查计时
+		centerPrint(100, "Starting Check between Source and Destination", "*")
+		checkStart := time.Now()
+
+		checker := FileWalk{
+			make(chan FileInfo, 100000), //注意这里设置缓冲区，不然会死锁
+			isInitialCopy,
+			nil,
+			newCheckSorter(),
+			newCheckSorter(),
+			srcPath,
+			dstPath,
+			defaultFileMode,
+			withAttr,
+		}
+
+		checker.GetCheck(true)
+
+		report := newCheckReport(jobFile, true)
+		checker.Verify(report, procs, readJobState(jobFile, dstNameOf)) //上次的结果作为第三个有序的流
+
+		// atrributes check检查计时
+		centerPrint(100, "Incremental Check between Source and Destination Completion", "*")
+		centerPrint(50, "Files which fail to pass check", "+")
+		success, fail := report.Result()
+		centerPrint(50, "", "+")
+
+		fmt.Printf("File check success: %d, File check fail: %d \n", success, fail)
+		result.Success, result.Fail = success, fail
+		func() {
+			layout := "2006-01-02 15:04:05"
+			fmt.Println("File check start time     :", checkStart.Format(layout))
+			fmt.Println("File check completion time:", time.Now().Format(layout))
+			fmt.Printf("Total check time : %.2f \n", time.Since(checkStart).Seconds())
 		}()
+		report.Save() //incr模式合并到上次的结果
+
 	}
+	return result
+}
+
+// 拷贝阶段，源端的列表由producer放入walker.FileList, procs个goroutine拷贝，返回拷贝的条目数
+func runCopy(walker FileWalk, procs int) int64 {
+	listed := startListing(walker)
 
 	centerPrint(100, "File Copy is Starting", "*")
 	fileCopyStart := time.Now()
 
 	var wg sync.WaitGroup
 
-	procs := factor * runtime.NumCPU()
-	runtime.GOMAXPROCS(procs)
-
 	wg.Add(procs)
+	var packer *Packer
 	if mode == "f2o" {
+		if packThreshold > 0 {
+			packer = NewPacker(CreateS3Client(region), srcPath, dstBucket, dstPrefix)
+		}
 
 		for i := 0; i < procs; i++ {
 
@@ -179,15 +611,24 @@ func main() {
 				client := CreateS3Client(region)
 
 				for info := range walker.FileList {
+					if skipName(info) {
+						continue //目标端无法表示的名字，见-name-encoding
+					}
+					sharedWorkers.Acquire() //job文件中同时运行的transfer共用worker名额
 					if info.FType == "0040" {
 						F2O_DirCopy(client, info, srcPath, dstBucket, dstPrefix)
 					}
 					if info.FType == "0120" {
-						F2O_SymCopy(client, info, srcPath, dstBucket, dstPrefix, storageClass)
+						F2O_SymCopy(client, info, srcPath, dstBucket, dstPrefix, SelectStorageClass(info))
 					}
 					if info.FType == "0100" {
-						F2O_RegCopy(client, info, srcPath, dstBucket, dstPrefix, storageClass, partSize)
+						if packer != nil && shouldPack(info) {
+							packer.Add(info)
+						} else {
+							F2O_RegCopy(client, info, srcPath, dstBucket, dstPrefix, SelectStorageClass(info), partSize)
+						}
 					}
+					sharedWorkers.Release()
 				}
 			}()
 		}
@@ -201,6 +642,10 @@ func main() {
 				client := CreateS3Client(region)
 
 				for info := range walker.FileList {
+					if skipName(info) {
+						continue //目标端无法表示的名字，见-name-encoding
+					}
+					sharedWorkers.Acquire()
 					if info.FType == "0040" {
 						O2F_DirCopy(client, info, srcBucket, srcPrefix, dstPath, defaultFileMode)
 					}
@@ -210,6 +655,7 @@ func main() {
 					if info.FType == "0100" {
 						O2F_RegCopy(client, info, srcBucket, srcPrefix, dstPath, partSize, defaultFileMode)
 					}
+					sharedWorkers.Release()
 
 				}
 			}()
@@ -221,6 +667,10 @@ func main() {
 			go func() {
 				defer wg.Done()
 				for info := range walker.FileList {
+					if skipName(info) {
+						continue //目标端无法表示的名字，见-name-encoding
+					}
+					sharedWorkers.Acquire()
 					if info.FType == "0040" {
 						F2F_DirCopy(info, srcPath, dstPath, defaultFileMode)
 					}
@@ -230,11 +680,16 @@ func main() {
 					if info.FType == "0100" {
 						F2F_RegCopy(info, srcPath, dstPath, partSize, defaultFileMode)
 					}
+					sharedWorkers.Release()
 				}
 			}()
 		}
 	}
 	if mode == "o2o" {
+		srcXattrSidecars = nil
+		if withAttr {
+			srcXattrSidecars = listXattrSidecars(CreateS3Client(region), srcBucket, srcPrefix)
+		}
 		//一个client一个TCP连接，所以要把client放到goroutine里面，这样可以建立多个tcp连接
 
 		for i := 0; i < procs; i++ {
@@ -244,311 +699,148 @@ func main() {
 				client := CreateS3Client(region)
 
 				for info := range walker.FileList {
+					if skipName(info) {
+						continue //目标端无法表示的名字，见-name-encoding
+					}
+					sharedWorkers.Acquire()
 					if info.FType == "0040" {
-						O2O_ObjectCopy(client, info, srcBucket, srcPrefix, dstBucket, storageClass, dstPrefix)
+						O2O_ObjectCopy(client, info, srcBucket, srcPrefix, dstBucket, dstPrefix, SelectStorageClass(info))
 					}
 					if info.FType == "0120" {
-						O2O_ObjectCopy(client, info, srcBucket, srcPrefix, dstBucket, storageClass, dstPrefix)
+						O2O_ObjectCopy(client, info, srcBucket, srcPrefix, dstBucket, dstPrefix, SelectStorageClass(info))
 					}
 					if info.FType == "0100" {
-						O2O_ObjectCopy(client, info, srcBucket, srcPrefix, dstBucket, dstPrefix, storageClass)
+						O2O_ObjectCopy(client, info, srcBucket, srcPrefix, dstBucket, dstPrefix, SelectStorageClass(info))
 					}
+					sharedWorkers.Release()
 				}
 			}()
 		}
 	}
 
-	wg.Wait()
-
-	centerPrint(100, "File Copy Completion", "*")
-	func() {
-		layout := "2006-01-02 15:04:05"
-		fmt.Println("File copy start time     :", fileCopyStart.Format(layout))
-		fmt.Println("File copy completion time:", time.Now().Format(layout))
-		fmt.Printf("Total copy time : %.2f \n", time.Since(fileCopyStart).Seconds())
-	}()
-
-	//////////////////////////////////////////////////////////////////////////////////////////////
-	//迁移后检查
-
-	if checkMode == "full" && (check == "attr" || check == "md5") {
-
-		// atrributes check检查计时
-		centerPrint(100, "Starting Check between Source and Destination", "*")
-		checkStart := time.Now()
-
-		checker := FileWalk{
-			make(chan FileInfo, 100000), //注意这里设置缓冲区，不然会死锁
-			isInitialCopy,
-			walker.FileMap,
-			map[string]FileInfo{},
-			map[string]FileInfo{},
-			map[string]FileInfo{},
-			srcPath,
-			dstPath,
-			defaultFileMode,
-			withAttr,
-		}
-
-		if mode == "f2o" {
-			client := CreateS3Client(region)
-			checker.F2O_GetCheck(client, srcPath, dstBucket, dstPrefix)
-
-		}
-		if mode == "f2f" {
-			checker.F2F_GetCheck(srcPath, dstPath)
-
-		}
-		if mode == "o2f" {
-			client := CreateS3Client(region)
-			checker.O2F_GetCheck(client, srcBucket, srcPrefix, dstPath)
-
-		}
-		if mode == "o2o" {
-			client := CreateS3Client(region)
-			checker.O2O_GetCheck(client, srcBucket, srcPrefix, dstBucket, dstPrefix)
-
+	if mode == "a2o" || mode == "a2f" {
+		if mode == "a2f" {
+			a2fGuard = newExtractGuard(dstPath)
 		}
+		//tar只能顺序读取，读取的goroutine把条目分发给worker
+		entries := make(chan ArchiveEntry, procs)
+		go func() {
+			client := archiveClient()
+			DispatchArchive(srcArchive, srcArchiveFormat, entries, func(entry ArchiveEntry) {
+				sharedWorkers.Acquire()
+				CopyArchiveEntry(client, entry)
+				sharedWorkers.Release()
+			})
+			close(entries)
+		}()
 
-		if check == "md5" {
-
-			var wg1 sync.WaitGroup
-			wg1.Add(procs)
-
-			var AttrResultList = make(chan FileInfo, 10000000)
-			var ResultList = make(chan FileInfo, 10000000)
-			var StopSingal = make(chan int, 1)
-			StopSingal <- 0
-
+		for i := 0; i < procs; i++ {
 			go func() {
-				for _, info := range checker.ResultMap {
-					AttrResultList <- info
+				defer wg.Done()
+				client := archiveClient()
+
+				for entry := range entries {
+					sharedWorkers.Acquire()
+					CopyArchiveEntry(client, entry)
+					sharedWorkers.Release()
 				}
-				close(AttrResultList)
 			}()
-
-			for i := 0; i < procs; i++ {
-				go func() {
-					defer wg1.Done()
-					defer func() {
-						count := <-StopSingal
-						if count == procs-1 {
-							close(ResultList)
-						} else {
-							count++
-							StopSingal <- count
-						}
-					}()
-					client := CreateS3Client(region)
-
-					for info := range AttrResultList {
-
-						//如果为目录或软链接，不进行md5比较，直接认为通过
-						if info.FType == "0040" || info.FType == "0120" {
-							info.CStatus.CopyStatus = "checkPass"
-						} else {
-							var srcMD5 []byte
-							var dstMD5 []byte
-							if mode == "f2o" {
-								srcMD5 = MD5File(srcPath + info.Filename)
-								dstMD5 = MD5Obj(client, dstBucket, pathJoin(dstPrefix, info.Filename), partSize)
-							}
-							if mode == "o2f" {
-								srcMD5 = MD5Obj(client, srcBucket, pathJoin(srcPrefix, info.Filename), partSize)
-								dstMD5 = MD5File(dstPath + info.Filename)
-							}
-							if mode == "f2f" {
-								srcMD5 = MD5File(srcPath + info.Filename)
-								dstMD5 = MD5File(dstPath + info.Filename)
-							}
-							if mode == "o2o" {
-								srcMD5 = MD5Obj(client, srcBucket, pathJoin(srcPrefix, info.Filename), partSize)
-								dstMD5 = MD5Obj(client, dstBucket, pathJoin(dstPrefix, info.Filename), partSize)
-							}
-
-							if bytes.Compare(srcMD5, dstMD5) == 0 {
-								fmt.Printf("%-23s%s\n", "MD5 check pass: ", info.Filename)
-								info.CStatus.CopyStatus = "checkPass"
-
-							} else {
-								fmt.Printf("%-23s%s\n", "MD5 check fail: ", info.Filename)
-								info.CStatus.CopyStatus = "checkFail"
-							}
-							ResultList <- info
-
-						}
-					}
-
-				}()
-			}
-			//这里必须要make一个新的临时map，如果直接写checker.ResultMap,会出现concurrent map read and write的问题
-
-			md5ResultMap := make(map[string]FileInfo)
-			for info := range ResultList {
-				md5ResultMap[info.Filename] = info
-			}
-			wg1.Wait()
-			for filename, info := range md5ResultMap {
-				checker.ResultMap[filename] = info
-			}
-
 		}
-
-		// atrributes check检查计时
-		centerPrint(100, "Full Check between Source and Destination Completion", "*")
-		centerPrint(50, "Files which fail to pass check", "+")
-		success, fail := getResult(&checker.ResultMap, "checkPass", "checkFail")
-		centerPrint(50, "", "+")
-
-		fmt.Printf("File check success: %d, File check fail: %d \n", success, fail)
-		func() {
-			layout := "2006-01-02 15:04:05"
-			fmt.Println("File check start time     :", checkStart.Format(layout))
-			fmt.Println("File check completion time:", time.Now().Format(layout))
-			fmt.Printf("Total check time : %.2f \n", time.Since(checkStart).Seconds())
-		}()
-		collectCheckInfo(jobFile, &checker.ResultMap)
-
 	}
+	var archive *ArchiveWriter
+	if mode == "o2a" || mode == "f2a" {
+		archive = NewArchiveWriter(dstArchive, dstArchiveFormat)
 
-	//Incr模式
-	if checkMode == "incr" && (check == "attr" || check == "md5") {
-
-		// atrributes check检查计时
-		centerPrint(100, "Starting Check between Source and Destination", "*")
-		checkStart := time.Now()
-
-		checker := FileWalk{
-			make(chan FileInfo, 100000), //注意这里设置缓冲区，不然会死锁
-			isInitialCopy,
-			walker.FileMap,
-			map[string]FileInfo{},
-			map[string]FileInfo{},
-			map[string]FileInfo{},
-			srcPath,
-			dstPath,
-			defaultFileMode,
-			withAttr,
-		}
-
-		if mode == "f2o" {
-			client := CreateS3Client(region)
-			checker.F2O_GetIncrCheck(client, srcPath, dstBucket, dstPrefix)
-
-		}
-		if mode == "f2f" {
-			checker.F2F_GetIncrCheck(srcPath, dstPath)
-
-		}
-		if mode == "o2f" {
-			client := CreateS3Client(region)
-			checker.O2F_GetIncrCheck(client, srcBucket, srcPrefix, dstPath)
-
-		}
-		if mode == "o2o" {
-			client := CreateS3Client(region)
-			checker.O2O_GetIncrCheck(client, srcBucket, srcPrefix, dstBucket, dstPrefix)
-
-		}
-
-		if check == "md5" {
-
-			var wg1 sync.WaitGroup
-			wg1.Add(procs)
-
-			var AttrResultList = make(chan FileInfo, 10000000)
-			var ResultList = make(chan FileInfo, 10000000)
-			var StopSingal = make(chan int, 1)
-			StopSingal <- 0
-
+		for i := 0; i < procs; i++ {
 			go func() {
-				for _, info := range checker.ResultMap {
-					AttrResultList <- info
+				defer wg.Done()
+				client := archiveClient()
+
+				for info := range walker.FileList {
+					if skipName(info) {
+						continue //目标端无法表示的名字，见-name-encoding
+					}
+					sharedWorkers.Acquire()
+					if mode == "o2a" {
+						O2A_Copy(client, info, srcBucket, srcPrefix, archive, partSize)
+					} else {
+						F2A_Copy(info, srcPath, archive)
+					}
+					sharedWorkers.Release()
 				}
-				close(AttrResultList)
 			}()
+		}
+	}
 
-			for i := 0; i < procs; i++ {
-				go func() {
-					defer wg1.Done()
-					defer func() {
-						count := <-StopSingal
-						if count == procs-1 {
-							close(ResultList)
-						} else {
-							count++
-							StopSingal <- count
-						}
-					}()
-					client := CreateS3Client(region)
-
-					for info := range AttrResultList {
-
-						//如果为目录或软链接，不进行md5比较，直接认为通过
-						if info.FType == "0040" || info.FType == "0120" {
-							info.CStatus.CopyStatus = "checkPass"
-						} else {
-							var srcMD5 []byte
-							var dstMD5 []byte
-							if mode == "f2o" {
-								srcMD5 = MD5File(srcPath + info.Filename)
-								dstMD5 = MD5Obj(client, dstBucket, pathJoin(dstPrefix, info.Filename), partSize)
-							}
-							if mode == "o2f" {
-								srcMD5 = MD5Obj(client, srcBucket, pathJoin(srcPrefix, info.Filename), partSize)
-								dstMD5 = MD5File(dstPath + info.Filename)
-							}
-							if mode == "f2f" {
-								srcMD5 = MD5File(srcPath + info.Filename)
-								dstMD5 = MD5File(dstPath + info.Filename)
-							}
-							if mode == "o2o" {
-								srcMD5 = MD5Obj(client, srcBucket, pathJoin(srcPrefix, info.Filename), partSize)
-								dstMD5 = MD5Obj(client, dstBucket, pathJoin(dstPrefix, info.Filename), partSize)
-							}
-
-							if bytes.Compare(srcMD5, dstMD5) == 0 {
-								fmt.Printf("%-23s%s\n", "MD5 check pass: ", info.Filename)
-								info.CStatus.CopyStatus = "checkPass"
-
-							} else {
-								fmt.Printf("%-23s%s\n", "MD5 check fail: ", info.Filename)
-								info.CStatus.CopyStatus = "checkFail"
-							}
-							ResultList <- info
+	wg.Wait()
+	if archive != nil {
+		archive.Close()
+	}
+	if packer != nil {
+		packer.Close() //上传所有未满的bundle
+	}
 
-						}
-					}
+	centerPrint(100, "File Copy Completion", "*")
+	func() {
+		layout := "2006-01-02 15:04:05"
+		fmt.Println("File copy start time     :", fileCopyStart.Format(layout))
+		fmt.Println("File copy completion time:", time.Now().Format(layout))
+		fmt.Printf("Total copy time : %.2f \n", time.Since(fileCopyStart).Seconds())
+	}()
+	return *listed
+}
 
-				}()
+// 在goroutine中列出源端放入walker.FileList，完成后关闭FileList
+// 返回放入FileList的条目数，FileList关闭后有效
+func startListing(walker FileWalk) *int64 {
+	//producer写入listed, 再按-include, -exclude过滤后放入walker.FileList
+	listed := walker
+	listed.FileList = make(chan FileInfo, cap(walker.FileList))
+	var count int64
+	go func() {
+		for info := range listed.FileList {
+			if selected(info.Filename) {
+				walker.FileList <- info
+				count++
 			}
-			//这里必须要make一个新的临时map，如果直接写checker.ResultMap,会出现concurrent map read and write的问题
+		}
+		close(walker.FileList)
+	}()
 
-			md5ResultMap := make(map[string]FileInfo)
-			for info := range ResultList {
-				md5ResultMap[info.Filename] = info
+	if filesFrom != "" {
+		go func() {
+			//清单代替扫描源目录或列出源bucket
+			var client *s3.Client
+			if strings.HasPrefix(mode, "o2") {
+				client = CreateS3Client(region)
 			}
-			wg1.Wait()
-			for filename, info := range md5ResultMap {
-				checker.ResultMap[filename] = info
+			listed.ListManifest(client, filesFrom)
+			close(listed.FileList)
+		}()
+	} else if mode == "f2o" || mode == "f2f" || mode == "f2a" {
+		go func() {
+			// Gather the files to upload by walking the path recursively
+			if err := ParallelWalk(srcPath, listed.Walk); err != nil {
+				log.Fatalln("Walk failed:", err)
 			}
-		}
+			close(listed.FileList)
+		}()
+	} else if srcInventory != "" {
+		go func() {
+			client := CreateS3Client(region)
+			listed.ListInventory(client, srcInventory)
+			close(listed.FileList)
+		}()
+	} else if mode == "o2f" || mode == "o2o" || mode == "o2a" {
 
-		// atrributes check检查计时
-		centerPrint(100, "Incremental Check between Source and Destination Completion", "*")
-		centerPrint(50, "Files which fail to pass check", "+")
-		success, fail := getResult(&checker.ResultMap, "checkPass", "checkFail")
-		centerPrint(50, "", "+")
+		go func() {
+			client := CreateS3Client(region)
+			listed.Listobj(client, srcBucket, srcPrefix)
+			close(listed.FileList)
 
-		fmt.Printf("File check success: %d, File check fail: %d \n", success, fail)
-		func() {
-			layout := "2006-01-02 15:04:05"
-			fmt.Println("File check start time     :", checkStart.Format(layout))
-			fmt.Println("File check completion time:", time.Now().Format(layout))
-			fmt.Printf("Total check time : %.2f \n", time.Since(checkStart).Seconds())
 		}()
-		collectIncrCheckInfo(jobFile, &walker.FileMap, &checker.ResultMap) //这里是full模式用的函数不一样
-
+	} else {
+		close(listed.FileList) //a2o, a2f直接读取归档
 	}
-
+	return &count
 }